@@ -0,0 +1,77 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+locals {
+  name = "hacluster"
+}
+
+resource "grid_network" "net1" {
+  name     = local.name
+  nodes    = [2, 4, 5]
+  ip_range = "10.1.0.0/16"
+}
+
+data "grid_gateway_domain" "domain" {
+  node = 7
+  name = local.name
+}
+
+resource "grid_kubernetes" "k8s1" {
+  name         = local.name
+  network_name = grid_network.net1.name
+  token        = "12345678910122"
+  ssh_key      = "PUT YOUR SSH KEY HERE"
+  # the workers reach the masters through the gateway below
+  api_endpoint = "${data.grid_gateway_domain.domain.fqdn}:443"
+
+  masters {
+    name      = "m1"
+    node      = 2
+    disk_size = 20
+    cpu       = 2
+    memory    = 2048
+    planetary = true
+  }
+  masters {
+    name      = "m2"
+    node      = 4
+    disk_size = 20
+    cpu       = 2
+    memory    = 2048
+    planetary = true
+  }
+  masters {
+    name      = "m3"
+    node      = 5
+    disk_size = 20
+    cpu       = 2
+    memory    = 2048
+    planetary = true
+  }
+  workers {
+    name      = "w1"
+    node      = 4
+    disk_size = 15
+    cpu       = 2
+    memory    = 2048
+  }
+}
+
+resource "grid_name_proxy" "api" {
+  node            = 7
+  name            = local.name
+  tls_passthrough = true
+  backends        = [for m in grid_kubernetes.k8s1.masters : format("https://[%s]:6443", m.ygg_ip)]
+}
+
+output "api_endpoint" {
+  value = grid_kubernetes.k8s1.api_endpoint
+}
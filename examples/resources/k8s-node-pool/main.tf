@@ -0,0 +1,55 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+resource "grid_network" "net1" {
+  name        = "poolnetwork"
+  nodes       = [2]
+  ip_range    = "10.1.0.0/16"
+  auto_extend = true
+}
+
+resource "grid_kubernetes" "k8s1" {
+  name         = "poolcluster"
+  network_name = grid_network.net1.name
+  token        = "12345678910122"
+  ssh_key      = "PUT YOUR SSH KEY HERE"
+  k3s_version  = "stable"
+  disable      = ["traefik"]
+
+  rolling_update {
+    max_unavailable = 1
+    wait_for_ready  = true
+  }
+
+  master {
+    name      = "mr"
+    node      = 2
+    disk_size = 20
+    cpu       = 2
+    memory    = 2048
+    planetary = true
+  }
+  node_pool {
+    name      = "pool"
+    count     = 3
+    farm      = "Freefarm"
+    disk_size = 15
+    cpu       = 2
+    memory    = 2048
+    labels = {
+      workload = "batch"
+    }
+  }
+}
+
+output "pool_workers" {
+  value = grid_kubernetes.k8s1.node_pool[0].workers
+}
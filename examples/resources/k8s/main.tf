@@ -68,3 +68,8 @@ output "master_public_ip" {
 output "wg_config" {
   value = grid_network.net1.access_wg_config
 }
+
+output "kubeconfig" {
+  value     = grid_kubernetes.k8s1.kubeconfig
+  sensitive = true
+}
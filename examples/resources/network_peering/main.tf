@@ -0,0 +1,39 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+resource "grid_network" "net_a" {
+  nodes       = [2]
+  ip_range    = "10.1.0.0/16"
+  name        = "net_a"
+  description = "first peered network"
+}
+
+resource "grid_network" "net_b" {
+  nodes       = [4]
+  ip_range    = "10.2.0.0/16"
+  name        = "net_b"
+  description = "second peered network"
+}
+
+resource "grid_network_peering" "ab" {
+  network_a = grid_network.net_a.name
+  node_a    = 2
+  network_b = grid_network.net_b.name
+  node_b    = 4
+}
+
+output "subnet_a" {
+  value = grid_network_peering.ab.subnet_a
+}
+
+output "subnet_b" {
+  value = grid_network_peering.ab.subnet_b
+}
@@ -0,0 +1,53 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+resource "grid_network" "net1" {
+  nodes       = [2, 4]
+  ip_range    = "10.1.0.0/16"
+  name        = "group_network"
+  description = "network of the vm group"
+}
+
+resource "grid_vm_group" "app" {
+  name         = "app"
+  network_name = grid_network.net1.name
+  disks {
+    name = "data"
+    node = 4
+    size = 10
+  }
+  vms {
+    name   = "db"
+    node   = 4
+    flist  = "https://hub.grid.tf/tf-official-apps/base:latest.flist"
+    cpu    = 2
+    memory = 2048
+    mounts {
+      disk_name   = "data"
+      mount_point = "/data"
+    }
+  }
+  vms {
+    name     = "web"
+    node     = 2
+    flist    = "https://hub.grid.tf/tf-official-apps/base:latest.flist"
+    cpu      = 1
+    memory   = 1024
+    publicip = true
+  }
+}
+
+output "db_ip" {
+  value = grid_vm_group.app.vms[0].ip
+}
+output "web_public_ip" {
+  value = grid_vm_group.app.vms[1].computedip
+}
@@ -8,7 +8,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -18,51 +22,261 @@ import (
 	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
 )
 
-const (
-	errThreshold = 4 // return error after failed 4 polls
-)
+// nonIdempotent lists the commands that must not be sent twice, they are only retried
+// if the previous attempt certainly never reached the proxy
+var nonIdempotent = map[string]bool{
+	"zos.deployment.deploy": true,
+	"zos.deployment.update": true,
+}
+
+// ProxyBusOptions tunes the http behaviour of the proxy bus
+type ProxyBusOptions struct {
+	// RequestTimeout bounds every single http request to a proxy
+	RequestTimeout time.Duration
+	// PollInterval is the initial wait between polls for a response
+	PollInterval time.Duration
+	// MaxPollInterval caps the backoff between polls
+	MaxPollInterval time.Duration
+	// MaxPollErrors is the number of failed polls after which the call fails
+	MaxPollErrors int
+	// Retries is the number of extra rounds over the endpoints to send a message
+	Retries int
+	// Recorder, if set, is given every call with its reply
+	Recorder Recorder
+}
+
+// Recorder captures the rmb traffic so it can be replayed
+type Recorder interface {
+	RecordCall(twin uint32, cmd string, data interface{}, reply []byte, err error)
+}
+
+func DefaultProxyBusOptions() ProxyBusOptions {
+	return ProxyBusOptions{
+		RequestTimeout:  30 * time.Second,
+		PollInterval:    time.Second,
+		MaxPollInterval: 5 * time.Second,
+		MaxPollErrors:   4,
+		Retries:         2,
+	}
+}
+
+// requestError is a failed request to the proxy
+type requestError struct {
+	err error
+	// status is the response status code, 0 if no response was received
+	status int
+	// delivered is false if the proxy certainly didn't process the request
+	delivered bool
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// retryable reports whether a failed request can be sent again
+func retryable(err error, idempotent bool) bool {
+	var re *requestError
+	if !errors.As(err, &re) {
+		return false
+	}
+	if !re.delivered {
+		return true
+	}
+	return idempotent && (re.status == 0 || re.status >= http.StatusInternalServerError)
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// drain reads the rest of the body before closing it so the connection can be reused
+func drain(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, body)
+	body.Close()
+}
+
+// jitter spreads d by 20% in both directions
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d) * 2 / 5
+	if spread <= 0 {
+		return d
+	}
+	return d - time.Duration(spread/2) + time.Duration(rand.Int63n(spread))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "context cancelled")
+	}
+}
 
 type TwinResolver struct {
 	cache  *cache.Cache
 	client subi.SubstrateExt
 }
+
+func NewTwinResolver(sub subi.SubstrateExt) TwinResolver {
+	return TwinResolver{
+		cache:  cache.New(time.Minute*5, time.Minute),
+		client: sub,
+	}
+}
+
 type ProxyBus struct {
 	signer      substrate.Identity
-	endpoint    string
+	endpoints   []string
 	twinID      uint32
 	verifyReply bool
 	resolver    TwinResolver
+	verifier    func(msg *rmb.Message, pk []byte) error
+	client      *http.Client
+	opts        ProxyBusOptions
+
+	m sync.Mutex
+	// index of the last endpoint that accepted a message
+	current int
 }
 
-func NewProxyBus(endpoint string, twinID uint32, sub subi.SubstrateExt, signer substrate.Identity, verifyReply bool) (*ProxyBus, error) {
-	if len(endpoint) != 0 && endpoint[len(endpoint)-1] == '/' {
-		endpoint = endpoint[:len(endpoint)-1]
+// NewProxyBus creates an rmb client over the given proxy endpoints, calls are sent to the
+// last endpoint that worked and fail over to the next one if it's unreachable
+func NewProxyBus(endpoints []string, twinID uint32, sub subi.SubstrateExt, signer substrate.Identity, verifyReply bool, opts ProxyBusOptions) (*ProxyBus, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("at least one rmb proxy endpoint is required")
+	}
+	trimmed := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		trimmed[i] = strings.TrimSuffix(endpoint, "/")
 	}
+	// connections are kept alive between the calls and polls of an apply
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 10
 
 	return &ProxyBus{
-		signer,
-		endpoint,
-		twinID,
-		verifyReply,
-		TwinResolver{
-			cache:  cache.New(time.Minute*5, time.Minute),
-			client: sub,
+		signer:      signer,
+		endpoints:   trimmed,
+		twinID:      twinID,
+		verifyReply: verifyReply,
+		resolver:    NewTwinResolver(sub),
+		verifier: func(msg *rmb.Message, pk []byte) error {
+			return msg.Verify(pk)
 		},
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.RequestTimeout,
+		},
+		opts: opts,
 	}, nil
 }
 
-func (r *ProxyBus) requestEndpoint(twinid uint32) string {
-	return fmt.Sprintf("%s/twin/%d", r.endpoint, twinid)
+func requestEndpoint(endpoint string, twinid uint32) string {
+	return fmt.Sprintf("%s/twin/%d", endpoint, twinid)
 }
 
-func (r *ProxyBus) resultEndpoint(twinid uint32, retqueue string) string {
-	return fmt.Sprintf("%s/twin/%d/%s", r.endpoint, twinid, retqueue)
+func resultEndpoint(endpoint string, twinid uint32, retqueue string) string {
+	return fmt.Sprintf("%s/twin/%d/%s", endpoint, twinid, retqueue)
+}
+
+// send posts the message to the first endpoint that accepts it, starting from the last
+// one that worked. Requests that may be retried move to the next endpoint, and the
+// endpoints are tried again after a backoff if all of them fail
+func (r *ProxyBus) send(ctx context.Context, twin uint32, idempotent bool, msg []byte) (string, ProxyResponse, error) {
+	r.m.Lock()
+	start := r.current
+	r.m.Unlock()
+	var err error
+	backoff := r.opts.PollInterval
+	for attempt := 0; attempt <= r.opts.Retries; attempt++ {
+		if attempt != 0 {
+			if err := sleep(ctx, jitter(backoff)); err != nil {
+				return "", ProxyResponse{}, err
+			}
+			backoff *= 2
+		}
+		for i := 0; i < len(r.endpoints); i++ {
+			idx := (start + i) % len(r.endpoints)
+			endpoint := r.endpoints[idx]
+			var res ProxyResponse
+			res, err = r.post(ctx, endpoint, twin, msg)
+			if err == nil {
+				r.m.Lock()
+				r.current = idx
+				r.m.Unlock()
+				return endpoint, res, nil
+			}
+			if ctx.Err() != nil || !retryable(err, idempotent) {
+				return "", ProxyResponse{}, err
+			}
+			log.Printf("rmb proxy %s failed: %s", endpoint, err)
+		}
+	}
+	return "", ProxyResponse{}, errors.Wrap(err, "all rmb proxy endpoints are unavailable")
+}
+
+func (r *ProxyBus) post(ctx context.Context, endpoint string, twin uint32, msg []byte) (ProxyResponse, error) {
+	var res ProxyResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestEndpoint(endpoint, twin), bytes.NewReader(msg))
+	if err != nil {
+		return res, errors.Wrap(err, "failed to build request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return res, &requestError{err: errors.Wrap(err, "error sending request"), delivered: !isDialError(err)}
+	}
+	defer drain(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return res, &requestError{
+			err:       parseError(resp),
+			status:    resp.StatusCode,
+			delivered: resp.StatusCode != http.StatusServiceUnavailable,
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, errors.Wrap(err, "failed to decode proxy response body")
+	}
+	return res, nil
 }
 
 func (r *ProxyBus) Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	reply, err := r.call(ctx, twin, fn, data)
+	if r.opts.Recorder != nil {
+		r.opts.Recorder.RecordCall(twin, fn, data, reply, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// not expecting a result
+	if result == nil {
+		return nil
+	}
+
+	if len(reply) == 0 {
+		return fmt.Errorf("no response body was returned")
+	}
+
+	if err := json.Unmarshal(reply, result); err != nil {
+		return errors.Wrap(err, "failed to decode response body")
+	}
+
+	return nil
+}
+
+// call sends the message and returns the data of the verified reply
+func (r *ProxyBus) call(ctx context.Context, twin uint32, fn string, data interface{}) ([]byte, error) {
 	bs, err := json.Marshal(data)
 	if err != nil {
-		return errors.Wrap(err, "failed to serialize request data")
+		return nil, errors.Wrap(err, "failed to serialize request data")
 	}
 
 	msg := rmb.Message{
@@ -76,77 +290,92 @@ func (r *ProxyBus) Call(ctx context.Context, twin uint32, fn string, data interf
 		Proxy:      true,
 	}
 	if err := msg.Sign(r.signer); err != nil {
-		return err
+		return nil, err
 	}
 	bs, err = json.Marshal(msg)
 	if err != nil {
-		return errors.Wrap(err, "failed to serialize message")
+		return nil, errors.Wrap(err, "failed to serialize message")
 	}
-	resp, err := http.Post(r.requestEndpoint(twin), "application/json", bytes.NewBuffer(bs))
+	endpoint, res, err := r.send(ctx, twin, !nonIdempotent[fn], bs)
 	if err != nil {
-		return errors.Wrap(err, "error sending request")
-	}
-	if resp.StatusCode != http.StatusOK {
-		return parseError(resp)
-	}
-	var res ProxyResponse
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return errors.Wrap(err, "failed to decode proxy response body")
-	}
-	msg, err = r.pollResponse(ctx, twin, res.Retqueue)
-	if err != nil {
-		return errors.Wrap(err, "couldn't poll response")
+		return nil, err
 	}
-	pk, err := r.resolver.PublicKey(int(twin))
+	msg, err = r.pollResponse(ctx, endpoint, twin, res.Retqueue)
 	if err != nil {
-		return errors.Wrap(err, "couldn't get twin public key")
+		return nil, errors.Wrapf(err, "couldn't poll response from %s", endpoint)
 	}
+	log.Printf("rmb call %s to twin %d served by %s", fn, twin, endpoint)
 	if r.verifyReply {
-
-		if err := msg.Verify(pk); err != nil {
-			return err
+		if err := r.verify(twin, &msg); err != nil {
+			return nil, err
 		}
 	}
 
 	// errorred?
 	if len(msg.Err) != 0 {
-		return errors.New(msg.Err)
-	}
-
-	// not expecting a result
-	if result == nil {
-		return nil
+		return nil, errors.New(msg.Err)
 	}
 
 	if len(msg.Data) == 0 {
-		return fmt.Errorf("no response body was returned")
+		return nil, nil
 	}
 
 	//check if msg.Data is base64 encoded
-	msgDataBytes := getDecodedMsgData(msg.Data)
+	return getDecodedMsgData(msg.Data), nil
+}
 
-	if err := json.Unmarshal(msgDataBytes, result); err != nil {
-		return errors.Wrap(err, "failed to decode response body")
-	}
+func (r *ProxyBus) verify(twin uint32, msg *rmb.Message) error {
+	return r.resolver.Verify(int(twin), fmt.Sprintf("command: %s, uid: %s", msg.Command, msg.UID), func(pk []byte) error {
+		return r.verifier(msg, pk)
+	})
+}
 
+// Verify checks a signature of the twin using verify. A failed check could be caused
+// by a rotated key, so the key is refetched and the check is retried once.
+// desc identifies the verified message in the audit log
+func (r TwinResolver) Verify(twin int, desc string, verify func(pk []byte) error) error {
+	pk, err := r.PublicKey(twin)
+	if err != nil {
+		return errors.Wrap(err, "couldn't get twin public key")
+	}
+	if err := verify(pk); err == nil {
+		return nil
+	}
+	pk, err = r.Refresh(twin)
+	if err != nil {
+		return errors.Wrap(err, "couldn't refresh twin public key")
+	}
+	if err := verify(pk); err != nil {
+		log.Printf("[WARN] audit: rmb reply signature mismatch, twin: %d, %s, error: %s", twin, desc, err)
+		return errors.Wrapf(err, "couldn't verify reply signature of twin %d", twin)
+	}
+	log.Printf("twin %d public key was rotated, reply verified with the refetched key", twin)
 	return nil
 }
 
 func (r TwinResolver) PublicKey(twin int) ([]byte, error) {
-	key := fmt.Sprintf("pk:%d", twin)
-	cached, ok := r.cache.Get(key)
+	cached, ok := r.cache.Get(twinKey(twin))
 	if ok {
 		return cached.([]byte), nil
 	}
+	return r.Refresh(twin)
+}
+
+// Refresh fetches the twin public key from the chain, replacing the cached one
+func (r TwinResolver) Refresh(twin int) ([]byte, error) {
 	pk, err := r.client.GetTwinPK(uint32(twin))
 	if err != nil {
 		return nil, err
 	}
 
-	r.cache.Set(key, pk, cache.DefaultExpiration)
+	r.cache.Set(twinKey(twin), pk, cache.DefaultExpiration)
 	return pk, nil
 }
 
+func twinKey(twin int) string {
+	return fmt.Sprintf("pk:%d", twin)
+}
+
 func getDecodedMsgData(data string) []byte {
 	decoded := []byte(data)
 	b, err := base64.StdEncoding.DecodeString(data)
@@ -156,47 +385,62 @@ func getDecodedMsgData(data string) []byte {
 	return decoded
 }
 
-func (r *ProxyBus) pollResponse(ctx context.Context, twin uint32, retqueue string) (rmb.Message, error) {
-	ts := time.NewTicker(1 * time.Second)
+// pollResponse waits for the response on the endpoint that accepted the message,
+// the wait between polls grows until MaxPollInterval
+func (r *ProxyBus) pollResponse(ctx context.Context, endpoint string, twin uint32, retqueue string) (rmb.Message, error) {
+	interval := r.opts.PollInterval
 	errCount := 0
-	var err error
 	for {
-		select {
-		case <-ts.C:
-			if errCount == errThreshold {
-				return rmb.Message{}, err
-			}
-			resp, lerr := http.Get(r.resultEndpoint(twin, retqueue))
-			if lerr != nil {
-				log.Printf("failed to send result-fetching request: %s", err.Error())
-				errCount += 1
-				err = lerr
-				continue
-			}
-			if resp.StatusCode == 404 {
-				// message not there yet
-				continue
-			}
-			if resp.StatusCode != http.StatusOK {
-				err = parseError(resp)
-				errCount += 1
-				continue
-			}
-			var msgs []rmb.Message
-			if lerr := json.NewDecoder(resp.Body).Decode(&msgs); lerr != nil {
-				err = lerr
-				errCount += 1
-				continue
+		if err := sleep(ctx, jitter(interval)); err != nil {
+			return rmb.Message{}, err
+		}
+		msg, found, err := r.fetch(ctx, endpoint, twin, retqueue)
+		if err != nil {
+			if ctx.Err() != nil {
+				return rmb.Message{}, errors.Wrap(ctx.Err(), "context cancelled")
 			}
-			if len(msgs) == 0 {
-				// nothing there yet
-				continue
+			log.Printf("failed to fetch result: %s", err)
+			errCount += 1
+			if errCount >= r.opts.MaxPollErrors {
+				return rmb.Message{}, err
 			}
-			return msgs[0], nil
-		case <-ctx.Done():
-			return rmb.Message{}, errors.New("context cancelled")
+		} else if found {
+			return msg, nil
 		}
+		interval *= 2
+		if interval > r.opts.MaxPollInterval {
+			interval = r.opts.MaxPollInterval
+		}
+	}
+}
+
+// fetch gets the response of a message if it has arrived
+func (r *ProxyBus) fetch(ctx context.Context, endpoint string, twin uint32, retqueue string) (rmb.Message, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resultEndpoint(endpoint, twin, retqueue), nil)
+	if err != nil {
+		return rmb.Message{}, false, errors.Wrap(err, "failed to build result-fetching request")
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return rmb.Message{}, false, errors.Wrap(err, "failed to send result-fetching request")
+	}
+	defer drain(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		// message not there yet
+		return rmb.Message{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return rmb.Message{}, false, parseError(resp)
+	}
+	var msgs []rmb.Message
+	if err := json.NewDecoder(resp.Body).Decode(&msgs); err != nil {
+		return rmb.Message{}, false, errors.Wrap(err, "failed to decode result")
+	}
+	if len(msgs) == 0 {
+		// nothing there yet
+		return rmb.Message{}, false, nil
 	}
+	return msgs[0], true, nil
 }
 
 func parseError(resp *http.Response) error {
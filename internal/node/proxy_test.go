@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/go-rmb"
+	"github.com/threefoldtech/substrate-client"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+)
+
+type fakeTwins struct {
+	subi.SubstrateExt
+}
+
+func (f *fakeTwins) GetTwinPK(twinID uint32) ([]byte, error) {
+	return []byte{}, nil
+}
+
+func testOptions() ProxyBusOptions {
+	opts := DefaultProxyBusOptions()
+	opts.PollInterval = 10 * time.Millisecond
+	opts.MaxPollInterval = 20 * time.Millisecond
+	return opts
+}
+
+func failingServer(status int, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.WriteHeader(status)
+	}))
+}
+
+func proxyServer(t *testing.T, reply string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			assert.NoError(t, json.NewEncoder(w).Encode(ProxyResponse{Retqueue: "queue"}))
+		case http.MethodGet:
+			msgs := []rmb.Message{{Data: base64.StdEncoding.EncodeToString([]byte(reply))}}
+			assert.NoError(t, json.NewEncoder(w).Encode(msgs))
+		}
+	}))
+}
+
+func TestProxyBusFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := proxyServer(t, `"pong"`)
+	defer up.Close()
+
+	identity, err := substrate.NewIdentityFromSr25519Phrase("//Alice")
+	assert.NoError(t, err)
+	bus, err := NewProxyBus([]string{down.URL, up.URL + "/"}, 1, &fakeTwins{}, identity, false, testOptions())
+	assert.NoError(t, err)
+
+	var result string
+	assert.NoError(t, bus.Call(context.Background(), 2, "ping", nil, &result))
+	assert.Equal(t, "pong", result)
+	assert.Equal(t, 1, bus.current)
+}
+
+func TestProxyBusClientErrorDoesNotFailover(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer bad.Close()
+	up := proxyServer(t, `"pong"`)
+	defer up.Close()
+
+	identity, err := substrate.NewIdentityFromSr25519Phrase("//Alice")
+	assert.NoError(t, err)
+	bus, err := NewProxyBus([]string{bad.URL, up.URL}, 1, &fakeTwins{}, identity, false, testOptions())
+	assert.NoError(t, err)
+
+	assert.Error(t, bus.Call(context.Background(), 2, "ping", nil, nil))
+	assert.Equal(t, 0, bus.current)
+}
+
+func TestProxyBusRetries(t *testing.T) {
+	identity, err := substrate.NewIdentityFromSr25519Phrase("//Alice")
+	assert.NoError(t, err)
+
+	t.Run("unavailable proxy is retried for any command", func(t *testing.T) {
+		var requests int32
+		down := failingServer(http.StatusServiceUnavailable, &requests)
+		defer down.Close()
+		bus, err := NewProxyBus([]string{down.URL}, 1, &fakeTwins{}, identity, false, testOptions())
+		assert.NoError(t, err)
+		assert.Error(t, bus.Call(context.Background(), 2, "zos.deployment.deploy", nil, nil))
+		assert.Equal(t, int32(bus.opts.Retries+1), atomic.LoadInt32(&requests))
+	})
+	t.Run("server error is retried for idempotent commands", func(t *testing.T) {
+		var requests int32
+		broken := failingServer(http.StatusInternalServerError, &requests)
+		defer broken.Close()
+		bus, err := NewProxyBus([]string{broken.URL}, 1, &fakeTwins{}, identity, false, testOptions())
+		assert.NoError(t, err)
+		assert.Error(t, bus.Call(context.Background(), 2, "zos.deployment.get", nil, nil))
+		assert.Equal(t, int32(bus.opts.Retries+1), atomic.LoadInt32(&requests))
+	})
+	t.Run("server error is not retried for deployments", func(t *testing.T) {
+		var requests int32
+		broken := failingServer(http.StatusInternalServerError, &requests)
+		defer broken.Close()
+		bus, err := NewProxyBus([]string{broken.URL}, 1, &fakeTwins{}, identity, false, testOptions())
+		assert.NoError(t, err)
+		assert.Error(t, bus.Call(context.Background(), 2, "zos.deployment.deploy", nil, nil))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+}
+
+func TestProxyBusPollingStopsWithContext(t *testing.T) {
+	pending := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			assert.NoError(t, json.NewEncoder(w).Encode(ProxyResponse{Retqueue: "queue"}))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer pending.Close()
+
+	identity, err := substrate.NewIdentityFromSr25519Phrase("//Alice")
+	assert.NoError(t, err)
+	bus, err := NewProxyBus([]string{pending.URL}, 1, &fakeTwins{}, identity, false, testOptions())
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, bus.Call(ctx, 2, "zos.deployment.get", nil, nil), context.DeadlineExceeded)
+}
+
+// rotatingTwins hands out the twin keys in order, the last one is kept once they run out
+type rotatingTwins struct {
+	subi.SubstrateExt
+	keys    []string
+	fetches int
+}
+
+func (f *rotatingTwins) GetTwinPK(twinID uint32) ([]byte, error) {
+	idx := f.fetches
+	if idx >= len(f.keys) {
+		idx = len(f.keys) - 1
+	}
+	f.fetches++
+	return []byte(f.keys[idx]), nil
+}
+
+func verifyingBus(t *testing.T, endpoint string, twins *rotatingTwins, trusted string) *ProxyBus {
+	identity, err := substrate.NewIdentityFromSr25519Phrase("//Alice")
+	assert.NoError(t, err)
+	bus, err := NewProxyBus([]string{endpoint}, 1, twins, identity, true, testOptions())
+	assert.NoError(t, err)
+	bus.verifier = func(msg *rmb.Message, pk []byte) error {
+		if string(pk) != trusted {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return bus
+}
+
+func TestProxyBusVerifiesReplies(t *testing.T) {
+	up := proxyServer(t, `"pong"`)
+	defer up.Close()
+
+	t.Run("key is cached", func(t *testing.T) {
+		twins := &rotatingTwins{keys: []string{"key"}}
+		bus := verifyingBus(t, up.URL, twins, "key")
+		var result string
+		assert.NoError(t, bus.Call(context.Background(), 2, "ping", nil, &result))
+		assert.NoError(t, bus.Call(context.Background(), 2, "ping", nil, &result))
+		assert.Equal(t, "pong", result)
+		assert.Equal(t, 1, twins.fetches)
+	})
+	t.Run("rotated key is refetched", func(t *testing.T) {
+		twins := &rotatingTwins{keys: []string{"old", "new"}}
+		bus := verifyingBus(t, up.URL, twins, "new")
+		var result string
+		assert.NoError(t, bus.Call(context.Background(), 2, "ping", nil, &result))
+		assert.Equal(t, "pong", result)
+		assert.Equal(t, 2, twins.fetches)
+	})
+	t.Run("mismatch fails after one retry", func(t *testing.T) {
+		twins := &rotatingTwins{keys: []string{"old", "other", "new"}}
+		bus := verifyingBus(t, up.URL, twins, "new")
+		var result string
+		assert.Error(t, bus.Call(context.Background(), 2, "ping", nil, &result))
+		assert.Empty(t, result)
+		assert.Equal(t, 2, twins.fetches)
+	})
+	t.Run("disabled verification skips the key", func(t *testing.T) {
+		twins := &rotatingTwins{keys: []string{"old"}}
+		bus := verifyingBus(t, up.URL, twins, "new")
+		bus.verifyReply = false
+		assert.NoError(t, bus.Call(context.Background(), 2, "ping", nil, nil))
+		assert.Equal(t, 0, twins.fetches)
+	})
+}
@@ -0,0 +1,171 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/gridtest"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+// accGrid starts a fake grid with a public and a hidden node and funds the //Alice twin.
+// It returns the grid, the provider factories using it and the provider block
+func accGrid(t *testing.T) (*gridtest.Grid, map[string]func() (*schema.Provider, error), string) {
+	grid := gridtest.NewGrid()
+	t.Cleanup(grid.Close)
+	farm := grid.AddFarm("freefarm", proxytypes.PublicIP{IP: "185.206.122.33/24", Gateway: "185.206.122.1"})
+	capacity := gridtypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 512 * gridtypes.Gigabyte, HRU: 1024 * gridtypes.Gigabyte}
+	ip, ipRange, err := net.ParseCIDR("185.206.122.10/24")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ipRange.IP = ip
+	public := &client.PublicConfig{
+		IPv4:   gridtypes.IPNet{IPNet: *ipRange},
+		GW4:    net.ParseIP("185.206.122.1"),
+		Domain: "gent01.gridtest.grid.tf",
+	}
+	if _, err := grid.AddNode(farm, capacity, public); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := grid.AddNode(farm, capacity, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	identity, err := subi.NewIdentityFromSr25519Phrase("//Alice")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	grid.Substrate.AddTwin(identity, "", 1000000000)
+
+	st := state.NewState()
+	factories := map[string]func() (*schema.Provider, error){
+		"grid": func() (*schema.Provider, error) {
+			return New("test", grid.Substrate, &st)(), nil
+		},
+	}
+	config := fmt.Sprintf(`
+provider "grid" {
+  network           = "custom"
+  substrate_version = "dev"
+  substrate_url     = "ws://gridtest"
+  rmb_proxy_url     = "%[1]s"
+  rmb_transport     = "proxy"
+  rmb_poll_interval = "10ms"
+  mnemonics         = "//Alice"
+  key_type          = "sr25519"
+}
+`, grid.URL())
+	return grid, factories, config
+}
+
+// testAccCheckContracts checks the number of active contracts on the fake chain
+func testAccCheckContracts(grid *gridtest.Grid, active int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		count := 0
+		for _, c := range grid.Substrate.Contracts() {
+			if !c.Deleted {
+				count++
+			}
+		}
+		if count != active {
+			return fmt.Errorf("expected %d active contracts, found %d", active, count)
+		}
+		return nil
+	}
+}
+
+func TestAccDeployment(t *testing.T) {
+	grid, factories, provider := accGrid(t)
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: factories,
+		CheckDestroy:      testAccCheckContracts(grid, 0),
+		Steps: []resource.TestStep{
+			{
+				Config: provider + `
+resource "grid_deployment" "d1" {
+  node = 2
+  disks {
+    name = "data"
+    size = 10
+  }
+  zdbs {
+    name     = "zdb"
+    size     = 1
+    password = "secret"
+    mode     = "user"
+  }
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckContracts(grid, 1),
+					resource.TestCheckResourceAttr("grid_deployment.d1", "disks.0.size", "10"),
+					resource.TestCheckResourceAttr("grid_deployment.d1", "zdbs.0.port", "9900"),
+					resource.TestCheckResourceAttrSet("grid_deployment.d1", "zdbs.0.namespace"),
+				),
+			},
+			{
+				Config: provider + `
+resource "grid_deployment" "d1" {
+  node = 2
+  disks {
+    name = "data"
+    size = 20
+  }
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckContracts(grid, 1),
+					resource.TestCheckResourceAttr("grid_deployment.d1", "disks.0.size", "20"),
+					resource.TestCheckResourceAttr("grid_deployment.d1", "zdbs.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccNetworkWithVM(t *testing.T) {
+	grid, factories, provider := accGrid(t)
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: factories,
+		CheckDestroy:      testAccCheckContracts(grid, 0),
+		Steps: []resource.TestStep{
+			{
+				Config: provider + `
+resource "grid_network" "net" {
+  name          = "net"
+  nodes         = [2]
+  ip_range      = "10.1.0.0/16"
+  add_wg_access = true
+}
+resource "grid_deployment" "d1" {
+  node         = 2
+  network_name = grid_network.net.name
+  vms {
+    name      = "vm"
+    flist     = "https://hub.grid.tf/tf-official-apps/base:latest.flist"
+    cpu       = 1
+    memory    = 1024
+    planetary = true
+  }
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					// the hidden node is reached through the public one
+					testAccCheckContracts(grid, 3),
+					resource.TestCheckResourceAttr("grid_network.net", "public_node_id", "1"),
+					resource.TestCheckResourceAttrSet("grid_network.net", "access_wg_config"),
+					resource.TestCheckResourceAttrSet("grid_deployment.d1", "vms.0.ip"),
+					resource.TestCheckResourceAttrSet("grid_deployment.d1", "vms.0.ygg_ip"),
+				),
+			},
+		},
+	})
+}
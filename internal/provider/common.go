@@ -19,7 +19,7 @@ import (
 const RMB_WORKERS = 10
 
 func startRmbIfNeeded(ctx context.Context, api *apiClient) {
-	if api.use_rmb_proxy {
+	if api.rmb_transport != RMBTransportRedis {
 		return
 	}
 	rmbClient, err := gormb.NewServer(api.manager, "127.0.0.1:6379", RMB_WORKERS, api.identity)
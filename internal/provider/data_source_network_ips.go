@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+)
+
+func dataSourceNetworkIPs() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "Data source listing the free and used private ips of a network on a node, as tracked by the provider.",
+
+		ReadContext: dataSourceNetworkIPsRead,
+
+		Schema: map[string]*schema.Schema{
+			"network_name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the network",
+			},
+			"node": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "Node ID in the network",
+			},
+			"subnet": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Subnet of the network on the node",
+			},
+			"free_ips": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "IPs of the subnet that can be assigned to vms",
+			},
+			"used_ips": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Mapping from each used ip to the id of the deployment using it",
+			},
+		},
+	}
+}
+
+func dataSourceNetworkIPsRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	apiClient := meta.(*apiClient)
+	networkName := d.Get("network_name").(string)
+	nodeID := uint32(d.Get("node").(int))
+	network := apiClient.state.GetNetworkState().GetNetwork(networkName)
+	ipam, err := network.GetNodeIPAM(nodeID)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't get the ips of network %s", networkName))
+	}
+	used := make(map[string]interface{})
+	for ip, owner := range ipam.Used() {
+		used[ip] = owner
+	}
+	d.Set("subnet", ipam.Subnet())
+	d.Set("free_ips", ipam.Free())
+	d.Set("used_ips", used)
+	d.SetId(fmt.Sprintf("%s:%d", networkName, nodeID))
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/pkg/errors"
 	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/deployer"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/workloads"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
@@ -103,40 +104,65 @@ func getDeploymentDeployer(d *schema.ResourceData, apiClient *apiClient) (Deploy
 }
 
 func (d *DeploymentDeployer) assignNodesIPs() error {
-	networkingState := d.APIClient.state.GetNetworkState()
-	network := networkingState.GetNetwork(d.NetworkName)
-	usedIPs := network.GetNodeIPsList(d.Node)
 	if len(d.VMs) == 0 {
 		return nil
 	}
-	_, cidr, err := net.ParseCIDR(d.IPRange)
+	networkingState := d.APIClient.state.GetNetworkState()
+	network := networkingState.GetNetwork(d.NetworkName)
+	ipam, err := network.GetNodeIPAM(d.Node)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't get node %d ips", d.Node)
+	}
+	// the ips of the deployment are assigned again, the ones of the removed vms are freed
+	ipam.Release(d.Id)
+	_, cidr, err := net.ParseCIDR(ipam.Subnet())
 	if err != nil {
-		return errors.Wrapf(err, "invalid ip %s", d.IPRange)
+		return errors.Wrapf(err, "invalid ip range %s", ipam.Subnet())
 	}
 	for _, vm := range d.VMs {
-		if vm.IP != "" && cidr.Contains(net.ParseIP(vm.IP)) && !isInByte(usedIPs, net.ParseIP(vm.IP)[3]) {
-			usedIPs = append(usedIPs, net.ParseIP(vm.IP)[3])
+		if vm.IP != "" && cidr.Contains(net.ParseIP(vm.IP)) {
+			if err := ipam.Assign(vm.IP, d.Id); err != nil {
+				return errors.Wrapf(err, "couldn't assign the ip of vm %s", vm.Name)
+			}
 		}
 	}
-	cur := byte(2)
 	for idx, vm := range d.VMs {
 		if vm.IP != "" && cidr.Contains(net.ParseIP(vm.IP)) {
 			continue
 		}
-		ip := cidr.IP
-		ip[3] = cur
-		for isInByte(usedIPs, ip[3]) {
-			if cur == 254 {
-				return errors.New("all 253 ips of the network are exhausted")
-			}
-			cur++
-			ip[3] = cur
+		ip, err := ipam.Allocate(d.Id)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't allocate an ip for vm %s", vm.Name)
 		}
-		d.VMs[idx].IP = ip.String()
-		usedIPs = append(usedIPs, ip[3])
+		d.VMs[idx].IP = ip
 	}
 	return nil
 }
+
+// checkVMIPs reports the static ips of the vms on the node that conflict with the ips used by the
+// other deployments of the network or reserved in it, the nodes not in the network yet are skipped
+func checkVMIPs(network state.Network, node uint32, owner string, vms []map[string]interface{}) error {
+	ipam, err := network.GetNodeIPAM(node)
+	if err != nil {
+		return nil
+	}
+	ipam.Release(owner)
+	_, cidr, err := net.ParseCIDR(ipam.Subnet())
+	if err != nil {
+		return errors.Wrapf(err, "invalid ip range %s", ipam.Subnet())
+	}
+	for _, vm := range vms {
+		ip, _ := vm["ip"].(string)
+		if ip == "" || !cidr.Contains(net.ParseIP(ip)) {
+			continue
+		}
+		if err := ipam.Assign(ip, owner); err != nil {
+			return errors.Wrapf(err, "couldn't assign the ip of vm %s", vm["name"])
+		}
+	}
+	return nil
+}
+
 func (d *DeploymentDeployer) GenerateVersionlessDeployments(ctx context.Context) (map[uint32]gridtypes.Deployment, error) {
 	dl := workloads.NewDeployment(d.APIClient.twin_id)
 	err := d.assignNodesIPs()
@@ -245,7 +271,13 @@ func (d *DeploymentDeployer) sync(ctx context.Context, sub subi.SubstrateExt, cl
 	if err != nil {
 		return errors.Wrap(err, "failed to get deployments to update local state")
 	}
-	dl := currentDeployments[d.Node]
+	d.syncDeployment(currentDeployments[d.Node], cl)
+	return nil
+}
+
+// syncDeployment updates the workloads from the deployment on the node and records the
+// used vm ips in the network state
+func (d *DeploymentDeployer) syncDeployment(dl gridtypes.Deployment, cl *apiClient) {
 	var vms []workloads.VM
 	var zdbs []workloads.ZDB
 	var qsfs []workloads.QSFS
@@ -255,7 +287,7 @@ func (d *DeploymentDeployer) sync(ctx context.Context, sub subi.SubstrateExt, cl
 	network := ns.GetNetwork(d.NetworkName)
 	network.DeleteDeployment(d.Node, d.Id)
 
-	usedIPs := []byte{}
+	usedIPs := []string{}
 	for _, w := range dl.Workloads {
 		if !w.Result.State.IsOkay() {
 			continue
@@ -268,9 +300,7 @@ func (d *DeploymentDeployer) sync(ctx context.Context, sub subi.SubstrateExt, cl
 				continue
 			}
 			vms = append(vms, vm)
-
-			ip := net.ParseIP(vm.IP).To4()
-			usedIPs = append(usedIPs, ip[3])
+			usedIPs = append(usedIPs, vm.IP)
 		case zos.ZDBType:
 			zdb, err := workloads.NewZDBFromWorkload(&w)
 			if err != nil {
@@ -302,7 +332,6 @@ func (d *DeploymentDeployer) sync(ctx context.Context, sub subi.SubstrateExt, cl
 	d.QSFSs = qsfs
 	d.ZDBs = zdbs
 	d.VMs = vms
-	return nil
 }
 
 // Match objects to match the input
@@ -354,7 +383,8 @@ func (d *DeploymentDeployer) Match(
 }
 func (d *DeploymentDeployer) validate() error {
 	if len(d.VMs) != 0 && d.NetworkName == "" {
-		return errors.New("If you pass a vm, network_name must be non-empty")
+		// zos rejects zmachines without exactly one private network interface, even planetary only ones
+		return errors.New("If you pass a vm, network_name must be non-empty, zos requires every vm to have a private network")
 	}
 
 	for _, vm := range d.VMs {
@@ -368,6 +398,14 @@ func (d *DeploymentDeployer) Deploy(ctx context.Context, sub subi.SubstrateExt)
 	if err := d.validate(); err != nil {
 		return err
 	}
+	if len(d.VMs) != 0 {
+		if err := extendNetwork(ctx, sub, d.APIClient, d.NetworkName, []uint32{d.Node}); err != nil {
+			return err
+		}
+		if d.IPRange == "" {
+			d.IPRange = d.APIClient.state.GetNetworkState().GetNetwork(d.NetworkName).GetNodeSubnet(d.Node)
+		}
+	}
 	newDeployments, err := d.GenerateVersionlessDeployments(ctx)
 	if err != nil {
 		return errors.Wrap(err, "couldn't generate deployments data")
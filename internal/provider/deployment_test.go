@@ -4,13 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"log"
-	"net"
 	"sort"
 	"testing"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	mock "github.com/threefoldtech/terraform-provider-grid/internal/provider/mocks"
+	localstate "github.com/threefoldtech/terraform-provider-grid/pkg/state"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/workloads"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
 	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
@@ -290,7 +290,9 @@ func TestDeploymentGenerateDeployment(t *testing.T) {
 	state.EXPECT().GetNetworkState().Return(netState)
 	network := mock.NewMockNetwork(ctrl)
 	netState.EXPECT().GetNetwork(d.NetworkName).Return(network)
-	network.EXPECT().GetNodeIPsList(d.Node).Return([]byte{})
+	ipam, err := localstate.NewIPAM("10.10.10.0/24")
+	assert.NoError(t, err)
+	network.EXPECT().GetNodeIPAM(d.Node).Return(ipam, nil)
 	dl, err := d.GenerateVersionlessDeployments(context.Background())
 	assert.NoError(t, err)
 	var wls []gridtypes.Workload
@@ -326,7 +328,9 @@ func TestDeploymentSync(t *testing.T) {
 	state.EXPECT().GetNetworkState().AnyTimes().Return(netState)
 	network := mock.NewMockNetwork(ctrl)
 	netState.EXPECT().GetNetwork(d.NetworkName).AnyTimes().Return(network)
-	network.EXPECT().GetNodeIPsList(d.Node).Return([]byte{})
+	ipam, err := localstate.NewIPAM("10.10.10.0/24")
+	assert.NoError(t, err)
+	network.EXPECT().GetNodeIPAM(d.Node).Return(ipam, nil)
 	dls, err := d.GenerateVersionlessDeployments(context.Background())
 	assert.NoError(t, err)
 	dl := dls[d.Node]
@@ -422,6 +426,10 @@ func TestDeploymentSync(t *testing.T) {
 		}, nil)
 	var cp DeploymentDeployer
 	musUnmarshal(mustMarshal(d), &cp)
+	// the private ipv6 is derived from the private ip when reading the vms
+	for idx := range cp.VMs {
+		cp.VMs[idx].IP6 = workloads.PrivateIP6(dl.TwinID, cp.VMs[idx].NetworkName, cp.VMs[idx].IP)
+	}
 	network.EXPECT().DeleteDeployment(d.Node, d.Id)
 	usedIPs := getUsedIPs(dl)
 	network.EXPECT().SetDeploymentIPs(d.Node, d.Id, usedIPs)
@@ -434,8 +442,8 @@ func TestDeploymentSync(t *testing.T) {
 	assert.Equal(t, d.Node, cp.Node)
 }
 
-func getUsedIPs(dl gridtypes.Deployment) []byte {
-	usedIPs := []byte{}
+func getUsedIPs(dl gridtypes.Deployment) []string {
+	usedIPs := []string{}
 	for _, w := range dl.Workloads {
 		if !w.Result.State.IsOkay() {
 			continue
@@ -446,9 +454,7 @@ func getUsedIPs(dl gridtypes.Deployment) []byte {
 				log.Printf("error parsing vm: %s", err.Error())
 				continue
 			}
-
-			ip := net.ParseIP(vm.IP).To4()
-			usedIPs = append(usedIPs, ip[3])
+			usedIPs = append(usedIPs, vm.IP)
 		}
 	}
 	return usedIPs
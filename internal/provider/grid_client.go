@@ -0,0 +1,130 @@
+package provider
+
+import (
+	"log"
+	"net/url"
+	"sync"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
+	proxy "github.com/threefoldtech/grid_proxy_server/pkg/client"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+)
+
+// failoverGridClient is a grid proxy client over a list of proxies, a request a proxy couldn't serve
+// is sent to the next proxies in order and the one answering it is used for the next requests
+type failoverGridClient struct {
+	urls    []string
+	clients []proxy.Client
+
+	m       sync.Mutex
+	current int
+}
+
+func newFailoverGridClient(urls []string) *failoverGridClient {
+	clients := make([]proxy.Client, 0, len(urls))
+	for _, url := range urls {
+		clients = append(clients, newGridProxyClient(url))
+	}
+	return &failoverGridClient{urls: urls, clients: clients}
+}
+
+// proxyFailed reports whether err comes from a proxy that couldn't be reached or failed to serve
+// a request, the other errors are answers to the request that the next proxies would give too
+func proxyFailed(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var proxyErr *proxyError
+	return errors.As(err, &proxyErr) && proxyErr.status >= 500
+}
+
+// try calls fn with the current proxy then with the next ones until one succeeds, it returns the last error.
+// The requests rejected by a proxy aren't sent to the others and their errors are permanent, so the
+// retrying client doesn't send them again either
+func (c *failoverGridClient) try(fn func(cl proxy.Client) error) error {
+	c.m.Lock()
+	current := c.current
+	c.m.Unlock()
+	var err error
+	for i := 0; i < len(c.clients); i++ {
+		idx := (current + i) % len(c.clients)
+		if err = fn(c.clients[idx]); err == nil {
+			if idx != current {
+				log.Printf("using grid proxy %s", c.urls[idx])
+				c.m.Lock()
+				c.current = idx
+				c.m.Unlock()
+			}
+			return nil
+		}
+		if !proxyFailed(err) {
+			return backoff.Permanent(err)
+		}
+		log.Printf("grid proxy %s request failed: %s", c.urls[idx], err)
+	}
+	return err
+}
+
+func (c *failoverGridClient) Ping() error {
+	return c.try(func(cl proxy.Client) error {
+		return cl.Ping()
+	})
+}
+
+func (c *failoverGridClient) Nodes(filter proxytypes.NodeFilter, pagination proxytypes.Limit) (res []proxytypes.Node, totalCount int, err error) {
+	err = c.try(func(cl proxy.Client) error {
+		res, totalCount, err = cl.Nodes(filter, pagination)
+		return err
+	})
+	return
+}
+
+func (c *failoverGridClient) Farms(filter proxytypes.FarmFilter, pagination proxytypes.Limit) (res []proxytypes.Farm, totalCount int, err error) {
+	err = c.try(func(cl proxy.Client) error {
+		res, totalCount, err = cl.Farms(filter, pagination)
+		return err
+	})
+	return
+}
+
+func (c *failoverGridClient) Contracts(filter proxytypes.ContractFilter, pagination proxytypes.Limit) (res []proxytypes.Contract, totalCount int, err error) {
+	err = c.try(func(cl proxy.Client) error {
+		res, totalCount, err = cl.Contracts(filter, pagination)
+		return err
+	})
+	return
+}
+
+func (c *failoverGridClient) Twins(filter proxytypes.TwinFilter, pagination proxytypes.Limit) (res []proxytypes.Twin, totalCount int, err error) {
+	err = c.try(func(cl proxy.Client) error {
+		res, totalCount, err = cl.Twins(filter, pagination)
+		return err
+	})
+	return
+}
+
+func (c *failoverGridClient) Node(nodeID uint32) (res proxytypes.NodeWithNestedCapacity, err error) {
+	err = c.try(func(cl proxy.Client) error {
+		res, err = cl.Node(nodeID)
+		return err
+	})
+	return
+}
+
+func (c *failoverGridClient) NodeStatus(nodeID uint32) (res proxytypes.NodeStatus, err error) {
+	err = c.try(func(cl proxy.Client) error {
+		res, err = cl.NodeStatus(nodeID)
+		return err
+	})
+	return
+}
+
+func (c *failoverGridClient) Counters(filter proxytypes.StatsFilter) (res proxytypes.Counters, err error) {
+	err = c.try(func(cl proxy.Client) error {
+		res, err = cl.Counters(filter)
+		return err
+	})
+	return
+}
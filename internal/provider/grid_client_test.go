@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	proxy "github.com/threefoldtech/grid_proxy_server/pkg/client"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/gridtest"
+)
+
+func TestFailoverGridClient(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	dead := httptest.NewServer(nil)
+	dead.Close()
+
+	cl := newFailoverGridClient([]string{dead.URL + "/", grid.URL()})
+	node, err := cl.Node(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, node.NodeID)
+	// the proxy that answered is used first from now on
+	assert.Equal(t, 1, cl.current)
+
+	other := gridtest.NewGrid()
+	other.Close()
+	cl = newFailoverGridClient([]string{dead.URL + "/", other.URL()})
+	_, err = cl.Node(1)
+	assert.Error(t, err)
+}
+
+func TestFailoverGridClientRejectedRequests(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	var hits int32
+	answer := func(status int) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error": "node not found"}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	// a proxy failing the request is skipped
+	cl := newFailoverGridClient([]string{answer(http.StatusBadGateway).URL, grid.URL()})
+	node, err := cl.Node(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, node.NodeID)
+	assert.Equal(t, 1, cl.current)
+
+	// a rejected request is neither sent to the next proxy nor retried
+	atomic.StoreInt32(&hits, 0)
+	cl = newFailoverGridClient([]string{answer(http.StatusNotFound).URL, grid.URL()})
+	_, err = proxy.NewRetryingClient(cl).Node(10)
+	assert.EqualError(t, err, "node not found (404)")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+	assert.Equal(t, 0, cl.current)
+}
@@ -0,0 +1,246 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	proxy "github.com/threefoldtech/grid_proxy_server/pkg/client"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+)
+
+// proxyError is the answer of a grid proxy to a request it didn't serve
+type proxyError struct {
+	status int
+	msg    string
+}
+
+func (e *proxyError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.msg, e.status)
+}
+
+// gridProxyClient talks to a grid proxy like the client of grid_proxy_server, but its errors keep
+// the status of the answers so a proxy failing a request can be told from a request it rejected
+type gridProxyClient struct {
+	endpoint string
+}
+
+func newGridProxyClient(endpoint string) proxy.Client {
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	return &gridProxyClient{endpoint: endpoint}
+}
+
+func parseProxyError(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read error response (%d)", resp.StatusCode)
+	}
+	var reply proxy.ErrorReply
+	if err := json.Unmarshal(body, &reply); err == nil && reply.Error != "" {
+		return &proxyError{status: resp.StatusCode, msg: reply.Error}
+	}
+	if len(body) != 0 {
+		return &proxyError{status: resp.StatusCode, msg: string(body)}
+	}
+	return &proxyError{status: resp.StatusCode, msg: http.StatusText(resp.StatusCode)}
+}
+
+// get decodes the answer of the proxy to the path in res, it returns the Count header of the lists
+func (c *gridProxyClient) get(path string, query url.Values, res interface{}) (int, error) {
+	u := c.endpoint + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, parseProxyError(resp)
+	}
+	if res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			return 0, errors.Wrapf(err, "couldn't decode the answer of %s", path)
+		}
+	}
+	count := resp.Header.Get("Count")
+	if count == "" {
+		return 0, nil
+	}
+	total, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, errors.Wrap(err, "couldn't parse count header")
+	}
+	return total, nil
+}
+
+func (c *gridProxyClient) Ping() error {
+	_, err := c.get("version", nil, nil)
+	return err
+}
+
+func (c *gridProxyClient) Nodes(filter proxytypes.NodeFilter, pagination proxytypes.Limit) (res []proxytypes.Node, totalCount int, err error) {
+	q := limitQuery(pagination)
+	setString(q, "status", filter.Status)
+	setUint(q, "free_mru", filter.FreeMRU)
+	setUint(q, "free_hru", filter.FreeHRU)
+	setUint(q, "free_sru", filter.FreeSRU)
+	setString(q, "country", filter.Country)
+	setString(q, "city", filter.City)
+	setString(q, "farm_name", filter.FarmName)
+	setUints(q, "farm_ids", filter.FarmIDs)
+	setUint(q, "free_ips", filter.FreeIPs)
+	setBool(q, "ipv4", filter.IPv4)
+	setBool(q, "ipv6", filter.IPv6)
+	setBool(q, "domain", filter.Domain)
+	setBool(q, "rentable", filter.Rentable)
+	if filter.RentedBy != nil {
+		// 0 is kept, it's used to get the nodes that aren't rented
+		q.Set("rented_by", fmt.Sprint(*filter.RentedBy))
+	}
+	if filter.AvailableFor != nil {
+		q.Set("available_for", fmt.Sprint(*filter.AvailableFor))
+	}
+	totalCount, err = c.get("nodes", q, &res)
+	return
+}
+
+func (c *gridProxyClient) Farms(filter proxytypes.FarmFilter, pagination proxytypes.Limit) (res []proxytypes.Farm, totalCount int, err error) {
+	q := limitQuery(pagination)
+	setUint(q, "free_ips", filter.FreeIPs)
+	setUint(q, "total_ips", filter.TotalIPs)
+	setString(q, "stellar_address", filter.StellarAddress)
+	if filter.PricingPolicyID != nil {
+		q.Set("pricing_policy_id", fmt.Sprint(*filter.PricingPolicyID))
+	}
+	setUint(q, "farm_id", filter.FarmID)
+	setUint(q, "twin_id", filter.TwinID)
+	setString(q, "name", filter.Name)
+	setString(q, "name_contains", filter.NameContains)
+	setString(q, "certification_type", filter.CertificationType)
+	setBool(q, "dedicated", filter.Dedicated)
+	totalCount, err = c.get("farms", q, &res)
+	return
+}
+
+func (c *gridProxyClient) Contracts(filter proxytypes.ContractFilter, pagination proxytypes.Limit) (res []proxytypes.Contract, totalCount int, err error) {
+	q := limitQuery(pagination)
+	setUint(q, "contract_id", filter.ContractID)
+	setUint(q, "twin_id", filter.TwinID)
+	setUint(q, "node_id", filter.NodeID)
+	setString(q, "type", filter.Type)
+	setString(q, "state", filter.State)
+	setString(q, "name", filter.Name)
+	setUint(q, "number_of_public_ips", filter.NumberOfPublicIps)
+	setString(q, "deployment_data", filter.DeploymentData)
+	setString(q, "deployment_hash", filter.DeploymentHash)
+	var contracts []struct {
+		proxytypes.Contract
+		Details json.RawMessage `json:"details"`
+	}
+	totalCount, err = c.get("contracts", q, &contracts)
+	if err != nil {
+		return
+	}
+	for _, contract := range contracts {
+		switch contract.Type {
+		case "node":
+			var details proxytypes.NodeContractDetails
+			err = json.Unmarshal(contract.Details, &details)
+			contract.Contract.Details = details
+		case "rent":
+			var details proxytypes.RentContractDetails
+			err = json.Unmarshal(contract.Details, &details)
+			contract.Contract.Details = details
+		case "name":
+			var details proxytypes.NameContractDetails
+			err = json.Unmarshal(contract.Details, &details)
+			contract.Contract.Details = details
+		}
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "couldn't decode the details of contract %d", contract.ContractID)
+		}
+		res = append(res, contract.Contract)
+	}
+	return
+}
+
+func (c *gridProxyClient) Twins(filter proxytypes.TwinFilter, pagination proxytypes.Limit) (res []proxytypes.Twin, totalCount int, err error) {
+	q := limitQuery(pagination)
+	setUint(q, "twin_id", filter.TwinID)
+	setString(q, "account_id", filter.AccountID)
+	totalCount, err = c.get("twins", q, &res)
+	return
+}
+
+func (c *gridProxyClient) Node(nodeID uint32) (res proxytypes.NodeWithNestedCapacity, err error) {
+	_, err = c.get(fmt.Sprintf("nodes/%d", nodeID), nil, &res)
+	return
+}
+
+func (c *gridProxyClient) NodeStatus(nodeID uint32) (res proxytypes.NodeStatus, err error) {
+	_, err = c.get(fmt.Sprintf("nodes/%d/status", nodeID), nil, &res)
+	return
+}
+
+func (c *gridProxyClient) Counters(filter proxytypes.StatsFilter) (res proxytypes.Counters, err error) {
+	q := url.Values{}
+	setString(q, "status", filter.Status)
+	_, err = c.get("stats", q, &res)
+	return
+}
+
+// limitQuery returns the query of the pagination, the filters are added to it
+func limitQuery(limit proxytypes.Limit) url.Values {
+	q := url.Values{}
+	if limit.Page != 0 {
+		q.Set("page", fmt.Sprint(limit.Page))
+	}
+	if limit.Size != 0 {
+		q.Set("size", fmt.Sprint(limit.Size))
+	}
+	if limit.RetCount {
+		q.Set("ret_count", "true")
+	}
+	if limit.Randomize {
+		q.Set("randomize", "true")
+	}
+	return q
+}
+
+func setString(q url.Values, key string, value *string) {
+	if value != nil && *value != "" {
+		q.Set(key, *value)
+	}
+}
+
+func setUint(q url.Values, key string, value *uint64) {
+	if value != nil && *value != 0 {
+		q.Set(key, fmt.Sprint(*value))
+	}
+}
+
+func setUints(q url.Values, key string, values []uint64) {
+	if len(values) == 0 {
+		return
+	}
+	s := make([]string, 0, len(values))
+	for _, v := range values {
+		s = append(s, fmt.Sprint(v))
+	}
+	q.Set(key, strings.Join(s, ","))
+}
+
+func setBool(q url.Values, key string, value *bool) {
+	if value != nil {
+		q.Set(key, fmt.Sprint(*value))
+	}
+}
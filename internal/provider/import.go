@@ -0,0 +1,198 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+// importedDeployment is a deployment adopted by an importer
+type importedDeployment struct {
+	Node       uint32
+	ContractID uint64
+	Deployment gridtypes.Deployment
+	// Data is the deployment data stored on the contract, it's empty for
+	// deployments that weren't created by the provider or the dashboard
+	Data DeploymentData
+}
+
+// parseNodeContractID parses import ids of the form <node_id>:<contract_id>
+func parseNodeContractID(id string) (uint32, uint64, error) {
+	parts := strings.Split(id, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid import id %s, expected <node_id>:<contract_id>", id)
+	}
+	node, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid node id %s", parts[0])
+	}
+	contractID, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid contract id %s", parts[1])
+	}
+	return uint32(node), contractID, nil
+}
+
+// parseContractList parses a comma separated list of contract ids
+func parseContractList(list string) ([]uint64, error) {
+	contracts := make([]uint64, 0)
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid contract id %s", s)
+		}
+		contracts = append(contracts, id)
+	}
+	if len(contracts) == 0 {
+		return nil, errors.New("at least one contract id is required")
+	}
+	return contracts, nil
+}
+
+// importDeployment checks the contract is a valid node contract of the configured twin
+// and gets its deployment from the node
+func importDeployment(ctx context.Context, apiClient *apiClient, contractID uint64) (importedDeployment, error) {
+	sub := apiClient.substrateConn
+	contract, err := sub.GetContract(contractID)
+	if err != nil {
+		return importedDeployment{}, errors.Wrapf(err, "couldn't get contract %d", contractID)
+	}
+	if !contract.IsCreated() {
+		return importedDeployment{}, fmt.Errorf("contract %d is not active", contractID)
+	}
+	if contract.TwinID() != apiClient.twin_id {
+		return importedDeployment{}, fmt.Errorf("contract %d is owned by twin %d not %d", contractID, contract.TwinID(), apiClient.twin_id)
+	}
+	node := contract.NodeID()
+	if node == 0 {
+		return importedDeployment{}, fmt.Errorf("contract %d is not a node contract", contractID)
+	}
+	cl, err := client.NewNodeClientPool(apiClient.rmb).GetNodeClient(sub, node)
+	if err != nil {
+		return importedDeployment{}, errors.Wrapf(err, "couldn't get node %d client", node)
+	}
+	dl, err := cl.DeploymentGet(ctx, contractID)
+	if err != nil {
+		return importedDeployment{}, errors.Wrapf(err, "couldn't get deployment %d from node %d", contractID, node)
+	}
+	imported := importedDeployment{Node: node, ContractID: contractID, Deployment: dl}
+	if err := json.Unmarshal([]byte(contract.DeploymentData()), &imported.Data); err != nil {
+		imported.Data = DeploymentData{}
+	}
+	return imported, nil
+}
+
+// setImportedDeploymentData restores the name and solution type stored on the contract
+func setImportedDeploymentData(d *schema.ResourceData, data DeploymentData) {
+	if data.Name != "" {
+		d.Set("name", data.Name)
+	}
+	if data.ProjectName != "" {
+		d.Set("solution_type", data.ProjectName)
+	}
+}
+
+// importNodeSubnet stores the subnet of the network on the node in the local network state, so
+// deployments on networks that weren't created from this state can be read. The subnet is read
+// from the network workload on the node if the state doesn't have it
+func importNodeSubnet(ctx context.Context, apiClient *apiClient, networkName string, node uint32, ip net.IP) error {
+	if networkName == "" {
+		return nil
+	}
+	network := apiClient.state.GetNetworkState().GetNetwork(networkName)
+	subnet := network.GetNodeSubnet(node)
+	if subnet == "" {
+		var err error
+		subnet, err = nodeNetworkSubnet(ctx, apiClient, networkName, node)
+		if err != nil {
+			return err
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return errors.Wrapf(err, "invalid subnet %s of network %s on node %d", subnet, networkName, node)
+	}
+	if !ipNet.Contains(ip) {
+		return fmt.Errorf("ip %s is not in the subnet %s of network %s on node %d", ip, subnet, networkName, node)
+	}
+	network.SetNodeSubnet(node, subnet)
+	return nil
+}
+
+// nodeNetworkSubnet finds the deployment of the network on the node among the node contracts of
+// the twin and returns the subnet of its network workload
+func nodeNetworkSubnet(ctx context.Context, apiClient *apiClient, networkName string, node uint32) (string, error) {
+	twin, nodeID := uint64(apiClient.twin_id), uint64(node)
+	typ, state := "node", "Created"
+	filter := proxytypes.ContractFilter{TwinID: &twin, NodeID: &nodeID, Type: &typ, State: &state}
+	limit := proxytypes.Limit{Size: 50, Page: 1, RetCount: true}
+	for seen := 0; ; limit.Page++ {
+		contracts, total, err := apiClient.grid_client.Contracts(filter, limit)
+		if err != nil {
+			return "", errors.Wrapf(err, "couldn't list the contracts on node %d", node)
+		}
+		for _, contract := range contracts {
+			details, ok := contract.Details.(proxytypes.NodeContractDetails)
+			if !ok {
+				continue
+			}
+			var data DeploymentData
+			if err := json.Unmarshal([]byte(details.DeploymentData), &data); err != nil || data.Type != "network" || data.Name != networkName {
+				continue
+			}
+			imported, err := importDeployment(ctx, apiClient, uint64(contract.ContractID))
+			if err != nil {
+				return "", err
+			}
+			wl, err := imported.Deployment.Get(gridtypes.Name(networkName))
+			if err != nil || wl.Type != zos.NetworkType {
+				continue
+			}
+			workload, err := wl.WorkloadData()
+			if err != nil {
+				return "", errors.Wrapf(err, "couldn't parse network workload of deployment %d", contract.ContractID)
+			}
+			return workload.(*zos.Network).Subnet.String(), nil
+		}
+		seen += len(contracts)
+		if len(contracts) < int(limit.Size) || seen >= total {
+			break
+		}
+	}
+	return "", fmt.Errorf("network %s isn't deployed on node %d by twin %d, import the network first", networkName, node, apiClient.twin_id)
+}
+
+// readImported reads the imported resource from the grid, the import fails if
+// the read didn't succeed since the state would be incomplete
+func readImported(ctx context.Context, d *schema.ResourceData, meta interface{}, read schema.ReadContextFunc) ([]*schema.ResourceData, error) {
+	diags := read(ctx, d, meta)
+	if len(diags) != 0 {
+		return nil, fmt.Errorf("couldn't read imported resource: %s", diagDetail(diags[0]))
+	}
+	if d.Id() == "" {
+		return nil, errors.New("imported resource doesn't exist anymore")
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+func diagDetail(d diag.Diagnostic) string {
+	if d.Detail != "" {
+		return d.Detail
+	}
+	return d.Summary
+}
@@ -0,0 +1,183 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/gridtest"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
+)
+
+// configureTestProvider configures a provider using the fake grid with its own local state
+func configureTestProvider(t *testing.T, grid *gridtest.Grid) *schema.Provider {
+	st := state.NewState()
+	p := New("test", grid.Substrate, &st)()
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"network":           "custom",
+		"substrate_version": "dev",
+		"substrate_url":     "ws://gridtest",
+		"rmb_proxy_url":     grid.URL(),
+		"rmb_transport":     "proxy",
+		"rmb_poll_interval": "10ms",
+		"mnemonics":         "//Alice",
+		"key_type":          "sr25519",
+	}))
+	assert.False(t, diags.HasError(), "%v", diags)
+	return p
+}
+
+func createTestResource(t *testing.T, p *schema.Provider, typ string, raw map[string]interface{}) *schema.ResourceData {
+	r := p.ResourcesMap[typ]
+	d := schema.TestResourceDataRaw(t, r.Schema, raw)
+	diags := r.CreateContext(context.Background(), d, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	return d
+}
+
+func importTestResource(t *testing.T, p *schema.Provider, typ string, id string) (*schema.ResourceData, error) {
+	r := p.ResourcesMap[typ]
+	d := r.Data(nil)
+	d.SetId(id)
+	res, err := r.Importer.StateContext(context.Background(), d, p.Meta())
+	if err != nil {
+		return nil, err
+	}
+	assert.Len(t, res, 1)
+	return res[0], nil
+}
+
+func TestImport(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":          "net",
+		"nodes":         []interface{}{2},
+		"ip_range":      "10.1.0.0/16",
+		"add_wg_access": true,
+	})
+	deployment := createTestResource(t, p, "grid_deployment", map[string]interface{}{
+		"node":          2,
+		"name":          "web",
+		"network_name":  "net",
+		"solution_type": "Website",
+		"vms": []interface{}{map[string]interface{}{
+			"name":      "vm",
+			"flist":     "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+			"cpu":       1,
+			"memory":    1024,
+			"planetary": true,
+		}},
+		"zdbs": []interface{}{map[string]interface{}{
+			"name":     "zdb",
+			"size":     1,
+			"password": "secret",
+			"mode":     "user",
+		}},
+	})
+	k8s := createTestResource(t, p, "grid_kubernetes", map[string]interface{}{
+		"name":         "cluster",
+		"network_name": "net",
+		"token":        "token1234",
+		"master": []interface{}{map[string]interface{}{
+			"name":      "master",
+			"node":      2,
+			"disk_size": 5,
+			"cpu":       1,
+			"memory":    1024,
+		}},
+		"workers": []interface{}{map[string]interface{}{
+			"name":      "worker",
+			"node":      1,
+			"disk_size": 5,
+			"cpu":       1,
+			"memory":    1024,
+		}},
+	})
+	nameProxy := createTestResource(t, p, "grid_name_proxy", map[string]interface{}{
+		"name":     "example",
+		"node":     1,
+		"backends": []interface{}{"http://185.206.122.40:80"},
+	})
+	fqdnProxy := createTestResource(t, p, "grid_fqdn_proxy", map[string]interface{}{
+		"node":     1,
+		"fqdn":     "example.com",
+		"backends": []interface{}{"http://185.206.122.40:80"},
+	})
+
+	// the subnet of a deployment imported before its network is read from the network workload
+	imported, err := importTestResource(t, configureTestProvider(t, grid), "grid_deployment", "2:"+deployment.Id())
+	assert.NoError(t, err)
+	assert.Equal(t, deployment.Get("ip_range"), imported.Get("ip_range"))
+	assert.Equal(t, deployment.Get("vms.0.ip"), imported.Get("vms.0.ip"))
+
+	// adopt everything from an empty state
+	p = configureTestProvider(t, grid)
+	nodeDeploymentID := network.Get("node_deployment_id").(map[string]interface{})
+	contracts := fmt.Sprintf("%d,%d", nodeDeploymentID["1"], nodeDeploymentID["2"])
+	imported, err = importTestResource(t, p, "grid_network", "net:"+contracts)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.0/16", imported.Get("ip_range"))
+	assert.Equal(t, 1, imported.Get("public_node_id"))
+	assert.Equal(t, true, imported.Get("add_wg_access"))
+	assert.Equal(t, network.Get("external_ip"), imported.Get("external_ip"))
+	assert.Equal(t, network.Get("nodes_ip_range"), imported.Get("nodes_ip_range"))
+	assert.Equal(t, nodeDeploymentID, imported.Get("node_deployment_id"))
+
+	imported, err = importTestResource(t, p, "grid_deployment", "2:"+deployment.Id())
+	assert.NoError(t, err)
+	assert.Equal(t, deployment.Id(), imported.Id())
+	assert.Equal(t, "web", imported.Get("name"))
+	assert.Equal(t, "Website", imported.Get("solution_type"))
+	assert.Equal(t, "net", imported.Get("network_name"))
+	assert.Equal(t, deployment.Get("ip_range"), imported.Get("ip_range"))
+	assert.Equal(t, deployment.Get("vms.0.ip"), imported.Get("vms.0.ip"))
+	assert.Equal(t, deployment.Get("vms.0.ygg_ip"), imported.Get("vms.0.ygg_ip"))
+	assert.Equal(t, 1024, imported.Get("vms.0.memory"))
+	assert.Equal(t, "secret", imported.Get("zdbs.0.password"))
+
+	nodeDeploymentID = k8s.Get("node_deployment_id").(map[string]interface{})
+	imported, err = importTestResource(t, p, "grid_kubernetes", fmt.Sprintf("%d,%d", nodeDeploymentID["2"], nodeDeploymentID["1"]))
+	assert.NoError(t, err)
+	assert.Equal(t, "cluster", imported.Get("name"))
+	assert.Equal(t, "token1234", imported.Get("token"))
+	assert.Equal(t, "master", imported.Get("master.0.name"))
+	assert.Equal(t, 5, imported.Get("master.0.disk_size"))
+	assert.Equal(t, k8s.Get("master.0.ip"), imported.Get("master.0.ip"))
+	assert.Equal(t, "worker", imported.Get("workers.0.name"))
+	assert.Equal(t, 1, imported.Get("workers.0.node"))
+
+	nodeDeploymentID = nameProxy.Get("node_deployment_id").(map[string]interface{})
+	imported, err = importTestResource(t, p, "grid_name_proxy", fmt.Sprintf("1:%d", nodeDeploymentID["1"]))
+	assert.NoError(t, err)
+	assert.Equal(t, "example", imported.Get("name"))
+	assert.Equal(t, nameProxy.Get("name_contract_id"), imported.Get("name_contract_id"))
+	assert.Equal(t, nameProxy.Get("fqdn"), imported.Get("fqdn"))
+	assert.Equal(t, []interface{}{"http://185.206.122.40:80"}, imported.Get("backends"))
+
+	imported, err = importTestResource(t, p, "grid_fqdn_proxy", "1:"+fqdnProxy.Id())
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", imported.Get("fqdn"))
+	assert.Equal(t, []interface{}{"http://185.206.122.40:80"}, imported.Get("backends"))
+
+	// ids pointing to the wrong node or to other resources are rejected
+	_, err = importTestResource(t, p, "grid_deployment", "1:"+deployment.Id())
+	assert.Error(t, err)
+	_, err = importTestResource(t, p, "grid_fqdn_proxy", "2:"+deployment.Id())
+	assert.Error(t, err)
+	_, err = importTestResource(t, p, "grid_network", "other:"+contracts)
+	assert.Error(t, err)
+	_, err = importTestResource(t, p, "grid_deployment", deployment.Id())
+	assert.Error(t, err)
+
+	// without the network on the node the subnet is unknown
+	apiClient := p.Meta().(*apiClient)
+	nodeDeploymentID = network.Get("node_deployment_id").(map[string]interface{})
+	assert.NoError(t, grid.Substrate.CancelContract(apiClient.identity, uint64(nodeDeploymentID["2"].(int))))
+	_, err = importTestResource(t, configureTestProvider(t, grid), "grid_deployment", "2:"+deployment.Id())
+	assert.ErrorContains(t, err, "import the network first")
+}
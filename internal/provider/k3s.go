@@ -0,0 +1,326 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/pkg/errors"
+)
+
+var (
+	k3sVersionRegex = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-rc[0-9]+)?\+k3s[0-9]+$`)
+	k3sChannelRegex = regexp.MustCompile(`^(stable|latest|testing|v[0-9]+\.[0-9]+)$`)
+	// k3sLabelKeyRegex is an optional dns subdomain prefix followed by a name, like kubernetes label keys
+	k3sLabelKeyRegex   = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	k3sLabelValueRegex = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+	k3sTaintRegex      = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?(=([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?)?:(NoSchedule|PreferNoSchedule|NoExecute)$`)
+)
+
+var (
+	k3sFlannelBackends = []string{"vxlan", "host-gw", "wireguard-native", "none"}
+	k3sComponents      = []string{"traefik", "servicelb", "local-storage", "metrics-server", "coredns"}
+	// k3sComponentDeployments are the kube-system deployments of the components, servicelb only has daemonsets
+	// created for the load balancer services
+	k3sComponentDeployments = map[string]string{
+		"traefik":        "traefik",
+		"local-storage":  "local-path-provisioner",
+		"metrics-server": "metrics-server",
+		"coredns":        "coredns",
+	}
+	// k3sManagedFlags are set by the provider or through their own attributes, they can't be passed as extra args
+	k3sManagedFlags = []string{
+		"token", "token-file", "server", "cluster-init", "data-dir", "node-name",
+		"flannel-iface", "flannel-backend", "disable", "node-label", "node-taint",
+	}
+	// k3sCheckedAttributes are the attributes whose changes are checked on the cluster nodes after an update
+	k3sCheckedAttributes = []string{"master", "masters", "workers", "node_pool", "k3s_version", "flannel_backend", "disable"}
+)
+
+const (
+	// k3sEtcdRole and k3sControlPlaneRole are the labels k3s gives to the servers running the embedded etcd
+	k3sEtcdRole         = "node-role.kubernetes.io/etcd"
+	k3sControlPlaneRole = "node-role.kubernetes.io/control-plane"
+	// k3sFlannelBackendAnnotation is the flannel backend of the node, vxlan unless another one is set
+	k3sFlannelBackendAnnotation = "flannel.alpha.coreos.com/backend-type"
+)
+
+// k3sNode is the part of a kubernetes node the k3s settings passed to the flist are checked against
+type k3sNode struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Taints []struct {
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+			Effect string `json:"effect"`
+		} `json:"taints"`
+	} `json:"spec"`
+	Status struct {
+		NodeInfo struct {
+			KubeletVersion string `json:"kubeletVersion"`
+		} `json:"nodeInfo"`
+	} `json:"status"`
+}
+
+// hasTaint checks the node has the taint, given like key=value:Effect
+func (n *k3sNode) hasTaint(taint string) bool {
+	idx := strings.LastIndex(taint, ":")
+	key, effect := taint[:idx], taint[idx+1:]
+	value := ""
+	if parts := strings.SplitN(key, "=", 2); len(parts) == 2 {
+		key, value = parts[0], parts[1]
+	}
+	for _, t := range n.Spec.Taints {
+		if t.Key == key && t.Value == value && t.Effect == effect {
+			return true
+		}
+	}
+	return false
+}
+
+// k3sCluster is the state of the cluster as seen by k3s on a master
+type k3sCluster struct {
+	Nodes map[string]k3sNode
+	// Deployments are the names of the deployments of the kube-system namespace
+	Deployments []string
+}
+
+// parseK3sNodes parses the output of kubectl get nodes -o json, the nodes are keyed by name
+func parseK3sNodes(out string) (map[string]k3sNode, error) {
+	var list struct {
+		Items []k3sNode `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, errors.Wrap(err, "couldn't parse the kubernetes nodes")
+	}
+	nodes := make(map[string]k3sNode, len(list.Items))
+	for _, node := range list.Items {
+		nodes[node.Metadata.Name] = node
+	}
+	return nodes, nil
+}
+
+// K3sConfig is the configuration of k3s on the cluster nodes
+type K3sConfig struct {
+	// Version is a k3s release or a release channel
+	Version        string
+	FlannelBackend string
+	Disable        []string
+	ServerArgs     []string
+	AgentArgs      []string
+	// TLSSAN is the host of the api endpoint, added to the certificate of the masters for the kubeconfig
+	TLSSAN string
+}
+
+func NewK3sConfig(d *schema.ResourceData) K3sConfig {
+	disable := make([]string, 0)
+	for _, c := range d.Get("disable").(*schema.Set).List() {
+		disable = append(disable, c.(string))
+	}
+	sort.Strings(disable)
+	tlsSAN := d.Get("api_endpoint").(string)
+	if host, _, err := net.SplitHostPort(tlsSAN); err == nil {
+		tlsSAN = host
+	}
+	return K3sConfig{
+		Version:        d.Get("k3s_version").(string),
+		FlannelBackend: d.Get("flannel_backend").(string),
+		Disable:        disable,
+		ServerArgs:     stringList(d.Get("server_args").([]interface{})),
+		AgentArgs:      stringList(d.Get("agent_args").([]interface{})),
+		TLSSAN:         strings.Trim(tlsSAN, "[]"),
+	}
+}
+
+func stringList(l []interface{}) []string {
+	res := make([]string, 0, len(l))
+	for _, s := range l {
+		res = append(res, s.(string))
+	}
+	return res
+}
+
+// env adds the k3s configuration of the node to its env vars. Nothing is added for the defaults
+// so the vms of the clusters without a configuration stay the same
+func (c *K3sConfig) env(env map[string]string, node *K8sNodeData, server bool) map[string]string {
+	if k3sChannelRegex.MatchString(c.Version) {
+		env["INSTALL_K3S_CHANNEL"] = c.Version
+	} else if c.Version != "" {
+		env["INSTALL_K3S_VERSION"] = c.Version
+	}
+	if args := c.args(node, server); len(args) != 0 {
+		env["K3S_EXTRA_ARGS"] = strings.Join(args, " ")
+	}
+	return env
+}
+
+// args returns the extra args of k3s on the node, the server only flags are passed to the masters only
+func (c *K3sConfig) args(node *K8sNodeData, server bool) []string {
+	args := make([]string, 0)
+	if server && c.FlannelBackend != "" {
+		args = append(args, fmt.Sprintf("--flannel-backend=%s", c.FlannelBackend))
+	}
+	if server {
+		for _, component := range c.Disable {
+			args = append(args, fmt.Sprintf("--disable=%s", component))
+		}
+		if c.TLSSAN != "" {
+			args = append(args, fmt.Sprintf("--tls-san=%s", c.TLSSAN))
+		}
+	}
+	keys := make([]string, 0, len(node.Labels))
+	for key := range node.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("--node-label=%s=%s", key, node.Labels[key]))
+	}
+	for _, taint := range node.Taints {
+		args = append(args, fmt.Sprintf("--node-taint=%s", taint))
+	}
+	if server {
+		return append(args, c.ServerArgs...)
+	}
+	return append(args, c.AgentArgs...)
+}
+
+// mismatches returns the settings the node doesn't have. The release channels and the extra args can't be checked
+func (c *K3sConfig) mismatches(node *K8sNodeData, k3s *k3sNode, server bool) []string {
+	mismatches := make([]string, 0)
+	if k3sVersionRegex.MatchString(c.Version) && k3s.Status.NodeInfo.KubeletVersion != c.Version {
+		mismatches = append(mismatches, fmt.Sprintf("%s runs k3s %s instead of %s, INSTALL_K3S_VERSION was ignored", node.Name, k3s.Status.NodeInfo.KubeletVersion, c.Version))
+	}
+	// a node ignoring the flannel backend keeps the default one
+	if server && c.FlannelBackend != "" && c.FlannelBackend != "vxlan" && k3s.Metadata.Annotations[k3sFlannelBackendAnnotation] == "vxlan" {
+		mismatches = append(mismatches, fmt.Sprintf("%s uses the vxlan flannel backend instead of %s, K3S_EXTRA_ARGS was ignored", node.Name, c.FlannelBackend))
+	}
+	keys := make([]string, 0, len(node.Labels))
+	for key := range node.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := k3s.Metadata.Labels[key]; !ok || value != node.Labels[key] {
+			mismatches = append(mismatches, fmt.Sprintf("%s doesn't have the label %s=%s, K3S_EXTRA_ARGS was ignored", node.Name, key, node.Labels[key]))
+		}
+	}
+	for _, taint := range node.Taints {
+		if !k3s.hasTaint(taint) {
+			mismatches = append(mismatches, fmt.Sprintf("%s doesn't have the taint %s, K3S_EXTRA_ARGS was ignored", node.Name, taint))
+		}
+	}
+	return mismatches
+}
+
+// enabled returns the disabled components that are deployed anyway
+func (c *K3sConfig) enabled(deployments []string) []string {
+	enabled := make([]string, 0)
+	for _, component := range c.Disable {
+		if name, ok := k3sComponentDeployments[component]; ok && isInStr(deployments, name) {
+			enabled = append(enabled, component)
+		}
+	}
+	return enabled
+}
+
+func validateK3sVersion(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	if !k3sVersionRegex.MatchString(v) && !k3sChannelRegex.MatchString(v) {
+		return nil, []error{fmt.Errorf("%s must be a k3s release like v1.26.4+k3s1 or a channel (stable, latest, testing or v<major>.<minor>), got %s", k, v)}
+	}
+	return nil, nil
+}
+
+// validateK3sArg checks that the extra arg is a single flag the provider doesn't manage
+func validateK3sArg(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	if !strings.HasPrefix(v, "--") || strings.ContainsAny(v, " \t\n") {
+		return nil, []error{fmt.Errorf("%s must be a single flag like --flag=value, got %q", k, v)}
+	}
+	flag := strings.SplitN(strings.TrimPrefix(v, "--"), "=", 2)[0]
+	for _, managed := range k3sManagedFlags {
+		if flag == managed {
+			return nil, []error{fmt.Errorf("%s can't set --%s, it's set by the provider or through its own attribute", k, flag)}
+		}
+	}
+	return nil, nil
+}
+
+func validateK3sLabels(i interface{}, k string) ([]string, []error) {
+	labels, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be map", k)}
+	}
+	errs := make([]error, 0)
+	for key, value := range labels {
+		if !k3sLabelKeyRegex.MatchString(key) {
+			errs = append(errs, fmt.Errorf("%s has an invalid label key %s", k, key))
+		}
+		if v, ok := value.(string); !ok || !k3sLabelValueRegex.MatchString(v) {
+			errs = append(errs, fmt.Errorf("%s has an invalid value %v for label %s", k, value, key))
+		}
+	}
+	return nil, errs
+}
+
+func k3sLabelsSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:         schema.TypeMap,
+		Optional:     true,
+		Elem:         &schema.Schema{Type: schema.TypeString},
+		ValidateFunc: validateK3sLabels,
+		Description:  "Kubernetes labels of the node",
+	}
+}
+
+func k3sTaintsSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		Elem: &schema.Schema{
+			Type:         schema.TypeString,
+			ValidateFunc: validation.StringMatch(k3sTaintRegex, "must be a taint like key=value:NoSchedule, the effect is NoSchedule, PreferNoSchedule or NoExecute"),
+		},
+		Description: "Kubernetes taints of the node like key=value:NoSchedule",
+	}
+}
+
+func stringMap(m map[string]interface{}) map[string]string {
+	res := make(map[string]string, len(m))
+	for key, value := range m {
+		res[key] = value.(string)
+	}
+	return res
+}
+
+func interfaceMap(m map[string]string) map[string]interface{} {
+	res := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		res[key] = value
+	}
+	return res
+}
+
+func interfaceList(l []string) []interface{} {
+	res := make([]interface{}, 0, len(l))
+	for _, s := range l {
+		res = append(res, s)
+	}
+	return res
+}
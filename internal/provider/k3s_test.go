@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateK3sVersion(t *testing.T) {
+	for _, v := range []string{"v1.26.4+k3s1", "v1.27.1-rc2+k3s1", "stable", "latest", "v1.26"} {
+		_, errs := validateK3sVersion(v, "k3s_version")
+		assert.Empty(t, errs, v)
+	}
+	for _, v := range []string{"1.26.4", "v1.26.4", "edge", "v1", ""} {
+		_, errs := validateK3sVersion(v, "k3s_version")
+		assert.NotEmpty(t, errs, v)
+	}
+}
+
+func TestValidateK3sArg(t *testing.T) {
+	for _, v := range []string{"--kube-apiserver-arg=v=2", "--write-kubeconfig-mode=644", "--secrets-encryption"} {
+		_, errs := validateK3sArg(v, "server_args.0")
+		assert.Empty(t, errs, v)
+	}
+	for _, v := range []string{"kubelet-arg=max-pods=200", "--node-label=a=b", "--token=abc", "--disable=traefik", "--tls-san=a --tls-san=b"} {
+		_, errs := validateK3sArg(v, "server_args.0")
+		assert.NotEmpty(t, errs, v)
+	}
+}
+
+func TestValidateK3sLabels(t *testing.T) {
+	_, errs := validateK3sLabels(map[string]interface{}{"tier": "db", "topology.kubernetes.io/zone": "eu-1", "empty": ""}, "labels")
+	assert.Empty(t, errs)
+	_, errs = validateK3sLabels(map[string]interface{}{"Bad_Prefix/tier": "db", "tier": "not valid"}, "labels")
+	assert.Len(t, errs, 2)
+
+	for _, taint := range []string{"dedicated=db:NoSchedule", "gpu:NoExecute", "example.com/spot=:PreferNoSchedule"} {
+		assert.True(t, k3sTaintRegex.MatchString(taint), taint)
+	}
+	for _, taint := range []string{"dedicated=db", "gpu:Never", "=db:NoSchedule"} {
+		assert.False(t, k3sTaintRegex.MatchString(taint), taint)
+	}
+}
+
+func TestK3sConfigEnv(t *testing.T) {
+	node := K8sNodeData{Labels: map[string]string{"tier": "db", "disk": "ssd"}, Taints: []string{"dedicated=db:NoSchedule"}}
+	c := K3sConfig{}
+	// the defaults don't change the env vars
+	assert.Equal(t, map[string]string{"K3S_URL": ""}, c.env(map[string]string{"K3S_URL": ""}, &K8sNodeData{}, true))
+
+	c = K3sConfig{
+		Version:        "v1.26.4+k3s1",
+		FlannelBackend: "wireguard-native",
+		Disable:        []string{"servicelb", "traefik"},
+		ServerArgs:     []string{"--write-kubeconfig-mode=644"},
+		AgentArgs:      []string{"--kubelet-arg=max-pods=200"},
+	}
+	env := c.env(map[string]string{}, &node, true)
+	assert.Equal(t, "v1.26.4+k3s1", env["INSTALL_K3S_VERSION"])
+	assert.Equal(t, "--flannel-backend=wireguard-native --disable=servicelb --disable=traefik --node-label=disk=ssd --node-label=tier=db --node-taint=dedicated=db:NoSchedule --write-kubeconfig-mode=644", env["K3S_EXTRA_ARGS"])
+
+	// the agents get the node flags and the agent args only
+	c.Version = "stable"
+	env = c.env(map[string]string{}, &node, false)
+	assert.Equal(t, "stable", env["INSTALL_K3S_CHANNEL"])
+	assert.NotContains(t, env, "INSTALL_K3S_VERSION")
+	assert.Equal(t, "--node-label=disk=ssd --node-label=tier=db --node-taint=dedicated=db:NoSchedule --kubelet-arg=max-pods=200", env["K3S_EXTRA_ARGS"])
+
+	// the api endpoint is added to the certificate of the masters only
+	c = K3sConfig{TLSSAN: "k8s.example.com"}
+	assert.Equal(t, "--tls-san=k8s.example.com", c.env(map[string]string{}, &K8sNodeData{}, true)["K3S_EXTRA_ARGS"])
+	assert.NotContains(t, c.env(map[string]string{}, &K8sNodeData{}, false), "K3S_EXTRA_ARGS")
+}
+
+func TestK3sMismatches(t *testing.T) {
+	nodes, err := parseK3sNodes(`{"items": [
+		{"metadata": {"name": "m1", "labels": {"node-role.kubernetes.io/control-plane": "true", "node-role.kubernetes.io/etcd": "true"}}},
+		{"metadata": {"name": "m2", "labels": {"node-role.kubernetes.io/control-plane": "true"}}},
+		{"metadata": {"name": "m3", "labels": {}}}
+	]}`)
+	assert.NoError(t, err)
+	k := K8sDeployer{
+		HA:      true,
+		Masters: []K8sNodeData{{Name: "m1"}, {Name: "m2"}, {Name: "m3"}},
+		Workers: []K8sNodeData{{Name: "w1"}},
+	}
+	missing, mismatches := k.k3sMismatches(k3sCluster{Nodes: nodes})
+	assert.Equal(t, []string{"w1"}, missing)
+	assert.Equal(t, []string{
+		"master m2 doesn't run the embedded etcd, K3S_MASTER was ignored",
+		"master m3 isn't a server, K3S_MASTER was ignored",
+	}, mismatches)
+
+	// the single master isn't checked for the etcd
+	k = K8sDeployer{Masters: []K8sNodeData{{Name: "m3"}}}
+	missing, mismatches = k.k3sMismatches(k3sCluster{Nodes: nodes})
+	assert.Empty(t, missing)
+	assert.Empty(t, mismatches)
+}
+
+func TestK3sConfigMismatches(t *testing.T) {
+	nodes, err := parseK3sNodes(`{"items": [
+		{
+			"metadata": {"name": "m", "labels": {"tier": "db"}, "annotations": {"flannel.alpha.coreos.com/backend-type": "vxlan"}},
+			"spec": {"taints": [{"key": "dedicated", "value": "db", "effect": "NoSchedule"}]},
+			"status": {"nodeInfo": {"kubeletVersion": "v1.25.9+k3s1"}}
+		},
+		{
+			"metadata": {"name": "w", "labels": {}, "annotations": {"flannel.alpha.coreos.com/backend-type": "vxlan"}},
+			"status": {"nodeInfo": {"kubeletVersion": "v1.26.4+k3s1"}}
+		}
+	]}`)
+	assert.NoError(t, err)
+	k := K8sDeployer{
+		Masters: []K8sNodeData{{Name: "m", Labels: map[string]string{"tier": "db"}, Taints: []string{"dedicated=db:NoSchedule"}}},
+		Workers: []K8sNodeData{{Name: "w", Labels: map[string]string{"disk": "ssd"}, Taints: []string{"gpu:NoExecute"}}},
+		K3s:     K3sConfig{Version: "v1.26.4+k3s1", FlannelBackend: "host-gw", Disable: []string{"servicelb", "traefik"}},
+	}
+	assert.True(t, k.checksK3s())
+	missing, mismatches := k.k3sMismatches(k3sCluster{Nodes: nodes, Deployments: []string{"coredns", "traefik"}})
+	assert.Empty(t, missing)
+	assert.Equal(t, []string{
+		"m runs k3s v1.25.9+k3s1 instead of v1.26.4+k3s1, INSTALL_K3S_VERSION was ignored",
+		"m uses the vxlan flannel backend instead of host-gw, K3S_EXTRA_ARGS was ignored",
+		"w doesn't have the label disk=ssd, K3S_EXTRA_ARGS was ignored",
+		"w doesn't have the taint gpu:NoExecute, K3S_EXTRA_ARGS was ignored",
+		"traefik is deployed although it's disabled, K3S_EXTRA_ARGS was ignored",
+	}, mismatches)
+
+	// channels and extra args can't be checked
+	k.K3s = K3sConfig{Version: "stable", ServerArgs: []string{"--write-kubeconfig-mode=644"}}
+	k.Masters[0].Labels, k.Masters[0].Taints = nil, nil
+	k.Workers[0].Labels, k.Workers[0].Taints = nil, nil
+	assert.False(t, k.checksK3s())
+}
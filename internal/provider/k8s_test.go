@@ -0,0 +1,361 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/gridtest"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+func testK8sNode(name string, node int) map[string]interface{} {
+	return map[string]interface{}{
+		"name":      name,
+		"node":      node,
+		"disk_size": 5,
+		"cpu":       1,
+		"memory":    1024,
+	}
+}
+
+// k8sEnv returns the env vars of the vm called name of the cluster
+func k8sEnv(t *testing.T, grid *gridtest.Grid, k8s *schema.ResourceData, name string) map[string]string {
+	for node, id := range k8s.Get("node_deployment_id").(map[string]interface{}) {
+		nodeID, err := strconv.ParseUint(node, 10, 32)
+		assert.NoError(t, err)
+		dl, ok := grid.Node(uint32(nodeID)).Deployment(uint64(id.(int)))
+		assert.True(t, ok)
+		for _, wl := range dl.Workloads {
+			if wl.Type != zos.ZMachineType || string(wl.Name) != name {
+				continue
+			}
+			data, err := wl.WorkloadData()
+			assert.NoError(t, err)
+			return data.(*zos.ZMachine).Env
+		}
+	}
+	t.Fatalf("couldn't find vm %s", name)
+	return nil
+}
+
+func TestK8sMasters(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	farm := grid.AddFarm("k8sfarm")
+	capacity := gridtypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 512 * gridtypes.Gigabyte}
+	_, err := grid.AddNode(farm, capacity, nil)
+	assert.NoError(t, err)
+	p := configureTestProvider(t, grid)
+	createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "net",
+		"nodes":    []interface{}{1, 2, 3},
+		"ip_range": "10.1.0.0/16",
+	})
+
+	k8s := createTestResource(t, p, "grid_kubernetes", map[string]interface{}{
+		"name":         "cluster",
+		"network_name": "net",
+		"token":        "token1234",
+		"ssh_key":      "ssh-rsa AAAA",
+		"api_endpoint": "k8s.example.com",
+		"masters": []interface{}{
+			testK8sNode("m1", 1),
+			testK8sNode("m2", 2),
+			testK8sNode("m3", 3),
+		},
+		"workers": []interface{}{testK8sNode("w1", 1)},
+	})
+	assert.Len(t, k8s.Get("masters"), 3)
+	assert.Empty(t, k8s.Get("master"))
+
+	// the first master initializes the cluster, the others join it through its ip
+	env := k8sEnv(t, grid, k8s, "m1")
+	assert.Equal(t, "", env["K3S_URL"])
+	assert.Equal(t, "true", env["K3S_CLUSTER_INIT"])
+	for _, name := range []string{"m2", "m3"} {
+		env = k8sEnv(t, grid, k8s, name)
+		assert.Equal(t, fmt.Sprintf("https://%s:6443", k8s.Get("masters.0.ip")), env["K3S_URL"])
+		assert.Equal(t, "true", env["K3S_MASTER"])
+		assert.NotContains(t, env, "K3S_CLUSTER_INIT")
+	}
+	env = k8sEnv(t, grid, k8s, "w1")
+	assert.Equal(t, "https://k8s.example.com:6443", env["K3S_URL"])
+	assert.NotContains(t, env, "K3S_MASTER")
+
+	// the generated key is authorized next to ssh_key, the masters have no public ip to fetch the kubeconfig
+	pub, err := sshPublicKey(k8s.Get("generated_ssh_private_key").(string))
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-rsa AAAA\n"+pub, env["SSH_KEY"])
+	assert.NotContains(t, env, "K3S_EXTRA_ARGS")
+	assert.NotContains(t, env, "INSTALL_K3S_VERSION")
+	assert.Empty(t, k8s.Get("kubeconfig"))
+	diags := p.ResourcesMap["grid_kubernetes"].ReadContext(context.Background(), k8s, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, "ssh-rsa AAAA", k8s.Get("ssh_key"))
+
+	nodeDeploymentID := k8s.Get("node_deployment_id").(map[string]interface{})
+	imported, err := importTestResource(t, p, "grid_kubernetes", fmt.Sprintf("%d,%d,%d", nodeDeploymentID["1"], nodeDeploymentID["2"], nodeDeploymentID["3"]))
+	assert.NoError(t, err)
+	assert.Equal(t, "k8s.example.com", imported.Get("api_endpoint"))
+	assert.Equal(t, "m1", imported.Get("masters.0.name"))
+	assert.Len(t, imported.Get("masters"), 3)
+	assert.Len(t, imported.Get("workers"), 1)
+}
+
+func TestK8sValidateMasters(t *testing.T) {
+	masters := func(nodes ...uint32) []K8sNodeData {
+		res := make([]K8sNodeData, 0)
+		for idx, node := range nodes {
+			res = append(res, K8sNodeData{Name: fmt.Sprintf("m%d", idx), Node: node})
+		}
+		return res
+	}
+	k := K8sDeployer{HA: true, APIEndpoint: "k8s.example.com", Masters: masters(1, 2, 3)}
+	assert.NoError(t, k.validateMasters())
+
+	k.Masters = masters(1, 2, 3, 4)
+	assert.Error(t, k.validateMasters())
+
+	k.Masters = masters(1, 2, 2)
+	assert.Error(t, k.validateMasters())
+
+	k.Masters = masters(1, 2, 3)
+	k.APIEndpoint = ""
+	assert.Error(t, k.validateMasters())
+
+	k.HA = false
+	k.Masters = masters(1)
+	assert.NoError(t, k.validateMasters())
+
+	assert.Equal(t, "https://10.1.2.2:6443", k3sURL("10.1.2.2"))
+	assert.Equal(t, "https://k8s.example.com:443", k3sURL("k8s.example.com:443"))
+	assert.Equal(t, "https://[fd00::2]:6443", k3sURL("fd00::2"))
+
+	// the kubeconfig goes through the api endpoint instead of the master it's fetched from
+	assert.Equal(t, "185.206.122.40", k.kubeconfigServer("185.206.122.40"))
+	k.APIEndpoint = "k8s.example.com:443"
+	assert.Equal(t, "k8s.example.com:443", k.kubeconfigServer("185.206.122.40"))
+}
+
+func TestK8sMastersSSH(t *testing.T) {
+	key, err := generateSSHKey()
+	assert.NoError(t, err)
+	k := K8sDeployer{
+		Masters: []K8sNodeData{
+			{Name: "m0", ComputedIP: "185.206.122.40/24"},
+			{Name: "m1"},
+			{Name: "m2", YggIP: "300:e9c4:9048:57cf::1"},
+		},
+		secrets:  &k8sSecrets{SSHPrivateKey: key},
+		hostKeys: newKnownHosts(nil),
+	}
+	addrs, _, err := k.mastersSSH(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"185.206.122.40:22", "[300:e9c4:9048:57cf::1]:22"}, addrs)
+
+	// the updated master is tried last
+	addrs, _, err = k.mastersSSH([]string{"m0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[300:e9c4:9048:57cf::1]:22", "185.206.122.40:22"}, addrs)
+
+	k.Masters = []K8sNodeData{{Name: "m1"}}
+	_, _, err = k.masterSSH()
+	assert.Error(t, err)
+}
+
+func TestK8sNodePool(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	farm := grid.AddFarm("poolfarm")
+	capacity := gridtypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 512 * gridtypes.Gigabyte}
+	_, err := grid.AddNode(farm, capacity, nil)
+	assert.NoError(t, err)
+	p := configureTestProvider(t, grid)
+	createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":        "net",
+		"nodes":       []interface{}{1},
+		"ip_range":    "10.1.0.0/16",
+		"auto_extend": true,
+	})
+	pool := map[string]interface{}{
+		"name":      "pool",
+		"count":     3,
+		"cpu":       1,
+		"memory":    1024,
+		"disk_size": 5,
+		"farm":      "poolfarm",
+	}
+	k8s := createTestResource(t, p, "grid_kubernetes", map[string]interface{}{
+		"name":         "cluster",
+		"network_name": "net",
+		"token":        "token1234",
+		"master":       []interface{}{testK8sNode("master", 1)},
+		"node_pool":    []interface{}{pool},
+	})
+	// the workers are placed on the only node of the farm
+	assert.Len(t, k8s.Get("node_pool.0.workers"), 3)
+	for idx := 0; idx < 3; idx++ {
+		assert.Equal(t, fmt.Sprintf("pool%d", idx), k8s.Get(fmt.Sprintf("node_pool.0.workers.%d.name", idx)))
+		assert.Equal(t, 3, k8s.Get(fmt.Sprintf("node_pool.0.workers.%d.node", idx)))
+		assert.NotEmpty(t, k8s.Get(fmt.Sprintf("node_pool.0.workers.%d.ip", idx)))
+		env := k8sEnv(t, grid, k8s, fmt.Sprintf("pool%d", idx))
+		assert.Equal(t, fmt.Sprintf("https://%s:6443", k8s.Get("master.0.ip")), env["K3S_URL"])
+	}
+	assert.Empty(t, k8s.Get("workers"))
+	ip := k8s.Get("node_pool.0.workers.0.ip")
+
+	// scaling down removes the newest workers, the others are kept as they are
+	r := p.ResourcesMap["grid_kubernetes"]
+	k8s = r.Data(k8s.State())
+	pool["count"] = 1
+	assert.NoError(t, k8s.Set("node_pool", []interface{}{pool}))
+	diags := r.UpdateContext(context.Background(), k8s, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	// the masters aren't reachable to drain the removed workers
+	summaries := make([]string, 0)
+	for _, d := range diags {
+		summaries = append(summaries, d.Summary)
+	}
+	assert.Contains(t, summaries, "Couldn't drain the removed workers")
+	assert.Len(t, k8s.Get("node_pool.0.workers"), 1)
+	assert.Equal(t, "pool0", k8s.Get("node_pool.0.workers.0.name"))
+	assert.Equal(t, ip, k8s.Get("node_pool.0.workers.0.ip"))
+	dl, ok := grid.Node(3).Deployment(uint64(k8s.Get("node_deployment_id.3").(int)))
+	assert.True(t, ok)
+	names := make([]string, 0)
+	for _, wl := range dl.Workloads {
+		if wl.Type == zos.ZMachineType {
+			names = append(names, string(wl.Name))
+		}
+	}
+	assert.Equal(t, []string{"pool0"}, names)
+
+	// the pools need a farm with enough capacity
+	k8s = r.Data(k8s.State())
+	pool["count"] = 2
+	pool["memory"] = 64 * 1024
+	assert.NoError(t, k8s.Set("node_pool", []interface{}{pool}))
+	diags = r.UpdateContext(context.Background(), k8s, p.Meta())
+	assert.True(t, diags.HasError())
+
+	// nor more vcpus than the cores of its nodes
+	k8s = r.Data(k8s.State())
+	pool["memory"] = 1024
+	pool["cpu"] = 16
+	assert.NoError(t, k8s.Set("node_pool", []interface{}{pool}))
+	diags = r.UpdateContext(context.Background(), k8s, p.Meta())
+	if assert.True(t, diags.HasError()) {
+		assert.Contains(t, diags[0].Summary, "couldn't find a node for worker")
+	}
+
+	// and nodes with a public ipv4 for the public ips
+	k8s = r.Data(k8s.State())
+	pool["cpu"] = 1
+	pool["publicip"] = true
+	assert.NoError(t, k8s.Set("node_pool", []interface{}{pool}))
+	diags = r.UpdateContext(context.Background(), k8s, p.Meta())
+	if assert.True(t, diags.HasError()) {
+		assert.Contains(t, diags[0].Summary, "couldn't find a node for worker")
+	}
+}
+
+func TestK8sPoolName(t *testing.T) {
+	for _, name := range []string{"pool", "w", "gpu_pool", "w1_"} {
+		assert.True(t, k8sPoolNameRegex.MatchString(name), name)
+	}
+	// w1 worker 0 and w worker 10 would both be w10
+	for _, name := range []string{"w1", "pool-a", ""} {
+		assert.False(t, k8sPoolNameRegex.MatchString(name), name)
+	}
+}
+
+func TestK8sK3sConfig(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "net",
+		"nodes":    []interface{}{1, 2},
+		"ip_range": "10.1.0.0/16",
+	})
+	worker := testK8sNode("w1", 2)
+	worker["labels"] = map[string]interface{}{"tier": "db"}
+	worker["taints"] = []interface{}{"dedicated=db:NoSchedule"}
+	k8s := createTestResource(t, p, "grid_kubernetes", map[string]interface{}{
+		"name":            "cluster",
+		"network_name":    "net",
+		"token":           "token1234",
+		"k3s_version":     "v1.26.4+k3s1",
+		"flannel_backend": "host-gw",
+		"disable":         []interface{}{"traefik", "servicelb"},
+		"agent_args":      []interface{}{"--kubelet-arg=max-pods=200"},
+		"master":          []interface{}{testK8sNode("master", 1)},
+		"workers":         []interface{}{worker, testK8sNode("w2", 2)},
+	})
+
+	env := k8sEnv(t, grid, k8s, "master")
+	assert.Equal(t, "v1.26.4+k3s1", env["INSTALL_K3S_VERSION"])
+	assert.Equal(t, "--flannel-backend=host-gw --disable=servicelb --disable=traefik", env["K3S_EXTRA_ARGS"])
+	env = k8sEnv(t, grid, k8s, "w1")
+	assert.Equal(t, "v1.26.4+k3s1", env["INSTALL_K3S_VERSION"])
+	assert.Equal(t, "--node-label=tier=db --node-taint=dedicated=db:NoSchedule --kubelet-arg=max-pods=200", env["K3S_EXTRA_ARGS"])
+	env = k8sEnv(t, grid, k8s, "w2")
+	assert.Equal(t, "--kubelet-arg=max-pods=200", env["K3S_EXTRA_ARGS"])
+
+	// the labels and taints are kept when the state is read from the deployments
+	diags := p.ResourcesMap["grid_kubernetes"].ReadContext(context.Background(), k8s, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, map[string]interface{}{"tier": "db"}, k8s.Get("workers.0.labels"))
+	assert.Equal(t, []interface{}{"dedicated=db:NoSchedule"}, k8s.Get("workers.0.taints"))
+	assert.Empty(t, k8s.Get("workers.1.labels"))
+}
+
+func TestK8sRollingUpdate(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	farm := grid.AddFarm("k8sfarm")
+	capacity := gridtypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 512 * gridtypes.Gigabyte}
+	_, err := grid.AddNode(farm, capacity, nil)
+	assert.NoError(t, err)
+	p := configureTestProvider(t, grid)
+	createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "net",
+		"nodes":    []interface{}{1, 2, 3},
+		"ip_range": "10.1.0.0/16",
+	})
+	rollingUpdate := map[string]interface{}{"max_unavailable": 1, "wait_for_ready": false}
+	workers := []interface{}{testK8sNode("w1", 2), testK8sNode("w2", 3)}
+	k8s := createTestResource(t, p, "grid_kubernetes", map[string]interface{}{
+		"name":           "cluster",
+		"network_name":   "net",
+		"token":          "token1234",
+		"master":         []interface{}{testK8sNode("master", 1)},
+		"workers":        workers,
+		"rolling_update": []interface{}{rollingUpdate},
+	})
+
+	// every worker is updated, the master isn't changed
+	r := p.ResourcesMap["grid_kubernetes"]
+	k8s = r.Data(k8s.State())
+	for _, w := range workers {
+		w.(map[string]interface{})["memory"] = 2048
+	}
+	assert.NoError(t, k8s.Set("workers", workers))
+	diags := r.UpdateContext(context.Background(), k8s, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	for node, version := range map[uint32]uint32{1: 0, 2: 1, 3: 1} {
+		dl, ok := grid.Node(node).Deployment(uint64(k8s.Get(fmt.Sprintf("node_deployment_id.%d", node)).(int)))
+		assert.True(t, ok)
+		assert.Equal(t, version, dl.Version, "node %d", node)
+	}
+	assert.Equal(t, 2048, k8s.Get("workers.1.memory"))
+
+	// the masters have to be reachable to wait for the nodes
+	k8s = r.Data(k8s.State())
+	rollingUpdate["wait_for_ready"] = true
+	assert.NoError(t, k8s.Set("rolling_update", []interface{}{rollingUpdate}))
+	diags = r.UpdateContext(context.Background(), k8s, p.Meta())
+	assert.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "wait_for_ready")
+}
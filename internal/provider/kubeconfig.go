@@ -0,0 +1,192 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	kubeconfigPath = "/etc/rancher/k3s/k3s.yaml"
+	// kubeconfigTimeout is how long the provider waits for the master to write the kubeconfig
+	kubeconfigTimeout       = 5 * time.Minute
+	kubeconfigRetryInterval = 5 * time.Second
+	// drainTimeout is how long kubectl waits for the pods of a removed worker to be evicted
+	drainTimeout = 2 * time.Minute
+	// rejoinTimeout is how long the provider waits for the nodes of an updated deployment to be Ready again
+	rejoinTimeout = 5 * time.Minute
+)
+
+var kubeconfigServerRegex = regexp.MustCompile(`(?m)^([ \t]*server:[ \t]*)\S+[ \t]*$`)
+
+// generateSSHKey generates an ed25519 key pair, it returns the pem encoded private key
+func generateSSHKey() (string, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't generate ssh key")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't marshal ssh key")
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// sshPublicKey returns the authorized key line of the private key
+func sshPublicKey(privateKey string) (string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't parse ssh private key")
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// authorizesKey checks if one of the authorized keys is the public key of the private key
+func authorizesKey(authorizedKeys string, privateKey string) (bool, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't parse ssh private key")
+	}
+	for _, line := range strings.Split(authorizedKeys, "\n") {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err == nil && bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// knownHosts pins the host key of each host the provider connects to on the first connection,
+// the next connections fail if the host presents another key
+type knownHosts struct {
+	m    sync.Mutex
+	keys map[string]string
+	// mismatches are the hosts that presented another key than the pinned one
+	mismatches map[string]error
+}
+
+func newKnownHosts(keys map[string]string) *knownHosts {
+	h := &knownHosts{keys: make(map[string]string), mismatches: make(map[string]error)}
+	for host, key := range keys {
+		h.keys[host] = key
+	}
+	return h
+}
+
+func (h *knownHosts) callback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	h.m.Lock()
+	defer h.m.Unlock()
+	pinned, ok := h.keys[host]
+	if !ok {
+		h.keys[host] = line
+		return nil
+	}
+	if pinned != line {
+		h.mismatches[host] = fmt.Errorf("host key of %s is %s instead of the one pinned in ssh_host_keys on the first connection", host, line)
+		return h.mismatches[host]
+	}
+	return nil
+}
+
+// mismatch returns the error of the host at addr presenting another key than the pinned one
+func (h *knownHosts) mismatch(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.mismatches[host]
+}
+
+// pinned returns the keys pinned for the hosts
+func (h *knownHosts) pinned(hosts []string) map[string]string {
+	h.m.Lock()
+	defer h.m.Unlock()
+	keys := make(map[string]string)
+	for _, host := range hosts {
+		if key, ok := h.keys[host]; ok {
+			keys[host] = key
+		}
+	}
+	return keys
+}
+
+// sshClientConfig returns the config to connect as root to the cluster nodes with the private key,
+// the host keys are checked against the ones pinned in hosts
+func sshClientConfig(privateKey string, hosts *knownHosts) (*ssh.ClientConfig, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse ssh private key")
+	}
+	return &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hosts.callback,
+		Timeout:         10 * time.Second,
+	}, nil
+}
+
+// readKubeconfig reads the kubeconfig of k3s from the master at addr over ssh,
+// it retries until k3s writes it or ctx is done
+func readKubeconfig(ctx context.Context, addr string, privateKey string, hosts *knownHosts) (string, error) {
+	config, err := sshClientConfig(privateKey, hosts)
+	if err != nil {
+		return "", err
+	}
+	for {
+		kubeconfig, err := runSSH(addr, config, fmt.Sprintf("cat %s", kubeconfigPath))
+		if err == nil && strings.TrimSpace(kubeconfig) != "" {
+			return kubeconfig, nil
+		}
+		if err := hosts.mismatch(addr); err != nil {
+			return "", err
+		}
+		if err == nil {
+			err = fmt.Errorf("%s is empty", kubeconfigPath)
+		}
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrapf(err, "couldn't read %s from %s", kubeconfigPath, addr)
+		case <-time.After(kubeconfigRetryInterval):
+		}
+	}
+}
+
+// runSSH runs the command on the machine at addr, it returns the output of the command
+func runSSH(addr string, config *ssh.ClientConfig, cmd string) (string, error) {
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.Output(cmd)
+	return string(out), err
+}
+
+// kubeconfigWithServer points the clusters of the kubeconfig to the k3s server at host
+func kubeconfigWithServer(kubeconfig string, host string) string {
+	return kubeconfigServerRegex.ReplaceAllString(kubeconfig, "${1}"+k3sURL(host))
+}
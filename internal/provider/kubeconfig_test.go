@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+const testKubeconfig = `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: ZGF0YQ==
+    server: https://127.0.0.1:6443
+  name: default
+kind: Config
+`
+
+// serveKubeconfig serves the kubeconfig over ssh to the clients using the private key, it returns the server address
+func serveKubeconfig(t *testing.T, privateKey string, kubeconfig string) string {
+	authorized, err := ssh.ParsePrivateKey([]byte(privateKey))
+	assert.NoError(t, err)
+	hostKey, err := generateSSHKey()
+	assert.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey([]byte(hostKey))
+	assert.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorized.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized key")
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveCat(conn, config, kubeconfig)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveCat(conn net.Conn, config *ssh.ServerConfig, content string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				var exec struct{ Command string }
+				ok := req.Type == "exec" && ssh.Unmarshal(req.Payload, &exec) == nil && exec.Command == "cat "+kubeconfigPath
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				channel.Write([]byte(content))
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				channel.Close()
+			}
+		}()
+	}
+}
+
+func TestReadKubeconfig(t *testing.T) {
+	key, err := generateSSHKey()
+	assert.NoError(t, err)
+	addr := serveKubeconfig(t, key, testKubeconfig)
+
+	hosts := newKnownHosts(nil)
+	kubeconfig, err := readKubeconfig(context.Background(), addr, key, hosts)
+	assert.NoError(t, err)
+	assert.Equal(t, testKubeconfig, kubeconfig)
+
+	// other keys aren't authorized
+	other, err := generateSSHKey()
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = readKubeconfig(ctx, addr, other, hosts)
+	assert.Error(t, err)
+}
+
+func TestReadKubeconfigPinsHostKey(t *testing.T) {
+	key, err := generateSSHKey()
+	assert.NoError(t, err)
+	addr := serveKubeconfig(t, key, testKubeconfig)
+	host, _, err := net.SplitHostPort(addr)
+	assert.NoError(t, err)
+
+	hosts := newKnownHosts(nil)
+	_, err = readKubeconfig(context.Background(), addr, key, hosts)
+	assert.NoError(t, err)
+	pinned := hosts.pinned([]string{host, "10.1.2.2"})
+	assert.Len(t, pinned, 1)
+	assert.Contains(t, pinned[host], "ssh-ed25519 ")
+
+	// the same key is accepted again
+	_, err = readKubeconfig(context.Background(), addr, key, newKnownHosts(pinned))
+	assert.NoError(t, err)
+
+	// another host key fails right away instead of retrying until the timeout
+	other, err := generateSSHKey()
+	assert.NoError(t, err)
+	otherKey, err := sshPublicKey(other)
+	assert.NoError(t, err)
+	start := time.Now()
+	_, err = readKubeconfig(context.Background(), addr, key, newKnownHosts(map[string]string{host: otherKey}))
+	assert.ErrorContains(t, err, "pinned in ssh_host_keys")
+	assert.Less(t, time.Since(start), kubeconfigRetryInterval)
+}
+
+func TestKubeconfigWithServer(t *testing.T) {
+	kubeconfig := kubeconfigWithServer(testKubeconfig, "185.206.122.40")
+	assert.Contains(t, kubeconfig, "    server: https://185.206.122.40:6443\n  name: default\n")
+	assert.NotContains(t, kubeconfig, "127.0.0.1")
+
+	kubeconfig = kubeconfigWithServer(testKubeconfig, "300:e9c4:9048:57cf::1")
+	assert.Contains(t, kubeconfig, "server: https://[300:e9c4:9048:57cf::1]:6443\n")
+}
+
+func TestAuthorizesKey(t *testing.T) {
+	key, err := generateSSHKey()
+	assert.NoError(t, err)
+	pub, err := sshPublicKey(key)
+	assert.NoError(t, err)
+	other, err := generateSSHKey()
+	assert.NoError(t, err)
+	otherPub, err := sshPublicKey(other)
+	assert.NoError(t, err)
+
+	ok, err := authorizesKey(otherPub+"\n"+pub, key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = authorizesKey(otherPub, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, err = authorizesKey(pub, "not a key")
+	assert.Error(t, err)
+}
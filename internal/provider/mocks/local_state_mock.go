@@ -252,11 +252,25 @@ func (mr *MockNetworkMockRecorder) DeleteNodeSubnet(nodeID interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNodeSubnet", reflect.TypeOf((*MockNetwork)(nil).DeleteNodeSubnet), nodeID)
 }
 
+// GetConfig mocks base method.
+func (m *MockNetwork) GetConfig() *state.NetworkConfig {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetConfig")
+	ret0, _ := ret[0].(*state.NetworkConfig)
+	return ret0
+}
+
+// GetConfig indicates an expected call of GetConfig.
+func (mr *MockNetworkMockRecorder) GetConfig() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConfig", reflect.TypeOf((*MockNetwork)(nil).GetConfig))
+}
+
 // GetDeploymentIPs mocks base method.
-func (m *MockNetwork) GetDeploymentIPs(nodeID uint32, deploymentID string) []byte {
+func (m *MockNetwork) GetDeploymentIPs(nodeID uint32, deploymentID string) []string {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetDeploymentIPs", nodeID, deploymentID)
-	ret0, _ := ret[0].([]byte)
+	ret0, _ := ret[0].([]string)
 	return ret0
 }
 
@@ -266,6 +280,35 @@ func (mr *MockNetworkMockRecorder) GetDeploymentIPs(nodeID, deploymentID interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeploymentIPs", reflect.TypeOf((*MockNetwork)(nil).GetDeploymentIPs), nodeID, deploymentID)
 }
 
+// GetNodeDeploymentID mocks base method.
+func (m *MockNetwork) GetNodeDeploymentID(nodeID uint32) uint64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNodeDeploymentID", nodeID)
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+// GetNodeDeploymentID indicates an expected call of GetNodeDeploymentID.
+func (mr *MockNetworkMockRecorder) GetNodeDeploymentID(nodeID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeDeploymentID", reflect.TypeOf((*MockNetwork)(nil).GetNodeDeploymentID), nodeID)
+}
+
+// GetNodeIPAM mocks base method.
+func (m *MockNetwork) GetNodeIPAM(nodeID uint32) (*state.IPAM, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNodeIPAM", nodeID)
+	ret0, _ := ret[0].(*state.IPAM)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNodeIPAM indicates an expected call of GetNodeIPAM.
+func (mr *MockNetworkMockRecorder) GetNodeIPAM(nodeID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeIPAM", reflect.TypeOf((*MockNetwork)(nil).GetNodeIPAM), nodeID)
+}
+
 // GetNodeIPs mocks base method.
 func (m *MockNetwork) GetNodeIPs() state.NodeIPs {
 	m.ctrl.T.Helper()
@@ -281,10 +324,10 @@ func (mr *MockNetworkMockRecorder) GetNodeIPs() *gomock.Call {
 }
 
 // GetNodeIPsList mocks base method.
-func (m *MockNetwork) GetNodeIPsList(nodeID uint32) []byte {
+func (m *MockNetwork) GetNodeIPsList(nodeID uint32) []string {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetNodeIPsList", nodeID)
-	ret0, _ := ret[0].([]byte)
+	ret0, _ := ret[0].([]string)
 	return ret0
 }
 
@@ -308,6 +351,34 @@ func (mr *MockNetworkMockRecorder) GetNodeSubnet(nodeID interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeSubnet", reflect.TypeOf((*MockNetwork)(nil).GetNodeSubnet), nodeID)
 }
 
+// GetPeers mocks base method.
+func (m *MockNetwork) GetPeers(nodeID uint32) []state.Peer {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPeers", nodeID)
+	ret0, _ := ret[0].([]state.Peer)
+	return ret0
+}
+
+// GetPeers indicates an expected call of GetPeers.
+func (mr *MockNetworkMockRecorder) GetPeers(nodeID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPeers", reflect.TypeOf((*MockNetwork)(nil).GetPeers), nodeID)
+}
+
+// GetReservedIPs mocks base method.
+func (m *MockNetwork) GetReservedIPs() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReservedIPs")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// GetReservedIPs indicates an expected call of GetReservedIPs.
+func (mr *MockNetworkMockRecorder) GetReservedIPs() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReservedIPs", reflect.TypeOf((*MockNetwork)(nil).GetReservedIPs))
+}
+
 // GetSubnets mocks base method.
 func (m *MockNetwork) GetSubnets() map[uint32]string {
 	m.ctrl.T.Helper()
@@ -322,8 +393,20 @@ func (mr *MockNetworkMockRecorder) GetSubnets() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubnets", reflect.TypeOf((*MockNetwork)(nil).GetSubnets))
 }
 
+// SetConfig mocks base method.
+func (m *MockNetwork) SetConfig(config *state.NetworkConfig) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetConfig", config)
+}
+
+// SetConfig indicates an expected call of SetConfig.
+func (mr *MockNetworkMockRecorder) SetConfig(config interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetConfig", reflect.TypeOf((*MockNetwork)(nil).SetConfig), config)
+}
+
 // SetDeploymentIPs mocks base method.
-func (m *MockNetwork) SetDeploymentIPs(nodeID uint32, deploymentID string, ips []byte) {
+func (m *MockNetwork) SetDeploymentIPs(nodeID uint32, deploymentID string, ips []string) {
 	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "SetDeploymentIPs", nodeID, deploymentID, ips)
 }
@@ -334,6 +417,18 @@ func (mr *MockNetworkMockRecorder) SetDeploymentIPs(nodeID, deploymentID, ips in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDeploymentIPs", reflect.TypeOf((*MockNetwork)(nil).SetDeploymentIPs), nodeID, deploymentID, ips)
 }
 
+// SetNodeDeploymentIDs mocks base method.
+func (m *MockNetwork) SetNodeDeploymentIDs(ids map[uint32]uint64) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetNodeDeploymentIDs", ids)
+}
+
+// SetNodeDeploymentIDs indicates an expected call of SetNodeDeploymentIDs.
+func (mr *MockNetworkMockRecorder) SetNodeDeploymentIDs(ids interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNodeDeploymentIDs", reflect.TypeOf((*MockNetwork)(nil).SetNodeDeploymentIDs), ids)
+}
+
 // SetNodeSubnet mocks base method.
 func (m *MockNetwork) SetNodeSubnet(nodeID uint32, subnet string) {
 	m.ctrl.T.Helper()
@@ -345,3 +440,27 @@ func (mr *MockNetworkMockRecorder) SetNodeSubnet(nodeID, subnet interface{}) *go
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNodeSubnet", reflect.TypeOf((*MockNetwork)(nil).SetNodeSubnet), nodeID, subnet)
 }
+
+// SetPeers mocks base method.
+func (m *MockNetwork) SetPeers(peering string, peers []state.Peer) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetPeers", peering, peers)
+}
+
+// SetPeers indicates an expected call of SetPeers.
+func (mr *MockNetworkMockRecorder) SetPeers(peering interface{}, peers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPeers", reflect.TypeOf((*MockNetwork)(nil).SetPeers), peering, peers)
+}
+
+// SetReservedIPs mocks base method.
+func (m *MockNetwork) SetReservedIPs(reserved []string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetReservedIPs", reserved)
+}
+
+// SetReservedIPs indicates an expected call of SetReservedIPs.
+func (mr *MockNetworkMockRecorder) SetReservedIPs(reserved interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetReservedIPs", reflect.TypeOf((*MockNetwork)(nil).SetReservedIPs), reserved)
+}
@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: ../../../pkg/subi/dev_manager.go
+// Source: ../../../pkg/subi/substrate.go
 
 // Package mock is a generated GoMock package.
 package mock
@@ -13,6 +13,7 @@ import (
 	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
 	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
 )
 
 var (
@@ -29,6 +30,35 @@ func ipNet(a, b, c, d, msk byte) gridtypes.IPNet {
 	})
 }
 
+// nextFreeSubnet returns the first subnet of the network range with the prefix that doesn't overlap
+// the used ones. zos uses the first address of a subnet as its gateway so the subnets smaller than a /24
+// can't share their third octet, and the ones bigger than a /24 are aligned to their size
+func nextFreeSubnet(ipRange gridtypes.IPNet, prefix int, used []gridtypes.IPNet) (gridtypes.IPNet, error) {
+	step := 1
+	if prefix < 24 {
+		step = 1 << (24 - prefix)
+	}
+	start := 2
+	if step > start {
+		start = step
+	}
+	ip := ipRange.IP.To4()
+	for octet := start; octet+step-1 <= 254; octet += step {
+		subnet := ipNet(ip[0], ip[1], byte(octet), 0, byte(prefix))
+		free := true
+		for _, u := range used {
+			if u.Contains(subnet.IP) || subnet.Contains(u.IP) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return subnet, nil
+		}
+	}
+	return gridtypes.IPNet{}, fmt.Errorf("no free /%d subnet left in ip range %s", prefix, ipRange.String())
+}
+
 func wgIP(ip gridtypes.IPNet) gridtypes.IPNet {
 	a := ip.IP[len(ip.IP)-3]
 	b := ip.IP[len(ip.IP)-2]
@@ -40,18 +70,45 @@ func wgIP(ip gridtypes.IPNet) gridtypes.IPNet {
 
 }
 
-func generateWGConfig(Address string, AccessPrivatekey string, NodePublicKey string, NodeEndpoint string, NetworkIPRange string) string {
+// wgPeer returns the peer of the network resource with the subnet, the endpoint is the host the resource listens
+// on with the port, it's empty if the resource is hidden so only it connects to the peer
+func wgPeer(subnet gridtypes.IPNet, publicKey string, endpoint string, port int, allowedIPs []gridtypes.IPNet) zos.Peer {
+	peer := zos.Peer{
+		Subnet:      subnet,
+		WGPublicKey: publicKey,
+		AllowedIPs:  allowedIPs,
+	}
+	if endpoint != "" {
+		peer.Endpoint = fmt.Sprintf("%s:%d", endpoint, port)
+	}
+	return peer
+}
 
-	return fmt.Sprintf(`
+// wgConfigPeer is a peer of a wg-quick config
+type wgConfigPeer struct {
+	PublicKey  string
+	Endpoint   string
+	AllowedIPs string
+}
+
+// generateWGConfig generates a wg-quick config, without the PrivateKey line if the private key isn't known
+func generateWGConfig(Address string, AccessPrivatekey string, peers []wgConfigPeer) string {
+	privateKey := ""
+	if AccessPrivatekey != "" {
+		privateKey = fmt.Sprintf("\nPrivateKey = %s", AccessPrivatekey)
+	}
+	config := fmt.Sprintf(`
 [Interface]
-Address = %s
-PrivateKey = %s
+Address = %s%s`, Address, privateKey)
+	for _, peer := range peers {
+		config += fmt.Sprintf(`
 [Peer]
 PublicKey = %s
-AllowedIPs = %s, 100.64.0.0/16
+AllowedIPs = %s
 PersistentKeepalive = 25
-Endpoint = %s
-	`, Address, AccessPrivatekey, NodePublicKey, NetworkIPRange, NodeEndpoint)
+Endpoint = %s`, peer.PublicKey, peer.AllowedIPs, peer.Endpoint)
+	}
+	return config + "\n\t"
 }
 
 func getPublicNode(ctx context.Context, gridClient proxy.Client, preferedNodes []uint32) (uint32, error) {
@@ -169,3 +226,15 @@ func getNodeEndpoint(ctx context.Context, nodeClient *client.NodeClient) (net.IP
 	}
 	return nil, errors.Wrap(ErrNoAccessibleInterfaceFound, "no public ipv4 or ipv6 on zos interface found")
 }
+
+// getNodeWGEndpoint returns the host of the node to use in wireguard endpoints, ipv6 addresses are bracketed
+func getNodeWGEndpoint(ctx context.Context, nodeClient *client.NodeClient) (string, error) {
+	endpoint, err := getNodeEndpoint(ctx, nodeClient)
+	if err != nil {
+		return "", err
+	}
+	if endpoint.To4() != nil {
+		return endpoint.String(), nil
+	}
+	return fmt.Sprintf("[%s]", endpoint.String()), nil
+}
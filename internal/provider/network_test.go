@@ -0,0 +1,591 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestNetworkAutoExtend(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":        "net",
+		"nodes":       []interface{}{1},
+		"ip_range":    "10.1.0.0/16",
+		"auto_extend": true,
+	})
+	assert.Len(t, network.Get("node_deployment_id"), 1)
+
+	deployment := createTestResource(t, p, "grid_deployment", map[string]interface{}{
+		"node":         2,
+		"network_name": "net",
+		"vms": []interface{}{map[string]interface{}{
+			"name":   "vm",
+			"flist":  "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+			"cpu":    1,
+			"memory": 1024,
+		}},
+	})
+	assert.NotEmpty(t, deployment.Id())
+	assert.NotEmpty(t, deployment.Get("ip_range"))
+
+	// the network picks up the node added by the deployment on refresh
+	r := p.ResourcesMap["grid_network"]
+	diags := r.ReadContext(context.Background(), network, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, []interface{}{1}, network.Get("nodes"))
+	assert.Equal(t, []interface{}{2}, network.Get("extended_nodes"))
+	assert.Len(t, network.Get("node_deployment_id"), 2)
+	assert.Equal(t, deployment.Get("ip_range"), network.Get("nodes_ip_range.2"))
+
+	diags = p.ResourcesMap["grid_deployment"].DeleteContext(context.Background(), deployment, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	diags = r.DeleteContext(context.Background(), network, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	for _, c := range grid.Substrate.Contracts() {
+		assert.True(t, c.Deleted, "contract %d is still active", c.ID)
+	}
+
+	// networks without auto_extend must list the nodes of their deployments
+	createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "static",
+		"nodes":    []interface{}{1},
+		"ip_range": "10.2.0.0/16",
+	})
+	d := p.ResourcesMap["grid_deployment"].Data(nil)
+	for key, value := range map[string]interface{}{
+		"node":         2,
+		"network_name": "static",
+		"vms": []interface{}{map[string]interface{}{
+			"name":   "vm",
+			"flist":  "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+			"cpu":    1,
+			"memory": 1024,
+		}},
+	} {
+		assert.NoError(t, d.Set(key, value))
+	}
+	diags = p.ResourcesMap["grid_deployment"].CreateContext(context.Background(), d, p.Meta())
+	assert.True(t, diags.HasError())
+	assert.Empty(t, d.Id())
+}
+
+func TestNetworkIPv6(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":          "net",
+		"nodes":         []interface{}{1, 2},
+		"ip_range":      "10.1.0.0/16",
+		"add_wg_access": true,
+		"ipv6":          true,
+	})
+	_, ip6Range, err := net.ParseCIDR(network.Get("ipv6_range").(string))
+	assert.NoError(t, err)
+	assert.Contains(t, network.Get("access_wg_config"), ip6Range.String())
+
+	// every peer routes the /64 of the node next to its ipv4 subnet
+	for node, contractID := range network.Get("node_deployment_id").(map[string]interface{}) {
+		_, subnet, err := net.ParseCIDR(network.Get("nodes_ipv6_range." + node).(string))
+		assert.NoError(t, err)
+		assert.True(t, ip6Range.Contains(subnet.IP))
+		id, err := strconv.ParseUint(node, 10, 32)
+		assert.NoError(t, err)
+		dl, ok := grid.Node(uint32(id)).Deployment(uint64(contractID.(int)))
+		assert.True(t, ok)
+		data, err := dl.Workloads[0].WorkloadData()
+		assert.NoError(t, err)
+		for _, peer := range data.(*zos.Network).Peers {
+			v6 := make([]gridtypes.IPNet, 0)
+			for _, ip := range peer.AllowedIPs {
+				if ip.IP.To4() == nil {
+					v6 = append(v6, ip)
+				}
+			}
+			assert.NotEmpty(t, v6, "peer %s of node %s has no ipv6 allowed ips", peer.Subnet.String(), node)
+		}
+	}
+
+	deployment := createTestResource(t, p, "grid_deployment", map[string]interface{}{
+		"node":         2,
+		"network_name": "net",
+		"vms": []interface{}{map[string]interface{}{
+			"name":   "vm",
+			"flist":  "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+			"cpu":    1,
+			"memory": 1024,
+		}},
+	})
+	_, subnet, err := net.ParseCIDR(network.Get("nodes_ipv6_range.2").(string))
+	assert.NoError(t, err)
+	ip6 := net.ParseIP(deployment.Get("vms.0.ip6").(string))
+	assert.True(t, subnet.Contains(ip6), "%s not in %s", ip6, subnet)
+	ip4 := net.ParseIP(deployment.Get("vms.0.ip").(string)).To4()
+	assert.Equal(t, ip4[3], ip6[15])
+
+	// turning ipv6 off drops the ipv6 allowed ips and is picked up on read
+	r := p.ResourcesMap["grid_network"]
+	assert.NoError(t, network.Set("ipv6", false))
+	diags := r.UpdateContext(context.Background(), network, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.NotContains(t, network.Get("access_wg_config"), ip6Range.String())
+	assert.NoError(t, network.Set("ipv6", true))
+	diags = r.ReadContext(context.Background(), network, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, false, network.Get("ipv6"))
+}
+
+func TestNetworkIPAM(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":               "net",
+		"nodes":              []interface{}{1},
+		"ip_range":           "10.1.0.0/16",
+		"node_subnet_prefix": 25,
+		"reserved_ips":       []interface{}{"10.1.2.2", "10.1.2.4/31"},
+	})
+	assert.Equal(t, "10.1.2.0/25", network.Get("nodes_ip_range.1"))
+
+	vm := func(name, ip string) interface{} {
+		return map[string]interface{}{
+			"name":   name,
+			"flist":  "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+			"cpu":    1,
+			"memory": 1024,
+			"ip":     ip,
+		}
+	}
+	// the reserved ips are skipped
+	first := createTestResource(t, p, "grid_deployment", map[string]interface{}{
+		"node":         1,
+		"network_name": "net",
+		"vms":          []interface{}{vm("a", ""), vm("b", "")},
+	})
+	assert.Equal(t, "10.1.2.3", first.Get("vms.0.ip"))
+	assert.Equal(t, "10.1.2.6", first.Get("vms.1.ip"))
+
+	ips := p.DataSourcesMap["grid_network_ips"]
+	d := schema.TestResourceDataRaw(t, ips.Schema, map[string]interface{}{
+		"network_name": "net",
+		"node":         1,
+	})
+	diags := ips.ReadContext(context.Background(), d, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, "10.1.2.0/25", d.Get("subnet"))
+	assert.Equal(t, map[string]interface{}{"10.1.2.3": first.Id(), "10.1.2.6": first.Id()}, d.Get("used_ips"))
+	assert.Len(t, d.Get("free_ips"), 125-3-2)
+	assert.Equal(t, "10.1.2.7", d.Get("free_ips.0"))
+
+	// static ips used by other deployments or reserved are reported in the plan
+	r := p.ResourcesMap["grid_deployment"]
+	for ip, conflict := range map[string]bool{"10.1.2.6": true, "10.1.2.5": true, "10.1.2.7": false} {
+		_, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(map[string]interface{}{
+			"node":         1,
+			"network_name": "net",
+			"vms":          []interface{}{vm("c", ip)},
+		}), p.Meta())
+		assert.Equal(t, conflict, err != nil, "ip %s: %v", ip, err)
+	}
+	// and when applying
+	d = r.Data(nil)
+	assert.NoError(t, d.Set("node", 1))
+	assert.NoError(t, d.Set("network_name", "net"))
+	assert.NoError(t, d.Set("vms", []interface{}{vm("c", "10.1.2.3")}))
+	diags = r.CreateContext(context.Background(), d, p.Meta())
+	assert.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "already used")
+
+	second := createTestResource(t, p, "grid_deployment", map[string]interface{}{
+		"node":         1,
+		"network_name": "net",
+		"vms":          []interface{}{vm("c", "10.1.2.7"), vm("d", "")},
+	})
+	assert.Equal(t, "10.1.2.7", second.Get("vms.0.ip"))
+	assert.Equal(t, "10.1.2.8", second.Get("vms.1.ip"))
+}
+
+func TestNextFreeSubnet(t *testing.T) {
+	ipRange := gridtypes.MustParseIPNet("10.1.0.0/16")
+	// the subnets are aligned to their size and skip the used ones
+	for prefix, expected := range map[int][]string{
+		23: {"10.1.4.0/23", "10.1.6.0/23", "10.1.8.0/23"},
+		24: {"10.1.2.0/24", "10.1.4.0/24", "10.1.5.0/24"},
+		26: {"10.1.2.0/26", "10.1.4.0/26", "10.1.5.0/26"},
+	} {
+		used := []gridtypes.IPNet{gridtypes.MustParseIPNet("10.1.3.0/24")}
+		got := make([]string, 0)
+		for range expected {
+			subnet, err := nextFreeSubnet(ipRange, prefix, used)
+			assert.NoError(t, err)
+			used = append(used, subnet)
+			got = append(got, subnet.String())
+		}
+		assert.Equal(t, expected, got, "prefix %d", prefix)
+	}
+	_, err := nextFreeSubnet(ipRange, 18, []gridtypes.IPNet{
+		gridtypes.MustParseIPNet("10.1.64.0/18"),
+		gridtypes.MustParseIPNet("10.1.128.0/18"),
+	})
+	assert.Error(t, err)
+}
+
+func TestNetworkAccessPeers(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	key, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "net",
+		"nodes":    []interface{}{1, 2},
+		"ip_range": "10.1.0.0/16",
+		"access_peers": []interface{}{
+			map[string]interface{}{"name": "alice"},
+			map[string]interface{}{"name": "ci", "public_key": key.PublicKey().String()},
+		},
+	})
+	assert.Equal(t, 1, network.Get("public_node_id"))
+	alice := network.Get("access_peers.0").(map[string]interface{})
+	ci := network.Get("access_peers.1").(map[string]interface{})
+	assert.NotEqual(t, alice["subnet"], ci["subnet"])
+	assert.Contains(t, alice["wg_config"], "PrivateKey = "+alice["private_key"].(string))
+	assert.Empty(t, ci["private_key"])
+	assert.NotContains(t, ci["wg_config"], "PrivateKey")
+	assert.Empty(t, network.Get("access_wg_config"))
+
+	publicPeers := func() map[string]string {
+		id := network.Get("node_deployment_id.1").(int)
+		dl, ok := grid.Node(1).Deployment(uint64(id))
+		assert.True(t, ok)
+		data, err := dl.Workloads[0].WorkloadData()
+		assert.NoError(t, err)
+		peers := make(map[string]string)
+		for _, peer := range data.(*zos.Network).Peers {
+			peers[peer.WGPublicKey] = peer.Subnet.String()
+		}
+		return peers
+	}
+	aliceKey, err := wgtypes.ParseKey(alice["private_key"].(string))
+	assert.NoError(t, err)
+	peers := publicPeers()
+	assert.Equal(t, alice["subnet"], peers[aliceKey.PublicKey().String()])
+	assert.Equal(t, ci["subnet"], peers[key.PublicKey().String()])
+
+	// revoking a peer keeps the others
+	r := p.ResourcesMap["grid_network"]
+	network = r.Data(network.State())
+	assert.NoError(t, network.Set("access_peers", []interface{}{
+		map[string]interface{}{"name": "ci", "public_key": key.PublicKey().String()},
+	}))
+	diags := r.UpdateContext(context.Background(), network, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, ci, network.Get("access_peers.0"))
+	peers = publicPeers()
+	assert.NotContains(t, peers, aliceKey.PublicKey().String())
+	assert.Equal(t, ci["subnet"], peers[key.PublicKey().String()])
+
+	// read keeps the generated keys
+	network = r.Data(network.State())
+	assert.NoError(t, network.Set("access_peers", []interface{}{
+		map[string]interface{}{"name": "ci", "public_key": key.PublicKey().String()},
+		map[string]interface{}{"name": "bob"},
+	}))
+	diags = r.UpdateContext(context.Background(), network, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	bob := network.Get("access_peers.1").(map[string]interface{})
+	network = r.Data(network.State())
+	diags = r.ReadContext(context.Background(), network, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, bob, network.Get("access_peers.1"))
+	assert.Equal(t, false, network.Get("add_wg_access"))
+}
+
+func TestNetworkExternalPublicKey(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	key, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":          "net",
+		"nodes":         []interface{}{1},
+		"ip_range":      "10.1.0.0/16",
+		"add_wg_access": true,
+		"external_pk":   key.PublicKey().String(),
+	})
+	assert.Empty(t, network.Get("external_sk"))
+	assert.NotContains(t, network.Get("access_wg_config"), "PrivateKey")
+	accessPeerKey := func() string {
+		dl, ok := grid.Node(1).Deployment(uint64(network.Get("node_deployment_id.1").(int)))
+		assert.True(t, ok)
+		data, err := dl.Workloads[0].WorkloadData()
+		assert.NoError(t, err)
+		peers := data.(*zos.Network).Peers
+		assert.Len(t, peers, 1)
+		return peers[0].WGPublicKey
+	}
+	assert.Equal(t, key.PublicKey().String(), accessPeerKey())
+
+	// without the public key a key pair is generated again
+	r := p.ResourcesMap["grid_network"]
+	network = r.Data(network.State())
+	assert.NoError(t, network.Set("external_pk", ""))
+	diags := r.UpdateContext(context.Background(), network, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	sk, err := wgtypes.ParseKey(network.Get("external_sk").(string))
+	assert.NoError(t, err)
+	assert.Contains(t, network.Get("access_wg_config"), "PrivateKey = "+sk.String())
+	assert.Equal(t, sk.PublicKey().String(), accessPeerKey())
+}
+
+func TestNetworkAccessNodes(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	farm := grid.AddFarm("accessfarm")
+	capacity := gridtypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 512 * gridtypes.Gigabyte}
+	ip, ipRange, err := net.ParseCIDR("185.206.122.11/24")
+	assert.NoError(t, err)
+	ipRange.IP = ip
+	_, err = grid.AddNode(farm, capacity, &client.PublicConfig{
+		IPv4: gridtypes.IPNet{IPNet: *ipRange},
+		GW4:  net.ParseIP("185.206.122.1"),
+	})
+	assert.NoError(t, err)
+	_, err = grid.AddNode(farm, capacity, nil)
+	assert.NoError(t, err)
+	p := configureTestProvider(t, grid)
+
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":          "net",
+		"nodes":         []interface{}{2, 4},
+		"ip_range":      "10.1.0.0/16",
+		"add_wg_access": true,
+		"access_nodes":  []interface{}{1, 3},
+	})
+	assert.Equal(t, 1, network.Get("public_node_id"))
+	assert.Equal(t, []interface{}{1, 3}, network.Get("access_nodes"))
+	config := network.Get("access_wg_config").(string)
+	assert.Equal(t, 2, strings.Count(config, "[Peer]"))
+	assert.Contains(t, config, "185.206.122.10:")
+	assert.Contains(t, config, "185.206.122.11:")
+
+	// every hidden node goes through one of the access nodes
+	peerEndpoints := func(node uint32) []string {
+		id := network.Get("node_deployment_id." + strconv.Itoa(int(node))).(int)
+		dl, ok := grid.Node(node).Deployment(uint64(id))
+		assert.True(t, ok)
+		data, err := dl.Workloads[0].WorkloadData()
+		assert.NoError(t, err)
+		endpoints := make([]string, 0)
+		for _, peer := range data.(*zos.Network).Peers {
+			endpoints = append(endpoints, peer.Endpoint)
+		}
+		return endpoints
+	}
+	for _, node := range []uint32{2, 4} {
+		endpoints := peerEndpoints(node)
+		assert.Len(t, endpoints, 1)
+		assert.Regexp(t, `^185\.206\.122\.1[01]:`, endpoints[0])
+	}
+	assert.NotEqual(t, peerEndpoints(2), peerEndpoints(4))
+	assert.Equal(t, map[string]interface{}{"2": 3, "4": 1}, network.Get("hidden_nodes_access"))
+
+	// removing an access node moves only its hidden nodes, and the plan shows it
+	r := p.ResourcesMap["grid_network"]
+	raw := map[string]interface{}{
+		"name":          "net",
+		"nodes":         []interface{}{2, 4},
+		"ip_range":      "10.1.0.0/16",
+		"add_wg_access": true,
+		"access_nodes":  []interface{}{1},
+	}
+	diff, err := r.Diff(context.Background(), network.State(), terraform.NewResourceConfigRaw(raw), p.Meta())
+	assert.NoError(t, err)
+	if assert.NotNil(t, diff) {
+		assert.Equal(t, "1", diff.Attributes["hidden_nodes_access.2"].New)
+		assert.NotContains(t, diff.Attributes, "hidden_nodes_access.4")
+		assert.True(t, diff.Attributes["access_wg_config"].NewComputed)
+	}
+
+	// the designated nodes are kept when they're down
+	grid.Node(1).SetDown(true)
+	raw["access_nodes"] = []interface{}{1, 3}
+	diff, err = r.Diff(context.Background(), network.State(), terraform.NewResourceConfigRaw(raw), p.Meta())
+	assert.NoError(t, err)
+	assert.Nil(t, diff)
+}
+
+func TestHiddenNodeAccess(t *testing.T) {
+	_, ok := hiddenNodeAccess(2, nil)
+	assert.False(t, ok)
+	for node := uint32(1); node < 100; node++ {
+		access, ok := hiddenNodeAccess(node, []uint32{10, 20})
+		assert.True(t, ok)
+		// the order of the access nodes doesn't matter
+		reordered, _ := hiddenNodeAccess(node, []uint32{20, 10})
+		assert.Equal(t, access, reordered)
+		// a new access node only takes hidden nodes
+		added, _ := hiddenNodeAccess(node, []uint32{10, 20, 30})
+		assert.Contains(t, []uint32{access, 30}, added)
+		// removing an access node only moves its hidden nodes
+		removed, _ := hiddenNodeAccess(node, []uint32{10, 30})
+		if added != 20 {
+			assert.Equal(t, added, removed)
+		}
+	}
+}
+
+func TestNetworkPublicNodeDown(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	raw := map[string]interface{}{
+		"name":          "net",
+		"nodes":         []interface{}{2},
+		"ip_range":      "10.1.0.0/16",
+		"add_wg_access": true,
+	}
+	network := createTestResource(t, p, "grid_network", raw)
+	assert.Equal(t, 1, network.Get("public_node_id"))
+
+	r := p.ResourcesMap["grid_network"]
+	diff, err := r.Diff(context.Background(), network.State(), terraform.NewResourceConfigRaw(raw), p.Meta())
+	assert.NoError(t, err)
+	assert.Nil(t, diff)
+
+	// the plan shows the public node changing instead of replacing it silently
+	grid.Node(1).SetDown(true)
+	diff, err = r.Diff(context.Background(), network.State(), terraform.NewResourceConfigRaw(raw), p.Meta())
+	assert.NoError(t, err)
+	if assert.NotNil(t, diff) {
+		assert.True(t, diff.Attributes["public_node_id"].NewComputed)
+		assert.True(t, diff.Attributes["access_wg_config"].NewComputed)
+	}
+}
+
+func TestNetworkTopology(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":          "net",
+		"nodes":         []interface{}{1, 2},
+		"ip_range":      "10.1.0.0/16",
+		"add_wg_access": true,
+	})
+	var topology NetworkTopology
+	assert.NoError(t, json.Unmarshal([]byte(network.Get("topology_json").(string)), &topology))
+	assert.Equal(t, "10.1.0.0/16", topology.IPRange)
+	assert.Equal(t, uint32(1), topology.PublicNode)
+	if !assert.Len(t, topology.Nodes, 2) {
+		return
+	}
+	public, hidden := topology.Nodes[0], topology.Nodes[1]
+	assert.Equal(t, network.Get("nodes_ip_range.1"), public.Subnet)
+	assert.Equal(t, "ipv4", public.EndpointType)
+	assert.Regexp(t, `^185\.206\.122\.10:\d+$`, public.Endpoint)
+	assert.Equal(t, "hidden", hidden.EndpointType)
+	assert.Empty(t, hidden.Endpoint)
+
+	names := make([]string, 0)
+	for _, peer := range public.Peers {
+		names = append(names, peer.label())
+	}
+	assert.ElementsMatch(t, []string{"node 2", "wg_access"}, names)
+	assert.Empty(t, public.Routes)
+
+	// the hidden node reaches the whole network through the public node
+	if assert.Len(t, hidden.Peers, 1) {
+		assert.Equal(t, uint32(1), hidden.Peers[0].NodeID)
+		assert.Equal(t, public.Endpoint, hidden.Peers[0].Endpoint)
+	}
+	assert.Contains(t, hidden.Routes, TopologyRoute{Destination: "10.1.0.0/16", Via: "node 1"})
+
+	dot := network.Get("topology_dot").(string)
+	assert.Contains(t, dot, `"node 1" -- "node 2";`)
+	assert.Contains(t, dot, `"node 1" -- "wg_access";`)
+	assert.Equal(t, 1, strings.Count(dot, `"node 1" -- "node 2"`))
+	assert.Contains(t, dot, `style=dashed`)
+}
+
+func TestNetworkPeering(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	netA := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "neta",
+		"nodes":    []interface{}{1},
+		"ip_range": "10.1.0.0/16",
+	})
+	createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "netb",
+		"nodes":    []interface{}{2},
+		"ip_range": "10.2.0.0/16",
+	})
+	peering := createTestResource(t, p, "grid_network_peering", map[string]interface{}{
+		"network_a": "neta",
+		"node_a":    1,
+		"network_b": "netb",
+		"node_b":    2,
+	})
+	subnetA := peering.Get("subnet_a").(string)
+	subnetB := peering.Get("subnet_b").(string)
+	assert.Equal(t, netA.Get("nodes_ip_range.1"), subnetA)
+
+	nodePeers := func(node uint32, name string) map[string]zos.Peer {
+		network := p.Meta().(*apiClient).state.GetNetworkState().GetNetwork(name)
+		dl, ok := grid.Node(node).Deployment(network.GetNodeDeploymentID(node))
+		assert.True(t, ok)
+		data, err := dl.Workloads[0].WorkloadData()
+		assert.NoError(t, err)
+		peers := make(map[string]zos.Peer)
+		for _, peer := range data.(*zos.Network).Peers {
+			peers[peer.Subnet.String()] = peer
+		}
+		return peers
+	}
+	// the hidden node connects to the public one
+	peer, ok := nodePeers(1, "neta")[subnetB]
+	assert.True(t, ok)
+	assert.Empty(t, peer.Endpoint)
+	peer, ok = nodePeers(2, "netb")[subnetA]
+	assert.True(t, ok)
+	assert.Regexp(t, `^185\.206\.122\.10:\d+$`, peer.Endpoint)
+
+	// updating a network keeps the peering
+	r := p.ResourcesMap["grid_network"]
+	netA = r.Data(netA.State())
+	assert.NoError(t, netA.Set("description", "updated"))
+	diags := r.UpdateContext(context.Background(), netA, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Contains(t, nodePeers(1, "neta"), subnetB)
+	assert.Contains(t, netA.Get("topology_json"), "netb node 2")
+	assert.Equal(t, false, netA.Get("add_wg_access"))
+
+	// overlapping networks can't be peered
+	createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "netc",
+		"nodes":    []interface{}{2},
+		"ip_range": "10.1.0.0/16",
+	})
+	pr := p.ResourcesMap["grid_network_peering"]
+	d := pr.Data(nil)
+	assert.NoError(t, d.Set("network_a", "neta"))
+	assert.NoError(t, d.Set("node_a", 1))
+	assert.NoError(t, d.Set("network_b", "netc"))
+	assert.NoError(t, d.Set("node_b", 2))
+	diags = pr.CreateContext(context.Background(), d, p.Meta())
+	assert.True(t, diags.HasError())
+
+	diags = pr.DeleteContext(context.Background(), peering, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.NotContains(t, nodePeers(1, "neta"), subnetB)
+	assert.NotContains(t, nodePeers(2, "netb"), subnetA)
+}
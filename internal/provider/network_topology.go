@@ -0,0 +1,214 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+const (
+	endpointIPv4   = "ipv4"
+	endpointIPv6   = "ipv6"
+	endpointHidden = "hidden"
+)
+
+// NetworkTopology describes the wireguard mesh of the network deployments
+type NetworkTopology struct {
+	IPRange     string         `json:"ip_range"`
+	PublicNode  uint32         `json:"public_node,omitempty"`
+	AccessNodes []uint32       `json:"access_nodes,omitempty"`
+	Nodes       []TopologyNode `json:"nodes"`
+}
+
+// TopologyNode is the network resource of a node with its peers
+type TopologyNode struct {
+	NodeID       uint32          `json:"node_id"`
+	Subnet       string          `json:"subnet"`
+	WGIP         string          `json:"wg_ip"`
+	WGPort       int             `json:"wg_port"`
+	PublicKey    string          `json:"public_key"`
+	Endpoint     string          `json:"endpoint,omitempty"`
+	EndpointType string          `json:"endpoint_type"`
+	Peers        []TopologyPeer  `json:"peers"`
+	Routes       []TopologyRoute `json:"routes,omitempty"`
+}
+
+// TopologyPeer is a wireguard peer of a node, either another node or an access peer
+type TopologyPeer struct {
+	// NodeID is 0 for the access peers, Name is the access peer name then
+	NodeID     uint32   `json:"node_id,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	Subnet     string   `json:"subnet"`
+	PublicKey  string   `json:"public_key"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	AllowedIPs []string `json:"allowed_ips"`
+}
+
+// TopologyRoute is a range a node reaches through one of its peers instead of directly
+type TopologyRoute struct {
+	Destination string `json:"destination"`
+	Via         string `json:"via"`
+}
+
+// topology builds the topology of the generated deployments, endpoints has the endpoints of the nodes that aren't hidden
+func (k *NetworkDeployer) topology(deployments map[uint32]gridtypes.Deployment, endpoints map[uint32]string) (NetworkTopology, error) {
+	peerNames := make(map[string]string)
+	peerNodes := make(map[string]uint32)
+	for node, key := range k.Keys {
+		peerNodes[key.PublicKey().String()] = node
+	}
+	if k.AddWGAccess {
+		peerNames[k.externalPK()] = "wg_access"
+	}
+	for _, peer := range k.AccessPeers {
+		peerNames[peer.publicKey()] = peer.Name
+	}
+	network := k.APIClient.state.GetNetworkState().GetNetwork(k.Name)
+	for node := range deployments {
+		for _, peer := range network.GetPeers(node) {
+			peerNames[peer.PublicKey] = fmt.Sprintf("%s %s", peer.Network, nodeLabel(peer.PeerNode))
+		}
+	}
+
+	nodes := make([]uint32, 0, len(deployments))
+	for node := range deployments {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	topology := NetworkTopology{
+		IPRange:     k.IPRange.String(),
+		PublicNode:  k.PublicNodeID,
+		AccessNodes: k.AccessNodes,
+		Nodes:       make([]TopologyNode, 0, len(nodes)),
+	}
+	for _, node := range nodes {
+		dl := deployments[node]
+		for _, wl := range dl.Workloads {
+			if wl.Type != zos.NetworkType {
+				continue
+			}
+			data, err := wl.WorkloadData()
+			if err != nil {
+				return NetworkTopology{}, errors.Wrapf(err, "couldn't parse network workload of node %d", node)
+			}
+			network := data.(*zos.Network)
+			n := TopologyNode{
+				NodeID:       node,
+				Subnet:       network.Subnet.String(),
+				WGIP:         wgIP(network.Subnet).IP.String(),
+				WGPort:       int(network.WGListenPort),
+				PublicKey:    k.Keys[node].PublicKey().String(),
+				EndpointType: endpointHidden,
+				Peers:        make([]TopologyPeer, 0, len(network.Peers)),
+			}
+			if endpoint, ok := endpoints[node]; ok {
+				n.Endpoint = fmt.Sprintf("%s:%d", endpoint, network.WGListenPort)
+				n.EndpointType = endpointIPv4
+				if strings.HasPrefix(endpoint, "[") {
+					n.EndpointType = endpointIPv6
+				}
+			}
+			for _, peer := range network.Peers {
+				p := TopologyPeer{
+					NodeID:     peerNodes[peer.WGPublicKey],
+					Name:       peerNames[peer.WGPublicKey],
+					Subnet:     peer.Subnet.String(),
+					PublicKey:  peer.WGPublicKey,
+					Endpoint:   peer.Endpoint,
+					AllowedIPs: make([]string, 0, len(peer.AllowedIPs)),
+				}
+				// the ranges of the peer itself are reached directly, the rest is routed through it
+				own := make(map[string]bool)
+				for _, ip := range k.allowedIPs(peer.Subnet) {
+					own[ip.String()] = true
+				}
+				for _, ip := range peer.AllowedIPs {
+					p.AllowedIPs = append(p.AllowedIPs, ip.String())
+					if !own[ip.String()] {
+						n.Routes = append(n.Routes, TopologyRoute{
+							Destination: ip.String(),
+							Via:         p.label(),
+						})
+					}
+				}
+				n.Peers = append(n.Peers, p)
+			}
+			topology.Nodes = append(topology.Nodes, n)
+		}
+	}
+	return topology, nil
+}
+
+// label returns the name of the peer in the topology
+func (p *TopologyPeer) label() string {
+	if p.NodeID != 0 {
+		return nodeLabel(p.NodeID)
+	}
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.PublicKey
+}
+
+func nodeLabel(node uint32) string {
+	return fmt.Sprintf("node %d", node)
+}
+
+// JSON returns the indented json of the topology
+func (t *NetworkTopology) JSON() (string, error) {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't marshal network topology")
+	}
+	return string(data), nil
+}
+
+// DOT returns the topology as a graphviz graph, the public node is bold, the hidden nodes are dashed
+// and the access peers are boxes. Every wireguard link is drawn once
+func (t *NetworkTopology) DOT(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "graph %q {\n", name)
+	peers := make(map[string]bool)
+	for _, node := range t.Nodes {
+		attrs := ""
+		switch {
+		case node.NodeID == t.PublicNode:
+			attrs = ", style=bold"
+		case node.EndpointType == endpointHidden:
+			attrs = ", style=dashed"
+		}
+		label := fmt.Sprintf("%s\n%s\n%s", nodeLabel(node.NodeID), node.Subnet, node.EndpointType)
+		if node.Endpoint != "" {
+			label = fmt.Sprintf("%s\n%s\n%s", nodeLabel(node.NodeID), node.Subnet, node.Endpoint)
+		}
+		fmt.Fprintf(&b, "  %q [label=%q%s];\n", nodeLabel(node.NodeID), label, attrs)
+		for _, peer := range node.Peers {
+			if peer.NodeID == 0 && !peers[peer.label()] {
+				peers[peer.label()] = true
+				fmt.Fprintf(&b, "  %q [label=%q, shape=box];\n", peer.label(), fmt.Sprintf("%s\n%s", peer.label(), peer.Subnet))
+			}
+		}
+	}
+	links := make(map[string]bool)
+	for _, node := range t.Nodes {
+		for _, peer := range node.Peers {
+			from, to := nodeLabel(node.NodeID), peer.label()
+			if peer.NodeID != 0 && peer.NodeID < node.NodeID {
+				from, to = to, from
+			}
+			if links[from+"\x00"+to] {
+				continue
+			}
+			links[from+"\x00"+to] = true
+			fmt.Fprintf(&b, "  %q -- %q;\n", from, to)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -2,8 +2,11 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -11,6 +14,7 @@ import (
 	"github.com/pkg/errors"
 	proxy "github.com/threefoldtech/grid_proxy_server/pkg/client"
 	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/cassette"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
 	"github.com/threefoldtech/zos/pkg/rmb"
@@ -29,14 +33,91 @@ var (
 		"qa":   "https://gridproxy.qa.grid.tf/",
 		"main": "https://gridproxy.grid.tf/",
 	}
-	SubstrateVersion = map[string]func(url ...string) subi.Manager{
-		"dev":  subi.NewDevManager,
-		"qa":   subi.NewQAManager,
-		"test": subi.NewTestManager,
-		"main": subi.NewMMainanager,
-	}
+	SubstrateVersion = subi.Managers
 )
 
+// CustomNetwork is a privately hosted grid, its urls must be passed explicitly
+const CustomNetwork = "custom"
+
+// rmb transports
+const (
+	RMBTransportProxy = "proxy"
+	RMBTransportRedis = "redis"
+)
+
+// ManagerConstructor returns the substrate version compatible with the chain of the given network and the
+// constructor of its manager. Custom networks use the declared version, or detect it from the chain metadata if empty
+func ManagerConstructor(network string, version string, url ...string) (string, func(url ...string) subi.Manager, error) {
+	if network != CustomNetwork {
+		return network, SubstrateVersion[network], nil
+	}
+	if version == "" {
+		detected, err := subi.DetectVersion(url...)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "couldn't detect substrate version")
+		}
+		log.Printf("detected substrate version %s", detected)
+		version = detected
+	}
+	newManager, ok := SubstrateVersion[version]
+	if !ok {
+		return "", nil, fmt.Errorf("substrate version must be one of %s", strings.Join(subi.Networks, ", "))
+	}
+	return version, newManager, nil
+}
+
+// endpoints merges a single url attribute with its list counterpart,
+// the network default is used if neither is set
+func endpoints(d *schema.ResourceData, single string, list string, def string) []string {
+	var urls []string
+	if url := d.Get(single).(string); url != "" {
+		urls = append(urls, url)
+	}
+	for _, url := range d.Get(list).([]interface{}) {
+		if url, ok := url.(string); ok && url != "" {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 && def != "" {
+		urls = append(urls, def)
+	}
+	return urls
+}
+
+// healthyProxies returns the grid proxy urls that respond to a ping, keeping their order
+func healthyProxies(urls []string) ([]string, error) {
+	var healthy []string
+	for _, url := range urls {
+		if err := proxy.NewClient(url).Ping(); err != nil {
+			log.Printf("grid proxy %s is unhealthy: %s", url, err)
+			continue
+		}
+		healthy = append(healthy, url)
+	}
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("none of the grid proxies is reachable: %s", strings.Join(urls, ", "))
+	}
+	return healthy, nil
+}
+
+func proxyBusOptions(d *schema.ResourceData) (client.ProxyBusOptions, error) {
+	opts := client.DefaultProxyBusOptions()
+	interval, err := time.ParseDuration(d.Get("rmb_poll_interval").(string))
+	if err != nil || interval <= 0 {
+		return opts, fmt.Errorf("rmb_poll_interval must be a positive duration")
+	}
+	timeout, err := time.ParseDuration(d.Get("rmb_timeout").(string))
+	if err != nil || timeout <= 0 {
+		return opts, fmt.Errorf("rmb_timeout must be a positive duration")
+	}
+	opts.PollInterval = interval
+	if opts.MaxPollInterval < interval {
+		opts.MaxPollInterval = interval
+	}
+	opts.RequestTimeout = timeout
+	return opts, nil
+}
+
 func init() {
 	// Set descriptions to support markdown syntax, this will be used in document generation
 	// and the language server.
@@ -53,7 +134,7 @@ func init() {
 	// }
 }
 
-func New(version string, subext subi.SubstrateExt, st state.StateI) func() *schema.Provider {
+func New(version string, conns subi.Connector, st state.StateI) func() *schema.Provider {
 	return func() *schema.Provider {
 		p := &schema.Provider{
 			Schema: map[string]*schema.Schema{
@@ -72,7 +153,7 @@ func New(version string, subext subi.SubstrateExt, st state.StateI) func() *sche
 				"network": {
 					Type:        schema.TypeString,
 					Required:    true,
-					Description: "grid network, one of: dev test qa main",
+					Description: "grid network, one of: dev test qa main custom",
 					DefaultFunc: schema.EnvDefaultFunc("NETWORK", "dev"),
 				},
 				"substrate_url": {
@@ -81,6 +162,20 @@ func New(version string, subext subi.SubstrateExt, st state.StateI) func() *sche
 					Description: "substrate url, example: wss://tfchain.dev.grid.tf/ws",
 					DefaultFunc: schema.EnvDefaultFunc("SUBSTRATE_URL", nil),
 				},
+				"substrate_urls": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					Description: "fallback substrate urls, used in order when substrate_url is unreachable",
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+				"substrate_version": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "substrate client compatibility of a custom network, one of: dev qa test main. detected from the chain metadata if not set",
+					DefaultFunc: schema.EnvDefaultFunc("SUBSTRATE_VERSION", nil),
+				},
 				"rmb_redis_url": {
 					Type:        schema.TypeString,
 					Optional:    true,
@@ -92,58 +187,100 @@ func New(version string, subext subi.SubstrateExt, st state.StateI) func() *sche
 					Description: "rmb proxy url, example: https://gridproxy.dev.grid.tf/",
 					DefaultFunc: schema.EnvDefaultFunc("RMB_PROXY_URL", nil),
 				},
+				"rmb_proxy_urls": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					Description: "fallback rmb proxy urls, used in order when rmb_proxy_url is unreachable",
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+				"rmb_poll_interval": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "initial interval between polls for rmb proxy responses, example: 500ms",
+					Default:     "1s",
+				},
+				"rmb_timeout": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "timeout of a single request to the rmb proxy, example: 30s",
+					Default:     "30s",
+				},
 				"use_rmb_proxy": {
 					Type:        schema.TypeBool,
 					Optional:    true,
-					Description: "whether to use the rmb proxy or not",
+					Description: "whether to use the rmb proxy or not, ignored if rmb_transport is set",
 					DefaultFunc: schema.EnvDefaultFunc("USE_RMB_PROXY", true),
 				},
+				"rmb_transport": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "rmb transport, one of: proxy redis",
+					DefaultFunc: schema.EnvDefaultFunc("RMB_TRANSPORT", nil),
+				},
 				"verify_reply": {
 					Type:        schema.TypeBool,
 					Optional:    true,
-					Description: "whether to verify rmb replies (temporary for dev use only)",
-					DefaultFunc: schema.EnvDefaultFunc("VERIFY_REPLY", false),
+					Description: "whether to verify the signatures of rmb replies",
+					DefaultFunc: schema.EnvDefaultFunc("VERIFY_REPLY", true),
+				},
+				"record_cassette": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "file to append the rmb and grid proxy traffic to, with secrets redacted. only supported by the proxy transport, the substrate calls are not recorded",
+					DefaultFunc: schema.EnvDefaultFunc("GRID_RECORD_CASSETTE", nil),
+				},
+				"replay_cassette": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "file to serve the rmb and grid proxy traffic from instead of the network. only supported by the proxy transport. substrate is not replayed, the replay signs in on the chain with the mnemonics and the contracts it creates, updates, or cancels are real",
+					DefaultFunc: schema.EnvDefaultFunc("GRID_REPLAY_CASSETTE", nil),
 				},
 			},
 			DataSourcesMap: map[string]*schema.Resource{
 				"grid_gateway_domain": dataSourceGatewayDomain(),
+				"grid_network_ips":    dataSourceNetworkIPs(),
 			},
 			ResourcesMap: map[string]*schema.Resource{
-				"grid_scheduler":  ReourceScheduler(),
-				"grid_deployment": resourceDeployment(),
-				"grid_network":    resourceNetwork(),
-				"grid_kubernetes": resourceKubernetes(),
-				"grid_name_proxy": resourceGatewayNameProxy(),
-				"grid_fqdn_proxy": resourceGatewayFQDNProxy(),
+				"grid_scheduler":       ReourceScheduler(),
+				"grid_deployment":      resourceDeployment(),
+				"grid_network":         resourceNetwork(),
+				"grid_network_peering": resourceNetworkPeering(),
+				"grid_kubernetes":      resourceKubernetes(),
+				"grid_name_proxy":      resourceGatewayNameProxy(),
+				"grid_fqdn_proxy":      resourceGatewayFQDNProxy(),
+				"grid_vm_group":        resourceVMGroup(),
 			},
 		}
 
-		p.ConfigureContextFunc = providerConfigure(subext, st)
+		p.ConfigureContextFunc = providerConfigure(conns, st)
 
 		return p
 	}
 }
 
 type apiClient struct {
-	twin_id       uint32
-	mnemonics     string
-	substrate_url string
-	rmb_redis_url string
-	use_rmb_proxy bool
-	grid_client   proxy.Client
-	rmb           rmb.Client
-	substrateConn subi.SubstrateExt
-	manager       subi.Manager
-	identity      subi.Identity
-	state         state.StateI
+	twin_id        uint32
+	mnemonics      string
+	substrate_urls []string
+	rmb_redis_url  string
+	rmb_transport  string
+	grid_client    proxy.Client
+	rmb            rmb.Client
+	substrateConn  subi.SubstrateExt
+	manager        subi.Manager
+	identity       subi.Identity
+	state          state.StateI
+	// networkLock serializes the network extensions of the resources deployed in parallel
+	networkLock sync.Mutex
 }
 
-func providerConfigure(sub subi.SubstrateExt, st state.StateI) func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+func providerConfigure(conns subi.Connector, st state.StateI) func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
 	return func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
 		rand.Seed(time.Now().UnixNano())
 		var err error
 		apiClient := apiClient{}
-		apiClient.substrateConn = sub
 		apiClient.mnemonics = d.Get("mnemonics").(string)
 		key_type := d.Get("key_type").(string)
 		var identity subi.Identity
@@ -163,28 +300,53 @@ func providerConfigure(sub subi.SubstrateExt, st state.StateI) func(ctx context.
 		}
 		apiClient.identity = identity
 		network := d.Get("network").(string)
-		if network != "dev" && network != "qa" && network != "test" && network != "main" {
-			return nil, diag.Errorf("network must be one of dev, qa, test, and main")
-		}
-		apiClient.substrate_url = SUBSTRATE_URL[network]
-		rmb_proxy_url := RMB_PROXY_URL[network]
-		substrate_url := d.Get("substrate_url").(string)
-		passed_rmb_proxy_url := d.Get("rmb_proxy_url").(string)
-		if substrate_url != "" {
-			log.Printf("substrate url is not null %s", substrate_url)
-			apiClient.substrate_url = substrate_url
-		}
-		if passed_rmb_proxy_url != "" {
-			rmb_proxy_url = passed_rmb_proxy_url
-		}
-		log.Printf("substrate url: %s %s\n", apiClient.substrate_url, substrate_url)
-		apiClient.manager = SubstrateVersion[network](apiClient.substrate_url)
-		sub, err := apiClient.manager.SubstrateExt()
+		if network != "dev" && network != "qa" && network != "test" && network != "main" && network != CustomNetwork {
+			return nil, diag.Errorf("network must be one of dev, qa, test, main, and custom")
+		}
+		apiClient.substrate_urls = endpoints(d, "substrate_url", "substrate_urls", SUBSTRATE_URL[network])
+		rmb_proxy_urls := endpoints(d, "rmb_proxy_url", "rmb_proxy_urls", RMB_PROXY_URL[network])
+		if network == CustomNetwork && (len(apiClient.substrate_urls) == 0 || len(rmb_proxy_urls) == 0) {
+			return nil, diag.Errorf("substrate_url and rmb_proxy_url must be set for a custom network")
+		}
+		log.Printf("substrate urls: %s\n", strings.Join(apiClient.substrate_urls, ", "))
+		version, newManager, err := ManagerConstructor(network, d.Get("substrate_version").(string), apiClient.substrate_urls...)
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+		apiClient.manager = newManager(apiClient.substrate_urls...)
+		// the connection is shared between configurations and closed on plugin shutdown
+		sub, err := conns.Connect(version, newManager, apiClient.substrate_urls...)
 		if err != nil {
 			return nil, diag.FromErr(errors.Wrap(err, "couldn't get substrate client"))
 		}
-		defer sub.Close()
-		apiClient.use_rmb_proxy = d.Get("use_rmb_proxy").(bool)
+		apiClient.substrateConn = sub
+		record_cassette := d.Get("record_cassette").(string)
+		replay_cassette := d.Get("replay_cassette").(string)
+		if record_cassette != "" && replay_cassette != "" {
+			return nil, diag.Errorf("record_cassette and replay_cassette can't be used together")
+		}
+		if replay_cassette == "" {
+			rmb_proxy_urls, err = healthyProxies(rmb_proxy_urls)
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+		}
+		apiClient.rmb_transport = d.Get("rmb_transport").(string)
+		if apiClient.rmb_transport == "" {
+			apiClient.rmb_transport = RMBTransportRedis
+			if d.Get("use_rmb_proxy").(bool) {
+				apiClient.rmb_transport = RMBTransportProxy
+			}
+		}
+		if apiClient.rmb_transport != RMBTransportProxy && apiClient.rmb_transport != RMBTransportRedis {
+			return nil, diag.Errorf("rmb_transport must be one of %s and %s", RMBTransportProxy, RMBTransportRedis)
+		}
+		if record_cassette != "" && apiClient.rmb_transport != RMBTransportProxy {
+			return nil, diag.Errorf("record_cassette is only supported by the %s transport", RMBTransportProxy)
+		}
+		if replay_cassette != "" && apiClient.rmb_transport != RMBTransportProxy {
+			return nil, diag.Errorf("replay_cassette is only supported by the %s transport", RMBTransportProxy)
+		}
 
 		apiClient.rmb_redis_url = d.Get("rmb_redis_url").(string)
 
@@ -200,11 +362,39 @@ func providerConfigure(sub subi.SubstrateExt, st state.StateI) func(ctx context.
 			return nil, diag.FromErr(errors.Wrap(err, "failed to get twin for the given mnemonics"))
 		}
 		apiClient.twin_id = twin
+		if replay_cassette != "" {
+			c, err := cassette.Load(replay_cassette)
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+			log.Printf("replaying rmb and grid proxy traffic from %s", replay_cassette)
+			apiClient.rmb = cassette.NewReplayBus(c)
+			apiClient.grid_client = cassette.NewReplayClient(c)
+			apiClient.state = st
+			return &apiClient, nil
+		}
+		var recorder *cassette.Recorder
+		if record_cassette != "" {
+			recorder, err = cassette.NewRecorder(record_cassette)
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+			log.Printf("recording rmb and grid proxy traffic to %s", record_cassette)
+		}
 		var cl rmb.Client
-		if apiClient.use_rmb_proxy {
+		switch apiClient.rmb_transport {
+		case RMBTransportProxy:
 			verify_reply := d.Get("verify_reply").(bool)
-			cl, err = client.NewProxyBus(rmb_proxy_url, apiClient.twin_id, apiClient.substrateConn, identity, verify_reply)
-		} else {
+			var opts client.ProxyBusOptions
+			opts, err = proxyBusOptions(d)
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+			if recorder != nil {
+				opts.Recorder = recorder
+			}
+			cl, err = client.NewProxyBus(rmb_proxy_urls, apiClient.twin_id, apiClient.substrateConn, identity, verify_reply, opts)
+		default:
 			cl, err = rmb.NewClient(apiClient.rmb_redis_url)
 		}
 		if err != nil {
@@ -212,8 +402,12 @@ func providerConfigure(sub subi.SubstrateExt, st state.StateI) func(ctx context.
 		}
 		apiClient.rmb = cl
 
-		grid_client := proxy.NewClient(rmb_proxy_url)
-		apiClient.grid_client = proxy.NewRetryingClient(grid_client)
+		// the healthy proxies are tried in order, starting with the first one
+		log.Printf("using grid proxy %s", rmb_proxy_urls[0])
+		apiClient.grid_client = proxy.NewRetryingClient(newFailoverGridClient(rmb_proxy_urls))
+		if recorder != nil {
+			apiClient.grid_client = cassette.NewRecordingClient(apiClient.grid_client, recorder)
+		}
 		if err := preValidate(&apiClient, sub); err != nil {
 			return nil, diag.FromErr(err)
 		}
@@ -1,18 +1,59 @@
 package provider
 
 import (
+	"context"
+	"path/filepath"
 	"testing"
 
-	"github.com/golang/mock/gomock"
-	mock "github.com/threefoldtech/terraform-provider-grid/internal/provider/mocks"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/gridtest"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
 )
 
 func TestProvider(t *testing.T) {
 	st := state.NewState()
-	ctrl := gomock.NewController(t)
-	subext := mock.NewMockSubstrateExt(ctrl)
-	if err := New("dev", subext, &st)().InternalValidate(); err != nil {
+	if err := New("dev", subi.NewConnections(), &st)().InternalValidate(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 }
+
+func TestManagerConstructor(t *testing.T) {
+	if _, _, err := ManagerConstructor(CustomNetwork, "unknown", "ws://127.0.0.1:9944"); err == nil {
+		t.Fatalf("expected an error for an unknown substrate version")
+	}
+	version, newManager, err := ManagerConstructor(CustomNetwork, "test", "ws://127.0.0.1:9944")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if version != "test" {
+		t.Fatalf("expected the test version, got %s", version)
+	}
+	manager := newManager("ws://127.0.0.1:9944")
+	if _, ok := manager.(*subi.TestManager); !ok {
+		t.Fatalf("expected a test manager, got %T", manager)
+	}
+}
+
+func TestCassettesNeedProxyTransport(t *testing.T) {
+	grid := gridtest.NewGrid()
+	t.Cleanup(grid.Close)
+	file := filepath.Join(t.TempDir(), "apply.cassette")
+	for _, attr := range []string{"record_cassette", "replay_cassette"} {
+		st := state.NewState()
+		p := New("test", grid.Substrate, &st)()
+		diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+			"network":           "custom",
+			"substrate_version": "dev",
+			"substrate_url":     "ws://gridtest",
+			"rmb_proxy_url":     grid.URL(),
+			"rmb_transport":     "redis",
+			"mnemonics":         "//Alice",
+			"key_type":          "sr25519",
+			attr:                file,
+		}))
+		assert.True(t, diags.HasError())
+		assert.Contains(t, diags[0].Summary, attr+" is only supported by the proxy transport")
+	}
+}
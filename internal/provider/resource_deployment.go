@@ -2,12 +2,14 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
 )
 
 func resourceDeployment() *schema.Resource {
@@ -20,6 +22,12 @@ func resourceDeployment() *schema.Resource {
 		UpdateContext: ResourceFunc(resourceDeploymentUpdate),
 		DeleteContext: ResourceFunc(resourceDeploymentDelete),
 
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceDeploymentImport,
+		},
+
+		CustomizeDiff: resourceDeploymentCustomizeDiff,
+
 		Timeouts: &schema.ResourceTimeout{
 			Create: schema.DefaultTimeout(45 * time.Minute),
 		},
@@ -54,7 +62,7 @@ func resourceDeployment() *schema.Resource {
 			"network_name": {
 				Type:        schema.TypeString,
 				Optional:    true,
-				Description: "Network to use for Zmachines",
+				Description: "Network to use for Zmachines, required when there are vms (even planetary only ones) since zos gives every zmachine a private network interface",
 			},
 			"disks": {
 				Type:     schema.TypeList,
@@ -180,6 +188,11 @@ func resourceDeployment() *schema.Resource {
 							Computed:    true,
 							Description: "The private wg IP of the Zmachine",
 						},
+						"ip6": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The private IPv6 of the Zmachine (derived from ip by zos)",
+						},
 						"cpu": {
 							Type:        schema.TypeInt,
 							Optional:    true,
@@ -418,6 +431,21 @@ func resourceDeployment() *schema.Resource {
 	}
 }
 
+// resourceDeploymentCustomizeDiff reports the conflicting static ips of the vms in the plan
+func resourceDeploymentCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	apiClient := meta.(*apiClient)
+	networkName := d.Get("network_name").(string)
+	if networkName == "" {
+		return nil
+	}
+	vms := make([]map[string]interface{}, 0)
+	for _, vm := range d.Get("vms").([]interface{}) {
+		vms = append(vms, vm.(map[string]interface{}))
+	}
+	network := apiClient.state.GetNetworkState().GetNetwork(networkName)
+	return checkVMIPs(network, uint32(d.Get("node").(int)), d.Id(), vms)
+}
+
 func resourceDeploymentCreate(ctx context.Context, sub subi.SubstrateExt, d *schema.ResourceData, apiClient *apiClient) (Marshalable, error) {
 	deployer, err := getDeploymentDeployer(d, apiClient)
 	if err != nil {
@@ -463,3 +491,42 @@ func resourceDeploymentDelete(ctx context.Context, sub subi.SubstrateExt, d *sch
 
 	return &deployer, deployer.Cancel(ctx, sub)
 }
+
+// resourceDeploymentImport adopts a deployment using an id of the form <node_id>:<contract_id>
+func resourceDeploymentImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	apiClient := meta.(*apiClient)
+	nodeID, contractID, err := parseNodeContractID(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	imported, err := importDeployment(ctx, apiClient, contractID)
+	if err != nil {
+		return nil, err
+	}
+	if imported.Node != nodeID {
+		return nil, fmt.Errorf("contract %d is on node %d not %d", contractID, imported.Node, nodeID)
+	}
+	networkName := ""
+	for _, wl := range imported.Deployment.Workloads {
+		if wl.Type != zos.ZMachineType {
+			continue
+		}
+		data, err := wl.WorkloadData()
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse vm %s", wl.Name)
+		}
+		vm := data.(*zos.ZMachine)
+		if len(vm.Network.Interfaces) == 0 {
+			continue
+		}
+		networkName = string(vm.Network.Interfaces[0].Network)
+		if err := importNodeSubnet(ctx, apiClient, networkName, nodeID, vm.Network.Interfaces[0].IP); err != nil {
+			return nil, err
+		}
+	}
+	d.SetId(fmt.Sprint(contractID))
+	d.Set("node", nodeID)
+	d.Set("network_name", networkName)
+	setImportedDeploymentData(d, imported.Data)
+	return readImported(ctx, d, meta, resourceFunc(resourceDeploymentRead, true))
+}
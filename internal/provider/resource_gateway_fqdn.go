@@ -2,10 +2,12 @@ package provider
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
 )
 
 func resourceGatewayFQDNProxy() *schema.Resource {
@@ -18,6 +20,10 @@ func resourceGatewayFQDNProxy() *schema.Resource {
 		UpdateContext: ResourceFunc(resourceGatewayFQDNUpdate),
 		DeleteContext: ResourceFunc(resourceGatewayFQDNDelete),
 
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGatewayFQDNImport,
+		},
+
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -103,3 +109,37 @@ func resourceGatewayFQDNDelete(ctx context.Context, sub subi.SubstrateExt, d *sc
 	}
 	return &deployer, deployer.Cancel(ctx, sub)
 }
+
+// resourceGatewayFQDNImport adopts an fqdn proxy using an id of the form <node_id>:<contract_id>
+func resourceGatewayFQDNImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	apiClient := meta.(*apiClient)
+	nodeID, contractID, err := parseNodeContractID(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	imported, err := importDeployment(ctx, apiClient, contractID)
+	if err != nil {
+		return nil, err
+	}
+	if imported.Node != nodeID {
+		return nil, fmt.Errorf("contract %d is on node %d not %d", contractID, imported.Node, nodeID)
+	}
+	name := ""
+	for _, wl := range imported.Deployment.Workloads {
+		if wl.Type == zos.GatewayFQDNProxyType {
+			name = string(wl.Name)
+			break
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("deployment %d has no gateway fqdn proxy", contractID)
+	}
+	d.SetId(fmt.Sprint(contractID))
+	d.Set("node", nodeID)
+	d.Set("name", name)
+	d.Set("node_deployment_id", map[string]interface{}{fmt.Sprint(nodeID): int(contractID)})
+	if imported.Data.ProjectName != "" {
+		d.Set("solution_type", imported.Data.ProjectName)
+	}
+	return readImported(ctx, d, meta, resourceFunc(resourceGatewayFQDNRead, true))
+}
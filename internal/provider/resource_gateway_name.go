@@ -2,10 +2,13 @@ package provider
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
 )
 
 func resourceGatewayNameProxy() *schema.Resource {
@@ -18,6 +21,10 @@ func resourceGatewayNameProxy() *schema.Resource {
 		UpdateContext: ResourceFunc(resourceGatewayNameUpdate),
 		DeleteContext: ResourceFunc(resourceGatewayNameDelete),
 
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGatewayNameImport,
+		},
+
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -107,3 +114,43 @@ func resourceGatewayNameDelete(ctx context.Context, sub subi.SubstrateExt, d *sc
 	}
 	return &deployer, deployer.Cancel(ctx, sub)
 }
+
+// resourceGatewayNameImport adopts a name proxy using an id of the form <node_id>:<contract_id>
+// where the contract is the node contract of the gateway deployment
+func resourceGatewayNameImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	apiClient := meta.(*apiClient)
+	nodeID, contractID, err := parseNodeContractID(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	imported, err := importDeployment(ctx, apiClient, contractID)
+	if err != nil {
+		return nil, err
+	}
+	if imported.Node != nodeID {
+		return nil, fmt.Errorf("contract %d is on node %d not %d", contractID, imported.Node, nodeID)
+	}
+	name := ""
+	for _, wl := range imported.Deployment.Workloads {
+		if wl.Type == zos.GatewayNameProxyType {
+			name = string(wl.Name)
+			break
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("deployment %d has no gateway name proxy", contractID)
+	}
+	nameContractID, err := apiClient.substrateConn.GetContractIDByNameRegistration(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't get the name contract of %s", name)
+	}
+	d.SetId(uuid.New().String())
+	d.Set("node", nodeID)
+	d.Set("name", name)
+	d.Set("node_deployment_id", map[string]interface{}{fmt.Sprint(nodeID): int(contractID)})
+	d.Set("name_contract_id", nameContractID)
+	if imported.Data.ProjectName != "" {
+		d.Set("solution_type", imported.Data.ProjectName)
+	}
+	return readImported(ctx, d, meta, resourceFunc(resourceGatewayNameRead, true))
+}
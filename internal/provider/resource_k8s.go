@@ -8,19 +8,29 @@ import (
 	"net"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/pkg/errors"
 	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/internal/provider/scheduler"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/deployer"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/workloads"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
 	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+	"golang.org/x/crypto/ssh"
 )
 
+// k8sPoolNameRegex matches the pool names that can't end up in the names of the workers of other pools,
+// the index of a worker is added to the name of its pool
+var k8sPoolNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]*[a-zA-Z_]$`)
+
 func resourceKubernetes() *schema.Resource {
 	return &schema.Resource{
 		// This description is used by the documentation generator and the language server.
@@ -31,6 +41,10 @@ func resourceKubernetes() *schema.Resource {
 		UpdateContext: resourceK8sUpdate,
 		DeleteContext: resourceK8sDelete,
 
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceK8sImport,
+		},
+
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -60,6 +74,30 @@ func resourceKubernetes() *schema.Resource {
 				Default:     "",
 				Description: "SSH key to access the cluster nodes",
 			},
+			"ssh_private_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Private key of one of the keys in ssh_key, the provider uses it to fetch the kubeconfig from the masters instead of generating a key pair",
+			},
+			"generated_ssh_private_key": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Sensitive:   true,
+				Description: "Private key generated when the cluster is created without ssh_private_key, its public key is added to the cluster nodes to fetch the kubeconfig",
+			},
+			"kubeconfig": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Sensitive:   true,
+				Description: "Kubeconfig of the cluster fetched from a master over ssh through its public or planetary ip, the server is api_endpoint if it's set, otherwise the ip it's fetched through",
+			},
+			"ssh_host_keys": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Host keys of the masters by ip, pinned on the first ssh connection. The next connections fail if a master presents another key",
+			},
 			"token": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -72,61 +110,142 @@ func resourceKubernetes() *schema.Resource {
 				Description: "Network IP ranges of nodes in the cluster (usually assigned from grid_network.<network-resource-name>.nodes_ip_range)",
 			},
 			"master": {
-				MaxItems: 1,
+				MaxItems:     1,
+				Type:         schema.TypeList,
+				Optional:     true,
+				ExactlyOneOf: []string{"master", "masters"},
+				Elem:         k8sMasterResource(),
+				Description:  "Single master of the cluster",
+			},
+			"masters": {
+				MinItems:     3,
+				MaxItems:     5,
+				Type:         schema.TypeList,
+				Optional:     true,
+				ExactlyOneOf: []string{"master", "masters"},
+				Elem:         k8sMasterResource(),
+				Description:  "Masters of a highly available control plane with an embedded etcd (3 or 5 on different nodes), the first one initializes the cluster and the others join it, the flist must read K3S_CLUSTER_INIT and K3S_MASTER. Can't be used with master",
+			},
+			"api_endpoint": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Stable host[:port] of the masters the workers join the cluster through, like a gateway name proxy passing the tls to them or a dns name resolving to them. Required with masters, the port is 6443 by default",
+			},
+			"k3s_version": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateK3sVersion,
+				Description:  "k3s release like v1.26.4+k3s1 or release channel (stable, latest, testing or v<major>.<minor>) installed on the nodes through INSTALL_K3S_VERSION or INSTALL_K3S_CHANNEL, the one of the flist by default",
+			},
+			"flannel_backend": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(k3sFlannelBackends, false),
+				Description:  "Flannel backend of the cluster network: vxlan, host-gw, wireguard-native or none, vxlan by default",
+			},
+			"disable": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringInSlice(k3sComponents, false),
+				},
+				Description: "Packaged components k3s doesn't deploy: traefik, servicelb, local-storage, metrics-server or coredns",
+			},
+			"server_args": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: validateK3sArg},
+				Description: "Extra flags of k3s server on the masters like --kube-apiserver-arg=v=2, the flags set by the provider or through other attributes aren't allowed",
+			},
+			"agent_args": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: validateK3sArg},
+				Description: "Extra flags of k3s agent on the workers like --kubelet-arg=max-pods=200, the flags set by the provider or through other attributes aren't allowed",
+			},
+			"rolling_update": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Update the changed nodes of the cluster a few at a time, waiting for each batch to be back before updating the next one",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"max_unavailable": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      1,
+							ValidateFunc: validation.IntAtLeast(1),
+							Description:  "Number of nodes updated at the same time",
+						},
+						"wait_for_ready": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Wait for the kubernetes nodes of an updated node to be Ready through a master with a public or planetary ip before updating the next nodes",
+						},
+					},
+				},
+			},
+			"workers": {
 				Type:     schema.TypeList,
-				Required: true,
+				Optional: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"name": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"flist": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "https://hub.grid.tf/tf-official-apps/threefoldtech-k3s-latest.flist",
+						},
+						"flist_checksum": {
 							Type:        schema.TypeString,
-							Required:    true,
-							Description: "Master name",
+							Optional:    true,
+							Description: "if present, the flist is rejected if it has a different hash. the flist hash can be found by append",
 						},
-						"node": {
+						"disk_size": {
 							Type:        schema.TypeInt,
 							Required:    true,
-							Description: "Node ID",
+							Description: "Data disk size in GBs",
 						},
-						"disk_size": {
+						"node": {
 							Type:        schema.TypeInt,
 							Required:    true,
-							Description: "Data disk size in GBs",
+							Description: "Node ID",
 						},
 						"publicip": {
 							Type:        schema.TypeBool,
 							Optional:    true,
 							Description: "true to enable public ip reservation",
 						},
+						"computedip": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The reserved public ip",
+						},
 						"publicip6": {
 							Type:        schema.TypeBool,
 							Optional:    true,
 							Description: "true to enable public ipv6 reservation",
 						},
-						"flist": {
-							Type:     schema.TypeString,
-							Optional: true,
-							Default:  "https://hub.grid.tf/tf-official-apps/threefoldtech-k3s-latest.flist",
-						},
-						"flist_checksum": {
-							Type:        schema.TypeString,
-							Optional:    true,
-							Description: "if present, the flist is rejected if it has a different hash. the flist hash can be found by append",
-						},
-						"computedip": {
-							Type:        schema.TypeString,
-							Computed:    true,
-							Description: "The reserved public IP",
-						},
 						"computedip6": {
 							Type:        schema.TypeString,
 							Computed:    true,
-							Description: "The reserved public IPv6",
+							Description: "The reserved public ipv6",
 						},
 						"ip": {
 							Type:        schema.TypeString,
 							Computed:    true,
 							Description: "The private IP (computed from nodes_ip_range)",
 						},
+						"ip6": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The private IPv6 (derived from ip by zos)",
+						},
 						"cpu": {
 							Type:        schema.TypeInt,
 							Required:    true,
@@ -148,17 +267,43 @@ func resourceKubernetes() *schema.Resource {
 							Computed:    true,
 							Description: "Allocated Yggdrasil IP",
 						},
+						"labels": k3sLabelsSchema(),
+						"taints": k3sTaintsSchema(),
 					},
 				},
 			},
-			"workers": {
-				Type:     schema.TypeList,
-				Optional: true,
+			"node_pool": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Workers of the same size placed by the scheduler, named <name><index>. Scaling down removes the newest workers after draining them",
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"name": {
-							Type:     schema.TypeString,
-							Required: true,
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringMatch(k8sPoolNameRegex, "must be letters, digits and underscores ending with a letter or an underscore, so the workers of the pools can't have the same name like w1 worker 0 and w worker 10"),
+							Description:  "Pool name, the prefix of its workers names. It can't end with a digit",
+						},
+						"count": {
+							Type:         schema.TypeInt,
+							Required:     true,
+							ValidateFunc: validation.IntAtLeast(0),
+							Description:  "Number of workers in the pool",
+						},
+						"cpu": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "Number of VCPUs of each worker",
+						},
+						"memory": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "Memory size of each worker",
+						},
+						"disk_size": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "Data disk size of each worker in GBs",
 						},
 						"flist": {
 							Type:     schema.TypeString,
@@ -170,61 +315,76 @@ func resourceKubernetes() *schema.Resource {
 							Optional:    true,
 							Description: "if present, the flist is rejected if it has a different hash. the flist hash can be found by append",
 						},
-						"disk_size": {
-							Type:        schema.TypeInt,
-							Required:    true,
-							Description: "Data disk size in GBs",
-						},
-						"node": {
-							Type:        schema.TypeInt,
-							Required:    true,
-							Description: "Node ID",
-						},
 						"publicip": {
 							Type:        schema.TypeBool,
 							Optional:    true,
 							Description: "true to enable public ip reservation",
 						},
-						"computedip": {
-							Type:        schema.TypeString,
-							Computed:    true,
-							Description: "The reserved public ip",
-						},
 						"publicip6": {
 							Type:        schema.TypeBool,
 							Optional:    true,
 							Description: "true to enable public ipv6 reservation",
 						},
-						"computedip6": {
-							Type:        schema.TypeString,
-							Computed:    true,
-							Description: "The reserved public ipv6",
-						},
-						"ip": {
-							Type:        schema.TypeString,
-							Computed:    true,
-							Description: "The private IP (computed from nodes_ip_range)",
-						},
-						"cpu": {
-							Type:        schema.TypeInt,
-							Required:    true,
-							Description: "Number of VCPUs",
-						},
-						"memory": {
-							Type:        schema.TypeInt,
-							Required:    true,
-							Description: "Memory size",
-						},
 						"planetary": {
 							Type:        schema.TypeBool,
 							Optional:    true,
 							Default:     false,
 							Description: "Enable Yggdrasil allocation",
 						},
-						"ygg_ip": {
+						"farm": {
 							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Farm name to pick the nodes from",
+						},
+						"ipv4": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Pick only nodes with public config containing ipv4",
+						},
+						"labels": k3sLabelsSchema(),
+						"taints": k3sTaintsSchema(),
+						"workers": {
+							Type:        schema.TypeList,
 							Computed:    true,
-							Description: "Allocated Yggdrasil IP",
+							Description: "Workers of the pool with the nodes picked for them, they keep their nodes when the pool changes",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": {
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+									"node": {
+										Type:        schema.TypeInt,
+										Computed:    true,
+										Description: "Node ID",
+									},
+									"ip": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "The private IP (computed from nodes_ip_range)",
+									},
+									"ip6": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "The private IPv6 (derived from ip by zos)",
+									},
+									"computedip": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "The reserved public ip",
+									},
+									"computedip6": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "The reserved public ipv6",
+									},
+									"ygg_ip": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "Allocated Yggdrasil IP",
+									},
+								},
+							},
 						},
 					},
 				},
@@ -233,6 +393,91 @@ func resourceKubernetes() *schema.Resource {
 	}
 }
 
+func k8sMasterResource() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Master name",
+			},
+			"node": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "Node ID",
+			},
+			"disk_size": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "Data disk size in GBs",
+			},
+			"publicip": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "true to enable public ip reservation",
+			},
+			"publicip6": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "true to enable public ipv6 reservation",
+			},
+			"flist": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "https://hub.grid.tf/tf-official-apps/threefoldtech-k3s-latest.flist",
+			},
+			"flist_checksum": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "if present, the flist is rejected if it has a different hash. the flist hash can be found by append",
+			},
+			"computedip": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The reserved public IP",
+			},
+			"computedip6": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The reserved public IPv6",
+			},
+			"ip": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The private IP (computed from nodes_ip_range)",
+			},
+			"ip6": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The private IPv6 (derived from ip by zos)",
+			},
+			"cpu": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "Number of VCPUs",
+			},
+			"memory": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "Memory size",
+			},
+			"planetary": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Enable Yggdrasil allocation",
+			},
+			"ygg_ip": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Allocated Yggdrasil IP",
+			},
+			"labels": k3sLabelsSchema(),
+			"taints": k3sTaintsSchema(),
+		},
+	}
+}
+
 type K8sNodeData struct {
 	Name          string
 	Node          uint32
@@ -246,13 +491,42 @@ type K8sNodeData struct {
 	ComputedIP6   string
 	YggIP         string
 	IP            string
+	IP6           string
+	Cpu           int
+	Memory        int
+	Labels        map[string]string
+	Taints        []string
+	// Pool is the node pool of the worker, empty for the masters and the listed workers
+	Pool string
+}
+
+// K8sNodePool is a group of workers of the same size placed by the scheduler
+type K8sNodePool struct {
+	Name          string
+	Count         int
 	Cpu           int
 	Memory        int
+	DiskSize      int
+	Flist         string
+	FlistChecksum string
+	PublicIP      bool
+	PublicIP6     bool
+	Planetary     bool
+	Farm          string
+	IPv4          bool
+	Labels        map[string]string
+	Taints        []string
 }
 
 type K8sDeployer struct {
-	Master           *K8sNodeData
+	// Masters has the single master, or the masters of a highly available cluster with HA set
+	Masters          []K8sNodeData
+	HA               bool
+	APIEndpoint      string
 	Workers          []K8sNodeData
+	NodePools        []K8sNodePool
+	K3s              K3sConfig
+	RollingUpdate    *K8sRollingUpdate
 	NodesIPRange     map[uint32]gridtypes.IPNet
 	Token            string
 	SSHKey           string
@@ -261,10 +535,28 @@ type K8sDeployer struct {
 
 	APIClient *apiClient
 
-	NodeUsedIPs map[uint32][]byte
-	ncPool      *client.NodeClientPool
-	d           *schema.ResourceData
-	deployer    deployer.Deployer
+	secrets        *k8sSecrets
+	hostKeys       *knownHosts
+	ncPool         *client.NodeClientPool
+	d              *schema.ResourceData
+	deployer       deployer.Deployer
+	deploymentData string
+}
+
+// K8sRollingUpdate is how the nodes of the cluster are updated with rolling_update, without it they are
+// updated one after the other once the workloads of the previous one are ok, without waiting for its
+// kubernetes nodes to be ready
+type K8sRollingUpdate struct {
+	MaxUnavailable int
+	WaitForReady   bool
+}
+
+// k8sSecrets are kept behind a pointer so they aren't printed with the deployer in the logs
+type k8sSecrets struct {
+	SSHPrivateKey      string
+	GeneratedSSHKey    string
+	GeneratedPublicKey string
+	Kubeconfig         string
 }
 
 func NewK8sNodeData(m map[string]interface{}) K8sNodeData {
@@ -281,11 +573,77 @@ func NewK8sNodeData(m map[string]interface{}) K8sNodeData {
 		ComputedIP6:   m["computedip6"].(string),
 		YggIP:         m["ygg_ip"].(string),
 		IP:            m["ip"].(string),
+		IP6:           m["ip6"].(string),
 		Cpu:           m["cpu"].(int),
 		Memory:        m["memory"].(int),
+		Labels:        stringMap(m["labels"].(map[string]interface{})),
+		Taints:        stringList(m["taints"].([]interface{})),
+	}
+}
+
+func NewK8sNodePool(m map[string]interface{}) K8sNodePool {
+	return K8sNodePool{
+		Name:          m["name"].(string),
+		Count:         m["count"].(int),
+		Cpu:           m["cpu"].(int),
+		Memory:        m["memory"].(int),
+		DiskSize:      m["disk_size"].(int),
+		Flist:         m["flist"].(string),
+		FlistChecksum: m["flist_checksum"].(string),
+		PublicIP:      m["publicip"].(bool),
+		PublicIP6:     m["publicip6"].(bool),
+		Planetary:     m["planetary"].(bool),
+		Farm:          m["farm"].(string),
+		IPv4:          m["ipv4"].(bool),
+		Labels:        stringMap(m["labels"].(map[string]interface{})),
+		Taints:        stringList(m["taints"].([]interface{})),
+	}
+}
+
+// worker returns the worker of the pool from its computed attributes
+func (p *K8sNodePool) worker(m map[string]interface{}) K8sNodeData {
+	return K8sNodeData{
+		Name:          m["name"].(string),
+		Node:          uint32(m["node"].(int)),
+		DiskSize:      p.DiskSize,
+		PublicIP:      p.PublicIP,
+		PublicIP6:     p.PublicIP6,
+		Planetary:     p.Planetary,
+		Flist:         p.Flist,
+		FlistChecksum: p.FlistChecksum,
+		ComputedIP:    m["computedip"].(string),
+		ComputedIP6:   m["computedip6"].(string),
+		YggIP:         m["ygg_ip"].(string),
+		IP:            m["ip"].(string),
+		IP6:           m["ip6"].(string),
+		Cpu:           p.Cpu,
+		Memory:        p.Memory,
+		Labels:        p.Labels,
+		Taints:        p.Taints,
+		Pool:          p.Name,
 	}
 }
 
+func (p *K8sNodePool) Dictify(workers []interface{}) map[string]interface{} {
+	res := make(map[string]interface{})
+	res["name"] = p.Name
+	res["count"] = p.Count
+	res["cpu"] = p.Cpu
+	res["memory"] = p.Memory
+	res["disk_size"] = p.DiskSize
+	res["flist"] = p.Flist
+	res["flist_checksum"] = p.FlistChecksum
+	res["publicip"] = p.PublicIP
+	res["publicip6"] = p.PublicIP6
+	res["planetary"] = p.Planetary
+	res["farm"] = p.Farm
+	res["ipv4"] = p.IPv4
+	res["labels"] = interfaceMap(p.Labels)
+	res["taints"] = interfaceList(p.Taints)
+	res["workers"] = workers
+	return res
+}
+
 func NewK8sNodeDataFromWorkload(w gridtypes.Workload, nodeID uint32, diskSize int, computedIP string, computedIP6 string) (K8sNodeData, error) {
 	var k K8sNodeData
 	data, err := w.WorkloadData()
@@ -321,30 +679,40 @@ func NewK8sDeployer(d *schema.ResourceData, apiClient *apiClient) (K8sDeployer,
 	ns := apiClient.state.GetNetworkState()
 	network := ns.GetNetwork(networkName)
 
-	master := NewK8sNodeData(d.Get("master").([]interface{})[0].(map[string]interface{}))
-	workers := make([]K8sNodeData, 0)
-	usedIPs := make(map[uint32][]byte)
-
-	if master.IP != "" {
-		usedIPs[master.Node] = append(usedIPs[master.Node], net.ParseIP(master.IP)[3])
+	ha := len(d.Get("masters").([]interface{})) != 0
+	mastersKey := "master"
+	if ha {
+		mastersKey = "masters"
 	}
-	usedIPs[master.Node] = append(usedIPs[master.Node], network.GetNodeIPsList(master.Node)...)
+	masters := make([]K8sNodeData, 0)
+	for _, m := range d.Get(mastersKey).([]interface{}) {
+		masters = append(masters, NewK8sNodeData(m.(map[string]interface{})))
+	}
+	workers := make([]K8sNodeData, 0)
 	for _, w := range d.Get("workers").([]interface{}) {
-		data := NewK8sNodeData(w.(map[string]interface{}))
-		workers = append(workers, data)
-		if data.IP != "" {
-			usedIPs[data.Node] = append(usedIPs[data.Node], net.ParseIP(data.IP)[3])
-			usedIPs[data.Node] = append(usedIPs[data.Node], network.GetNodeIPsList(data.Node)...)
+		workers = append(workers, NewK8sNodeData(w.(map[string]interface{})))
+	}
+	pools := make([]K8sNodePool, 0)
+	for _, p := range d.Get("node_pool").([]interface{}) {
+		pool := NewK8sNodePool(p.(map[string]interface{}))
+		pools = append(pools, pool)
+		for _, w := range p.(map[string]interface{})["workers"].([]interface{}) {
+			workers = append(workers, pool.worker(w.(map[string]interface{})))
 		}
 	}
 	nodesIPRange := make(map[uint32]gridtypes.IPNet)
 	var err error
-	nodesIPRange[master.Node], err = gridtypes.ParseIPNet(network.GetNodeSubnet(master.Node))
-	if err != nil {
-		return K8sDeployer{}, errors.Wrap(err, "couldn't parse master node ip range")
+	for _, master := range masters {
+		nodesIPRange[master.Node], err = gridtypes.ParseIPNet(network.GetNodeSubnet(master.Node))
+		if err != nil {
+			return K8sDeployer{}, errors.Wrapf(err, "couldn't parse master node (%d) ip range", master.Node)
+		}
 	}
 	for _, worker := range workers {
 		nodesIPRange[worker.Node], err = gridtypes.ParseIPNet(network.GetNodeSubnet(worker.Node))
+		if err != nil && worker.Pool != "" {
+			return K8sDeployer{}, errors.Wrapf(err, "couldn't parse the ip range of node %d picked for worker %s, the network of node pools must have auto_extend", worker.Node, worker.Name)
+		}
 		if err != nil {
 			return K8sDeployer{}, errors.Wrapf(err, "couldn't parse worker node (%d) ip range", worker.Node)
 		}
@@ -360,6 +728,27 @@ func NewK8sDeployer(d *schema.ResourceData, apiClient *apiClient) (K8sDeployer,
 		nodeDeploymentID[uint32(nodeInt)] = deploymentID
 	}
 
+	secrets := k8sSecrets{
+		SSHPrivateKey:   d.Get("ssh_private_key").(string),
+		GeneratedSSHKey: d.Get("generated_ssh_private_key").(string),
+		Kubeconfig:      d.Get("kubeconfig").(string),
+	}
+	if secrets.GeneratedSSHKey != "" {
+		secrets.GeneratedPublicKey, err = sshPublicKey(secrets.GeneratedSSHKey)
+		if err != nil {
+			return K8sDeployer{}, errors.Wrap(err, "couldn't load the generated ssh key")
+		}
+	}
+
+	var rollingUpdate *K8sRollingUpdate
+	for _, r := range d.Get("rolling_update").([]interface{}) {
+		r := r.(map[string]interface{})
+		rollingUpdate = &K8sRollingUpdate{
+			MaxUnavailable: r["max_unavailable"].(int),
+			WaitForReady:   r["wait_for_ready"].(bool),
+		}
+	}
+
 	pool := client.NewNodeClientPool(apiClient.rmb)
 	deploymentData := DeploymentData{
 		Name:        d.Get("name").(string),
@@ -371,22 +760,42 @@ func NewK8sDeployer(d *schema.ResourceData, apiClient *apiClient) (K8sDeployer,
 		log.Printf("error parsing deploymentdata: %s", err.Error())
 	}
 	deployer := K8sDeployer{
-		Master:           &master,
+		Masters:          masters,
+		HA:               ha,
+		APIEndpoint:      d.Get("api_endpoint").(string),
 		Workers:          workers,
+		NodePools:        pools,
+		K3s:              NewK3sConfig(d),
+		RollingUpdate:    rollingUpdate,
 		Token:            d.Get("token").(string),
 		SSHKey:           d.Get("ssh_key").(string),
 		NetworkName:      d.Get("network_name").(string),
 		NodeDeploymentID: nodeDeploymentID,
-		NodeUsedIPs:      usedIPs,
 		NodesIPRange:     nodesIPRange,
 		APIClient:        apiClient,
+		secrets:          &secrets,
+		hostKeys:         newKnownHosts(stringMap(d.Get("ssh_host_keys").(map[string]interface{}))),
 		ncPool:           pool,
 		d:                d,
 		deployer:         deployer.NewDeployer(apiClient.identity, apiClient.twin_id, apiClient.grid_client, pool, true, nil, string(deploymentDataStr)),
+		deploymentData:   string(deploymentDataStr),
 	}
 	return deployer, nil
 }
 
+// dictifyPoolWorker returns the computed attributes of a worker of a node pool
+func (k *K8sNodeData) dictifyPoolWorker() map[string]interface{} {
+	res := make(map[string]interface{})
+	res["name"] = k.Name
+	res["node"] = int(k.Node)
+	res["computedip"] = k.ComputedIP
+	res["computedip6"] = k.ComputedIP6
+	res["ygg_ip"] = k.YggIP
+	res["ip"] = k.IP
+	res["ip6"] = k.IP6
+	return res
+}
+
 func (k *K8sNodeData) Dictify() map[string]interface{} {
 	res := make(map[string]interface{})
 	res["name"] = k.Name
@@ -400,8 +809,11 @@ func (k *K8sNodeData) Dictify() map[string]interface{} {
 	res["computedip6"] = k.ComputedIP6
 	res["ygg_ip"] = k.YggIP
 	res["ip"] = k.IP
+	res["ip6"] = k.IP6
 	res["cpu"] = k.Cpu
 	res["memory"] = k.Memory
+	res["labels"] = interfaceMap(k.Labels)
+	res["taints"] = interfaceList(k.Taints)
 	return res
 }
 
@@ -421,9 +833,13 @@ func (k *K8sDeployer) invalidateBrokenAttributes(sub subi.SubstrateExt) error {
 		}
 
 	}
-	if _, ok := validNodes[k.Master.Node]; !ok {
-		k.Master = &K8sNodeData{}
+	newMasters := make([]K8sNodeData, 0)
+	for _, master := range k.Masters {
+		if _, ok := validNodes[master.Node]; ok {
+			newMasters = append(newMasters, master)
+		}
 	}
+	k.Masters = newMasters
 	for _, worker := range k.Workers {
 		if _, ok := validNodes[worker.Node]; ok {
 			newWorkers = append(newWorkers, worker)
@@ -433,42 +849,82 @@ func (k *K8sDeployer) invalidateBrokenAttributes(sub subi.SubstrateExt) error {
 	return nil
 }
 
-func (d *K8sDeployer) retainChecksums(workers []interface{}, master interface{}) {
+func (d *K8sDeployer) retainChecksums(workers []interface{}, masters []interface{}) {
 	checksumMap := make(map[string]string)
-	checksumMap[d.Master.Name] = d.Master.FlistChecksum
-	for _, w := range d.Workers {
-		checksumMap[w.Name] = w.FlistChecksum
+	for _, n := range d.nodes() {
+		checksumMap[n.Name] = n.FlistChecksum
 	}
-	typed := master.(map[string]interface{})
-	typed["flist_checksum"] = checksumMap[typed["name"].(string)]
-	for _, w := range workers {
+	for _, w := range append(masters, workers...) {
 		typed := w.(map[string]interface{})
 		typed["flist_checksum"] = checksumMap[typed["name"].(string)]
 	}
 }
 
+// nodes returns the masters followed by the workers
+func (k *K8sDeployer) nodes() []*K8sNodeData {
+	nodes := make([]*K8sNodeData, 0, len(k.Masters)+len(k.Workers))
+	for idx := range k.Masters {
+		nodes = append(nodes, &k.Masters[idx])
+	}
+	for idx := range k.Workers {
+		nodes = append(nodes, &k.Workers[idx])
+	}
+	return nodes
+}
+
 func (k *K8sDeployer) storeState(d *schema.ResourceData, cl *apiClient) {
 	workers := make([]interface{}, 0)
-	for _, w := range k.Workers {
-		workers = append(workers, w.Dictify())
+	poolWorkers := make(map[string][]interface{})
+	for idx := range k.Workers {
+		k.Workers[idx].IP6 = workloads.PrivateIP6(cl.twin_id, k.NetworkName, k.Workers[idx].IP)
+		if pool := k.Workers[idx].Pool; pool != "" {
+			poolWorkers[pool] = append(poolWorkers[pool], k.Workers[idx].dictifyPoolWorker())
+			continue
+		}
+		workers = append(workers, k.Workers[idx].Dictify())
+	}
+	pools := make([]interface{}, 0)
+	for _, pool := range k.NodePools {
+		// the count follows the deployed workers so the missing ones show up in the plan
+		pool.Count = len(poolWorkers[pool.Name])
+		pools = append(pools, pool.Dictify(poolWorkers[pool.Name]))
 	}
 	nodeDeploymentID := make(map[string]interface{})
 	for node, id := range k.NodeDeploymentID {
 		nodeDeploymentID[fmt.Sprintf("%d", node)] = int(id)
 	}
-	log.Printf("master data: %v\n", k.Master)
-	if k.Master == nil {
-		k.Master = &K8sNodeData{}
+	log.Printf("masters data: %v\n", k.Masters)
+	if !k.HA && len(k.Masters) == 0 {
+		k.Masters = []K8sNodeData{{}}
 	}
-	master := k.Master.Dictify()
-	k.retainChecksums(workers, master)
+	masters := make([]interface{}, 0)
+	for idx := range k.Masters {
+		k.Masters[idx].IP6 = workloads.PrivateIP6(cl.twin_id, k.NetworkName, k.Masters[idx].IP)
+		masters = append(masters, k.Masters[idx].Dictify())
+	}
+	k.retainChecksums(workers, masters)
 
-	l := []interface{}{master}
 	k.updateNetworkState(d, cl.state)
-	d.Set("master", l)
+	if k.HA {
+		d.Set("masters", masters)
+	} else {
+		d.Set("master", masters)
+	}
+	d.Set("api_endpoint", k.APIEndpoint)
 	d.Set("workers", workers)
+	d.Set("node_pool", pools)
 	d.Set("token", k.Token)
 	d.Set("ssh_key", k.SSHKey)
+	d.Set("generated_ssh_private_key", k.secrets.GeneratedSSHKey)
+	d.Set("kubeconfig", k.secrets.Kubeconfig)
+	// the keys of the hosts that aren't masters anymore are dropped
+	hosts := make([]string, 0)
+	for _, m := range k.Masters {
+		if host := m.publicHost(); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	d.Set("ssh_host_keys", interfaceMap(k.hostKeys.pinned(hosts)))
 	d.Set("network_name", k.NetworkName)
 	d.Set("node_deployment_id", nodeDeploymentID)
 }
@@ -487,52 +943,67 @@ func (k *K8sDeployer) updateNetworkState(d *schema.ResourceData, state state.Sta
 		network.DeleteDeployment(uint32(nodeID), deploymentIDStr)
 	}
 	// remove old ips
-	network.DeleteDeployment(k.Master.Node, fmt.Sprint(k.NodeDeploymentID[k.Master.Node]))
-	for _, worker := range k.Workers {
-		network.DeleteDeployment(worker.Node, fmt.Sprint(k.NodeDeploymentID[worker.Node]))
+	for _, n := range k.nodes() {
+		network.DeleteDeployment(n.Node, fmt.Sprint(k.NodeDeploymentID[n.Node]))
 	}
 
 	// append new ips
-	masterNodeIPs := network.GetDeploymentIPs(k.Master.Node, fmt.Sprint(k.NodeDeploymentID[k.Master.Node]))
-	masterIP := net.ParseIP(k.Master.IP)
-	if masterIP == nil {
-		log.Printf("couldn't parse master ip")
-	} else {
-		masterNodeIPs = append(masterNodeIPs, masterIP.To4()[3])
-	}
-	network.SetDeploymentIPs(k.Master.Node, fmt.Sprint(k.NodeDeploymentID[k.Master.Node]), masterNodeIPs)
-	for _, worker := range k.Workers {
-		workerNodeIPs := network.GetDeploymentIPs(worker.Node, fmt.Sprint(k.NodeDeploymentID[worker.Node]))
-		workerIP := net.ParseIP(worker.IP)
-		if workerIP == nil {
-			log.Printf("couldn't parse worker ip at node (%d)", worker.Node)
+	for _, n := range k.nodes() {
+		nodeIPs := network.GetDeploymentIPs(n.Node, fmt.Sprint(k.NodeDeploymentID[n.Node]))
+		if net.ParseIP(n.IP) == nil {
+			log.Printf("couldn't parse %s ip at node (%d)", n.Name, n.Node)
 		} else {
-			workerNodeIPs = append(workerNodeIPs, workerIP.To4()[3])
+			nodeIPs = append(nodeIPs, n.IP)
 		}
-		network.SetDeploymentIPs(worker.Node, fmt.Sprint(k.NodeDeploymentID[worker.Node]), workerNodeIPs)
+		network.SetDeploymentIPs(n.Node, fmt.Sprint(k.NodeDeploymentID[n.Node]), nodeIPs)
 	}
 }
 
 func (k *K8sDeployer) assignNodesIPs() error {
-	// TODO: when a k8s node changes its zos node, remove its ip from the used ones. better at the beginning
-	masterNodeRange := k.NodesIPRange[k.Master.Node]
-	if k.Master.IP == "" || !masterNodeRange.Contains(net.ParseIP(k.Master.IP)) {
-		ip, err := k.getK8sFreeIP(masterNodeRange, k.Master.Node)
+	network := k.APIClient.state.GetNetworkState().GetNetwork(k.NetworkName)
+	ipams := make(map[uint32]*state.IPAM)
+	nodeIPAM := func(node uint32) (*state.IPAM, error) {
+		if ipam, ok := ipams[node]; ok {
+			return ipam, nil
+		}
+		ipam, err := network.GetNodeIPAM(node)
 		if err != nil {
-			return errors.Wrap(err, "failed to find free ip for master")
+			return nil, errors.Wrapf(err, "couldn't get node %d ips", node)
 		}
-		k.Master.IP = ip
+		// the ips of the cluster on the node are assigned again, the ones of the removed vms are freed
+		ipam.Release(fmt.Sprint(k.NodeDeploymentID[node]))
+		ipams[node] = ipam
+		return ipam, nil
+	}
+	nodes := k.nodes()
+	kept := make(map[*K8sNodeData]bool)
+	for _, n := range nodes {
+		nodeRange := k.NodesIPRange[n.Node]
+		if n.IP == "" || !nodeRange.Contains(net.ParseIP(n.IP)) {
+			continue
+		}
+		ipam, err := nodeIPAM(n.Node)
+		if err != nil {
+			return err
+		}
+		if err := ipam.Assign(n.IP, fmt.Sprint(k.NodeDeploymentID[n.Node])); err != nil {
+			return errors.Wrapf(err, "couldn't assign the ip of %s", n.Name)
+		}
+		kept[n] = true
 	}
-	for idx, w := range k.Workers {
-		workerNodeRange := k.NodesIPRange[w.Node]
-		if w.IP != "" && workerNodeRange.Contains(net.ParseIP(w.IP)) {
+	for _, n := range nodes {
+		if kept[n] {
 			continue
 		}
-		ip, err := k.getK8sFreeIP(workerNodeRange, w.Node)
+		ipam, err := nodeIPAM(n.Node)
+		if err != nil {
+			return err
+		}
+		ip, err := ipam.Allocate(fmt.Sprint(k.NodeDeploymentID[n.Node]))
 		if err != nil {
-			return errors.Wrap(err, "failed to find free ip for worker")
+			return errors.Wrapf(err, "failed to find free ip for %s", n.Name)
 		}
-		k.Workers[idx].IP = ip
+		n.IP = ip
 	}
 	return nil
 }
@@ -543,10 +1014,12 @@ func (k *K8sDeployer) GenerateVersionlessDeployments(ctx context.Context) (map[u
 	}
 	deployments := make(map[uint32]gridtypes.Deployment)
 	nodeWorkloads := make(map[uint32][]gridtypes.Workload)
-	masterWorkloads := k.Master.GenerateK8sWorkload(k, "")
-	nodeWorkloads[k.Master.Node] = append(nodeWorkloads[k.Master.Node], masterWorkloads...)
+	for idx, m := range k.Masters {
+		masterWorkloads := m.GenerateK8sWorkload(k, k.K3s.env(k.masterEnv(idx), &m, true))
+		nodeWorkloads[m.Node] = append(nodeWorkloads[m.Node], masterWorkloads...)
+	}
 	for _, w := range k.Workers {
-		workerWorkloads := w.GenerateK8sWorkload(k, k.Master.IP)
+		workerWorkloads := w.GenerateK8sWorkload(k, k.K3s.env(k.workerEnv(), &w, false))
 		nodeWorkloads[w.Node] = append(nodeWorkloads[w.Node], workerWorkloads...)
 	}
 
@@ -572,8 +1045,7 @@ func (k *K8sDeployer) GenerateVersionlessDeployments(ctx context.Context) (map[u
 }
 
 func (d *K8sDeployer) validateChecksums() error {
-	nodes := append(d.Workers, *d.Master)
-	for _, vm := range nodes {
+	for _, vm := range d.nodes() {
 		if vm.FlistChecksum == "" {
 			continue
 		}
@@ -596,20 +1068,21 @@ func (d *K8sDeployer) validateChecksums() error {
 func (k *K8sDeployer) ValidateNames(ctx context.Context) error {
 
 	names := make(map[string]bool)
-	names[k.Master.Name] = true
-	for _, w := range k.Workers {
-		if _, ok := names[w.Name]; ok {
-			return fmt.Errorf("k8s workers and master must have unique names: %s occured more than once", w.Name)
+	for _, n := range k.nodes() {
+		if _, ok := names[n.Name]; ok {
+			return fmt.Errorf("k8s workers and masters must have unique names: %s occured more than once", n.Name)
 		}
-		names[w.Name] = true
+		names[n.Name] = true
 	}
 	return nil
 }
 
 func (k *K8sDeployer) ValidateIPranges(ctx context.Context) error {
 
-	if _, ok := k.NodesIPRange[k.Master.Node]; !ok {
-		return fmt.Errorf("the master node %d doesn't exist in the network's ip ranges", k.Master.Node)
+	for _, m := range k.Masters {
+		if _, ok := k.NodesIPRange[m.Node]; !ok {
+			return fmt.Errorf("the node %d of master %s doesn't exist in the network's ip ranges", m.Node, m.Name)
+		}
 	}
 	for _, w := range k.Workers {
 		if _, ok := k.NodesIPRange[w.Node]; !ok {
@@ -642,14 +1115,29 @@ func (k *K8sDeployer) Validate(ctx context.Context, sub subi.SubstrateExt) error
 	if err := k.ValidateNames(ctx); err != nil {
 		return err
 	}
+	if err := k.validateMasters(); err != nil {
+		return err
+	}
+	if k.secrets.SSHPrivateKey != "" {
+		ok, err := authorizesKey(k.SSHKey, k.secrets.SSHPrivateKey)
+		if err != nil {
+			return errors.Wrap(err, "invalid ssh_private_key")
+		}
+		if !ok {
+			return errors.New("ssh_private_key isn't the private key of any of the keys in ssh_key")
+		}
+	}
 	if err := k.ValidateIPranges(ctx); err != nil {
 		return err
 	}
+	if k.RollingUpdate != nil && k.RollingUpdate.WaitForReady && len(k.NodeDeploymentID) != 0 {
+		if _, _, err := k.masterSSH(); err != nil {
+			return errors.Wrap(err, "wait_for_ready needs to reach the cluster")
+		}
+	}
 	nodes := make([]uint32, 0)
-	nodes = append(nodes, k.Master.Node)
-	for _, w := range k.Workers {
-		nodes = append(nodes, w.Node)
-
+	for _, n := range k.nodes() {
+		nodes = append(nodes, n.Node)
 	}
 	return isNodesUp(ctx, sub, nodes, k.ncPool)
 }
@@ -662,7 +1150,7 @@ func (k *K8sDeployer) Deploy(ctx context.Context, sub subi.SubstrateExt, d *sche
 	if err != nil {
 		return errors.Wrap(err, "couldn't generate deployments data")
 	}
-	currentDeployments, err := k.deployer.Deploy(ctx, sub, k.NodeDeploymentID, newDeployments)
+	currentDeployments, err := k.nodesDeployer().Deploy(ctx, sub, k.NodeDeploymentID, newDeployments)
 	if err := k.updateState(ctx, sub, currentDeployments, d, cl); err != nil {
 		log.Printf("error updating state: %s\n", err)
 	}
@@ -698,9 +1186,8 @@ func (k *K8sDeployer) removeUsedIPsFromLocalState(cl *apiClient) {
 	ns := cl.state.GetNetworkState()
 	network := ns.GetNetwork(k.NetworkName)
 
-	network.DeleteDeployment(k.Master.Node, fmt.Sprint(k.NodeDeploymentID[k.Master.Node]))
-	for _, worker := range k.Workers {
-		network.DeleteDeployment(worker.Node, fmt.Sprint(k.NodeDeploymentID[worker.Node]))
+	for _, n := range k.nodes() {
+		network.DeleteDeployment(n.Node, fmt.Sprint(k.NodeDeploymentID[n.Node]))
 	}
 }
 
@@ -742,11 +1229,13 @@ func (k *K8sDeployer) updateState(ctx context.Context, sub subi.SubstrateExt, cu
 			}
 		}
 	}
-	masterIPName := fmt.Sprintf("%sip", k.Master.Name)
-	k.Master.ComputedIP = publicIPs[masterIPName]
-	k.Master.ComputedIP6 = publicIP6s[masterIPName]
-	k.Master.IP = privateIPs[string(k.Master.Name)]
-	k.Master.YggIP = yggIPs[string(k.Master.Name)]
+	for idx, m := range k.Masters {
+		masterIPName := fmt.Sprintf("%sip", m.Name)
+		k.Masters[idx].ComputedIP = publicIPs[masterIPName]
+		k.Masters[idx].ComputedIP6 = publicIP6s[masterIPName]
+		k.Masters[idx].IP = privateIPs[string(m.Name)]
+		k.Masters[idx].YggIP = yggIPs[string(m.Name)]
+	}
 
 	for idx, w := range k.Workers {
 		workerIPName := fmt.Sprintf("%sip", w.Name)
@@ -795,6 +1284,9 @@ func (k *K8sDeployer) updateFromRemote(ctx context.Context, sub subi.SubstrateEx
 					log.Printf("failed to get workload data %s", err)
 				}
 				SSHKey := d.(*zos.ZMachine).Env["SSH_KEY"]
+				if k.secrets.GeneratedPublicKey != "" {
+					SSHKey = strings.TrimSuffix(strings.TrimSuffix(SSHKey, k.secrets.GeneratedPublicKey), "\n")
+				}
 				token := d.(*zos.ZMachine).Env["K3S_TOKEN"]
 				networkName := string(d.(*zos.ZMachine).Network.Interfaces[0].Network)
 				if !keyUpdated && SSHKey != k.SSHKey {
@@ -863,22 +1355,29 @@ func (k *K8sDeployer) updateFromRemote(ctx context.Context, sub subi.SubstrateEx
 			}
 		}
 	}
-	// update master
-	masterNodeID, ok := workloadNodeID[k.Master.Name]
-	if !ok {
-		k.Master = nil
-	} else {
-		masterWorkload := workloadObj[k.Master.Name]
-		masterIP := workloadComputedIP[k.Master.Name]
-		masterIP6 := workloadComputedIP6[k.Master.Name]
-		masterDiskSize := workloadDiskSize[k.Master.Name]
+	// update masters
+	masters := make([]K8sNodeData, 0)
+	for _, m := range k.Masters {
+		masterNodeID, ok := workloadNodeID[m.Name]
+		if !ok {
+			// master doesn't exist in any deployment, skip it
+			continue
+		}
+		delete(workloadNodeID, m.Name)
+		masterWorkload := workloadObj[m.Name]
+		masterIP := workloadComputedIP[m.Name]
+		masterIP6 := workloadComputedIP6[m.Name]
+		masterDiskSize := workloadDiskSize[m.Name]
 
-		m, err := NewK8sNodeDataFromWorkload(masterWorkload, masterNodeID, masterDiskSize, masterIP, masterIP6)
+		master, err := NewK8sNodeDataFromWorkload(masterWorkload, masterNodeID, masterDiskSize, masterIP, masterIP6)
 		if err != nil {
 			return errors.Wrap(err, "failed to get master data from workload")
 		}
-		k.Master = &m
+		// the labels and taints are only passed to k3s in the env vars
+		master.Labels, master.Taints = m.Labels, m.Taints
+		masters = append(masters, master)
 	}
+	k.Masters = masters
 	// update workers
 	workers := make([]K8sNodeData, 0)
 	for _, w := range k.Workers {
@@ -893,17 +1392,16 @@ func (k *K8sDeployer) updateFromRemote(ctx context.Context, sub subi.SubstrateEx
 		workerIP6 := workloadComputedIP6[w.Name]
 
 		workerDiskSize := workloadDiskSize[w.Name]
-		w, err := NewK8sNodeDataFromWorkload(workerWorkload, workerNodeID, workerDiskSize, workerIP, workerIP6)
+		worker, err := NewK8sNodeDataFromWorkload(workerWorkload, workerNodeID, workerDiskSize, workerIP, workerIP6)
 		if err != nil {
 			return errors.Wrap(err, "failed to get worker data from workload")
 		}
-		workers = append(workers, w)
+		worker.Pool = w.Pool
+		worker.Labels, worker.Taints = w.Labels, w.Taints
+		workers = append(workers, worker)
 	}
 	// add missing workers (in case of failed deletions)
 	for name, workerNodeID := range workloadNodeID {
-		if name == k.Master.Name {
-			continue
-		}
 		workerWorkload := workloadObj[name]
 		workerIP := workloadComputedIP[name]
 		workerIP6 := workloadComputedIP6[name]
@@ -923,7 +1421,391 @@ func (k *K8sDeployer) updateFromRemote(ctx context.Context, sub subi.SubstrateEx
 	return nil
 }
 
-func (k *K8sNodeData) GenerateK8sWorkload(deployer *K8sDeployer, masterIP string) []gridtypes.Workload {
+// sshKeys returns the authorized keys of the cluster nodes, ssh_key and the public key of the generated key
+func (k *K8sDeployer) sshKeys() string {
+	if k.secrets.GeneratedPublicKey == "" {
+		return k.SSHKey
+	}
+	if k.SSHKey == "" {
+		return k.secrets.GeneratedPublicKey
+	}
+	return fmt.Sprintf("%s\n%s", k.SSHKey, k.secrets.GeneratedPublicKey)
+}
+
+// publicHost returns the ip the node is reachable through from outside the network,
+// its public ipv4, public ipv6 or planetary ip
+func (k *K8sNodeData) publicHost() string {
+	for _, ip := range []string{k.ComputedIP, k.ComputedIP6, k.YggIP} {
+		if parsed, _, err := net.ParseCIDR(ip); err == nil {
+			return parsed.String()
+		}
+		if parsed := net.ParseIP(ip); parsed != nil {
+			return parsed.String()
+		}
+	}
+	return ""
+}
+
+// sshPrivateKey returns the private key the provider connects to the cluster nodes with
+func (k *K8sDeployer) sshPrivateKey() (string, error) {
+	if k.secrets.SSHPrivateKey != "" {
+		return k.secrets.SSHPrivateKey, nil
+	}
+	if k.secrets.GeneratedSSHKey != "" {
+		return k.secrets.GeneratedSSHKey, nil
+	}
+	return "", errors.New("no private key to access the masters, ssh_private_key must be set for the clusters created without a generated key")
+}
+
+// updateKubeconfig fetches the kubeconfig from the first master reachable from outside the network
+func (k *K8sDeployer) updateKubeconfig(ctx context.Context) error {
+	key, err := k.sshPrivateKey()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, kubeconfigTimeout)
+	defer cancel()
+	err = errors.New("the masters don't have a public or planetary ip to fetch the kubeconfig through")
+	for _, m := range k.Masters {
+		host := m.publicHost()
+		if host == "" {
+			continue
+		}
+		var kubeconfig string
+		kubeconfig, err = readKubeconfig(ctx, net.JoinHostPort(host, "22"), key, k.hostKeys)
+		if err == nil {
+			k.secrets.Kubeconfig = kubeconfigWithServer(kubeconfig, k.kubeconfigServer(host))
+			return nil
+		}
+	}
+	return err
+}
+
+// kubeconfigServer returns the server of the kubeconfig fetched from the master at host, the api endpoint
+// is used if it's set so the kubeconfig keeps working when that master goes down
+func (k *K8sDeployer) kubeconfigServer(host string) string {
+	if k.APIEndpoint != "" {
+		return k.APIEndpoint
+	}
+	return host
+}
+
+// drainDiags drains the removed workers, failing to do so is only a warning and the workers are removed anyway
+func (k *K8sDeployer) drainDiags(names []string) diag.Diagnostics {
+	if len(names) == 0 {
+		return nil
+	}
+	if err := k.drainWorkers(names); err != nil {
+		return diag.Diagnostics{{
+			Severity: diag.Warning,
+			Summary:  "Couldn't drain the removed workers",
+			Detail:   err.Error(),
+		}}
+	}
+	return nil
+}
+
+// kubeconfigDiags fetches the kubeconfig, failing to do so is only a warning since the cluster is deployed
+func (k *K8sDeployer) kubeconfigDiags(ctx context.Context) diag.Diagnostics {
+	if err := k.updateKubeconfig(ctx); err != nil {
+		return diag.Diagnostics{{
+			Severity: diag.Warning,
+			Summary:  "Couldn't fetch the kubeconfig of the cluster",
+			Detail:   err.Error(),
+		}}
+	}
+	return nil
+}
+
+// drainWorkers drains the workers and deletes them from the cluster through the first master
+// reachable from outside the network, before their vms are removed
+func (k *K8sDeployer) drainWorkers(names []string) error {
+	addr, config, err := k.masterSSH()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		cmd := fmt.Sprintf(
+			"k3s kubectl drain %[1]s --ignore-daemonsets --delete-emptydir-data --force --timeout=%[2]ds && k3s kubectl delete node %[1]s",
+			name, int(drainTimeout.Seconds()),
+		)
+		if _, err := runSSH(addr, config, cmd); err != nil {
+			return errors.Wrapf(err, "couldn't drain worker %s", name)
+		}
+	}
+	return nil
+}
+
+// masterSSH returns the ssh address and config of the first master reachable from outside the network
+func (k *K8sDeployer) masterSSH() (string, *ssh.ClientConfig, error) {
+	addrs, config, err := k.mastersSSH(nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return addrs[0], config, nil
+}
+
+// mastersSSH returns the ssh addresses of the masters reachable from outside the network and their config,
+// the masters named in last come after the others
+func (k *K8sDeployer) mastersSSH(last []string) ([]string, *ssh.ClientConfig, error) {
+	key, err := k.sshPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := sshClientConfig(key, k.hostKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs := make([]string, 0)
+	lastAddrs := make([]string, 0)
+	for _, m := range k.Masters {
+		host := m.publicHost()
+		if host == "" {
+			continue
+		}
+		if isInStr(last, m.Name) {
+			lastAddrs = append(lastAddrs, net.JoinHostPort(host, "22"))
+		} else {
+			addrs = append(addrs, net.JoinHostPort(host, "22"))
+		}
+	}
+	addrs = append(addrs, lastAddrs...)
+	if len(addrs) == 0 {
+		return nil, nil, errors.New("the masters don't have a public or planetary ip to reach the cluster through")
+	}
+	return addrs, config, nil
+}
+
+// nodesDeployer returns the deployer of the cluster deployments, it updates them a few nodes at a time with rolling_update
+func (k *K8sDeployer) nodesDeployer() deployer.Deployer {
+	if k.RollingUpdate == nil {
+		return k.deployer
+	}
+	rollingUpdate := deployer.RollingUpdate{MaxUnavailable: k.RollingUpdate.MaxUnavailable}
+	if k.RollingUpdate.WaitForReady {
+		rollingUpdate.Ready = k.nodesReady
+	}
+	return deployer.NewRollingDeployer(k.APIClient.identity, k.APIClient.twin_id, k.APIClient.grid_client, k.ncPool, true, nil, k.deploymentData, rollingUpdate)
+}
+
+// nodesReady waits for the kubernetes nodes of the vms of the updated deployment to be Ready again.
+// It goes through the masters that aren't in the deployment first, and retries through the others
+// since the updated master can be down for a while
+func (k *K8sDeployer) nodesReady(ctx context.Context, node uint32, dl gridtypes.Deployment) error {
+	vms := make([]string, 0)
+	names := make([]string, 0)
+	for _, wl := range dl.Workloads {
+		if wl.Type == zos.ZMachineType {
+			vms = append(vms, string(wl.Name))
+			names = append(names, fmt.Sprintf("node/%s", wl.Name))
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	addrs, config, err := k.mastersSSH(vms)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rejoinTimeout)
+	defer cancel()
+	cmd := fmt.Sprintf("k3s kubectl wait --for=condition=Ready --timeout=%ds %s", int(kubeconfigRetryInterval.Seconds()), strings.Join(names, " "))
+	for {
+		for _, addr := range addrs {
+			if _, err = runSSH(addr, config, cmd); err == nil {
+				return nil
+			}
+			if err := k.hostKeys.mismatch(addr); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(err, "%s didn't rejoin the cluster", strings.Join(names, ", "))
+		case <-time.After(kubeconfigRetryInterval):
+		}
+	}
+}
+
+// k3sDiags checks that the nodes of the cluster have the settings passed to the flist in env vars, so a flist
+// that doesn't read them fails the apply instead of silently setting up another cluster. Not being able to
+// reach the cluster is only a warning like for the kubeconfig
+func (k *K8sDeployer) k3sDiags(ctx context.Context) diag.Diagnostics {
+	if !k.checksK3s() {
+		return nil
+	}
+	mismatches, err := k.checkK3s(ctx)
+	var diags diag.Diagnostics
+	if len(mismatches) != 0 {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  "The cluster nodes don't have the k3s settings, the flist doesn't support them",
+			Detail:   strings.Join(mismatches, "\n"),
+		})
+	}
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "Couldn't check the k3s settings of the cluster",
+			Detail:   err.Error(),
+		})
+	}
+	return diags
+}
+
+// checksK3s tells if the cluster has settings that only take effect if the flist reads their env vars
+func (k *K8sDeployer) checksK3s() bool {
+	if k.HA || k3sVersionRegex.MatchString(k.K3s.Version) || k.K3s.FlannelBackend != "" || len(k.K3s.Disable) != 0 {
+		return true
+	}
+	for _, node := range append(append([]K8sNodeData{}, k.Masters...), k.Workers...) {
+		if len(node.Labels) != 0 || len(node.Taints) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkK3s reads the cluster from the masters until all the vms joined it, and returns the settings its nodes don't have
+func (k *K8sDeployer) checkK3s(ctx context.Context) ([]string, error) {
+	addrs, config, err := k.mastersSSH(nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rejoinTimeout)
+	defer cancel()
+	var mismatches []string
+	for {
+		for _, addr := range addrs {
+			var cluster k3sCluster
+			cluster, err = readK3sCluster(addr, config)
+			if err == nil {
+				var missing []string
+				missing, mismatches = k.k3sMismatches(cluster)
+				if len(missing) == 0 {
+					return mismatches, nil
+				}
+				err = fmt.Errorf("%s didn't join the cluster", strings.Join(missing, ", "))
+				break
+			}
+			if err := k.hostKeys.mismatch(addr); err != nil {
+				return nil, err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return mismatches, err
+		case <-time.After(kubeconfigRetryInterval):
+		}
+	}
+}
+
+// readK3sCluster reads the state of the cluster from the master at addr
+func readK3sCluster(addr string, config *ssh.ClientConfig) (k3sCluster, error) {
+	out, err := runSSH(addr, config, "k3s kubectl get nodes -o json")
+	if err != nil {
+		return k3sCluster{}, errors.Wrapf(err, "couldn't get the kubernetes nodes from %s", addr)
+	}
+	nodes, err := parseK3sNodes(out)
+	if err != nil {
+		return k3sCluster{}, err
+	}
+	out, err = runSSH(addr, config, "k3s kubectl -n kube-system get deployments -o jsonpath={.items[*].metadata.name}")
+	if err != nil {
+		return k3sCluster{}, errors.Wrapf(err, "couldn't get the kube-system deployments from %s", addr)
+	}
+	return k3sCluster{Nodes: nodes, Deployments: strings.Fields(out)}, nil
+}
+
+// k3sMismatches returns the vms that didn't join the cluster yet and the settings the joined nodes don't have
+func (k *K8sDeployer) k3sMismatches(cluster k3sCluster) (missing []string, mismatches []string) {
+	for idx, m := range k.Masters {
+		node, ok := cluster.Nodes[m.Name]
+		if !ok {
+			missing = append(missing, m.Name)
+			continue
+		}
+		mismatches = append(mismatches, k.K3s.mismatches(&k.Masters[idx], &node, true)...)
+		if !k.HA {
+			continue
+		}
+		env := "K3S_MASTER"
+		if idx == 0 {
+			env = "K3S_CLUSTER_INIT"
+		}
+		if node.Metadata.Labels[k3sControlPlaneRole] != "true" {
+			mismatches = append(mismatches, fmt.Sprintf("master %s isn't a server, %s was ignored", m.Name, env))
+		} else if node.Metadata.Labels[k3sEtcdRole] != "true" {
+			mismatches = append(mismatches, fmt.Sprintf("master %s doesn't run the embedded etcd, %s was ignored", m.Name, env))
+		}
+	}
+	for idx, w := range k.Workers {
+		node, ok := cluster.Nodes[w.Name]
+		if !ok {
+			missing = append(missing, w.Name)
+			continue
+		}
+		mismatches = append(mismatches, k.K3s.mismatches(&k.Workers[idx], &node, false)...)
+	}
+	for _, component := range k.K3s.enabled(cluster.Deployments) {
+		mismatches = append(mismatches, fmt.Sprintf("%s is deployed although it's disabled, K3S_EXTRA_ARGS was ignored", component))
+	}
+	return missing, mismatches
+}
+
+// masterEnv returns the env vars of the idx-th master joining the cluster. With several masters the first one
+// initializes the embedded etcd and the others join it as servers, the single master is set up as before
+func (k *K8sDeployer) masterEnv(idx int) map[string]string {
+	if !k.HA {
+		return map[string]string{"K3S_URL": ""}
+	}
+	if idx == 0 {
+		return map[string]string{"K3S_URL": "", "K3S_CLUSTER_INIT": "true"}
+	}
+	return map[string]string{"K3S_URL": k3sURL(k.Masters[0].IP), "K3S_MASTER": "true"}
+}
+
+// workerEnv returns the env vars of the workers joining the cluster through the api endpoint or the first master
+func (k *K8sDeployer) workerEnv() map[string]string {
+	if k.APIEndpoint != "" {
+		return map[string]string{"K3S_URL": k3sURL(k.APIEndpoint)}
+	}
+	if len(k.Masters) == 0 || k.Masters[0].IP == "" {
+		return map[string]string{"K3S_URL": ""}
+	}
+	return map[string]string{"K3S_URL": k3sURL(k.Masters[0].IP)}
+}
+
+// k3sURL returns the url of the k3s server at host, on port 6443 if host doesn't have one
+func k3sURL(host string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "6443")
+	}
+	return fmt.Sprintf("https://%s", host)
+}
+
+// validateMasters checks that the masters of a highly available cluster can keep the etcd quorum
+func (k *K8sDeployer) validateMasters() error {
+	if !k.HA {
+		return nil
+	}
+	if len(k.Masters) != 3 && len(k.Masters) != 5 {
+		return fmt.Errorf("a highly available cluster needs 3 or 5 masters, found %d", len(k.Masters))
+	}
+	if k.APIEndpoint == "" {
+		return errors.New("api_endpoint is required with masters so the workers don't depend on a single master")
+	}
+	nodes := make(map[uint32]string)
+	for _, m := range k.Masters {
+		if other, ok := nodes[m.Node]; ok {
+			return fmt.Errorf("masters %s and %s are on the same node %d, losing it would lose the etcd quorum", other, m.Name, m.Node)
+		}
+		nodes[m.Node] = m.Name
+	}
+	return nil
+}
+
+func (k *K8sNodeData) GenerateK8sWorkload(deployer *K8sDeployer, joinEnv map[string]string) []gridtypes.Workload {
 	diskName := fmt.Sprintf("%sdisk", k.Name)
 	workloads := make([]gridtypes.Workload, 0)
 	diskWorkload := gridtypes.Workload{
@@ -942,15 +1824,14 @@ func (k *K8sNodeData) GenerateK8sWorkload(deployer *K8sDeployer, masterIP string
 		workloads = append(workloads, constructPublicIPWorkload(publicIPName, k.PublicIP, k.PublicIP6))
 	}
 	envVars := map[string]string{
-		"SSH_KEY":           deployer.SSHKey,
+		"SSH_KEY":           deployer.sshKeys(),
 		"K3S_TOKEN":         deployer.Token,
 		"K3S_DATA_DIR":      "/mydisk",
 		"K3S_FLANNEL_IFACE": "eth0",
 		"K3S_NODE_NAME":     k.Name,
-		"K3S_URL":           "",
 	}
-	if masterIP != "" {
-		envVars["K3S_URL"] = fmt.Sprintf("https://%s:6443", masterIP)
+	for key, value := range joinEnv {
+		envVars[key] = value
 	}
 	workload := gridtypes.Workload{
 		Version: 0,
@@ -984,21 +1865,105 @@ func (k *K8sNodeData) GenerateK8sWorkload(deployer *K8sDeployer, masterIP string
 	return workloads
 }
 
-func (k *K8sDeployer) getK8sFreeIP(ipRange gridtypes.IPNet, nodeID uint32) (string, error) {
-	for i := byte(2); i <= byte(255); i++ {
-		if !isInByte(k.NodeUsedIPs[nodeID], i) {
-			k.NodeUsedIPs[nodeID] = append(k.NodeUsedIPs[nodeID], i)
-			ip := ipRange.IP.To4()
-			ip[3] = i
-			return ip.String(), nil
+// k8sNodes returns the nodes of the masters and the workers
+func k8sNodes(d *schema.ResourceData) []uint32 {
+	nodes := make([]uint32, 0)
+	for _, m := range d.Get("master").([]interface{}) {
+		nodes = append(nodes, uint32(m.(map[string]interface{})["node"].(int)))
+	}
+	for _, m := range d.Get("masters").([]interface{}) {
+		nodes = append(nodes, uint32(m.(map[string]interface{})["node"].(int)))
+	}
+	for _, w := range d.Get("workers").([]interface{}) {
+		nodes = append(nodes, uint32(w.(map[string]interface{})["node"].(int)))
+	}
+	for _, p := range d.Get("node_pool").([]interface{}) {
+		for _, w := range p.(map[string]interface{})["workers"].([]interface{}) {
+			nodes = append(nodes, uint32(w.(map[string]interface{})["node"].(int)))
+		}
+	}
+	return nodes
+}
+
+// scheduleNodePools names the workers of the node pools and picks the nodes of the new ones with the scheduler,
+// the other workers keep their nodes. It returns the names of the removed workers, the newest first
+func scheduleNodePools(d *schema.ResourceData, apiClient *apiClient) ([]string, error) {
+	oldPools, _ := d.GetChange("node_pool")
+	assigned := make(map[string]interface{})
+	oldNames := make([]string, 0)
+	for _, p := range oldPools.([]interface{}) {
+		workers := p.(map[string]interface{})["workers"].([]interface{})
+		for idx := len(workers) - 1; idx >= 0; idx-- {
+			name := workers[idx].(map[string]interface{})["name"].(string)
+			assigned[name] = workers[idx]
+			oldNames = append(oldNames, name)
+		}
+	}
+	sched := scheduler.NewScheduler(apiClient.grid_client, uint64(apiClient.twin_id))
+	pools := d.Get("node_pool").([]interface{})
+	kept := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, p := range pools {
+		pool := NewK8sNodePool(p.(map[string]interface{}))
+		if names[pool.Name] {
+			return nil, fmt.Errorf("node pool %s is defined more than once", pool.Name)
+		}
+		names[pool.Name] = true
+		workers := make([]interface{}, 0)
+		for idx := 0; idx < pool.Count; idx++ {
+			name := fmt.Sprintf("%s%d", pool.Name, idx)
+			kept[name] = true
+			if worker, ok := assigned[name]; ok {
+				workers = append(workers, worker)
+				continue
+			}
+			r := scheduler.Request{
+				Name: name,
+				Farm: pool.Farm,
+				// the public ip is reserved from the farm of the node
+				HasIPv4: pool.IPv4 || pool.PublicIP,
+				Cap: scheduler.Capacity{
+					Cru:    uint64(pool.Cpu),
+					Memory: uint64(pool.Memory) * uint64(gridtypes.Megabyte),
+					Sru:    uint64(pool.DiskSize) * uint64(gridtypes.Gigabyte),
+				},
+			}
+			node, err := sched.Schedule(&r)
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldn't find a node for worker %s of node pool %s", name, pool.Name)
+			}
+			workers = append(workers, map[string]interface{}{"name": name, "node": int(node)})
+		}
+		p.(map[string]interface{})["workers"] = workers
+	}
+	if err := d.Set("node_pool", pools); err != nil {
+		return nil, errors.Wrap(err, "couldn't set the workers of the node pools")
+	}
+	removed := make([]string, 0)
+	for _, name := range oldNames {
+		if !kept[name] {
+			removed = append(removed, name)
 		}
 	}
-	return "", errors.New("all ips are used")
+	return removed, nil
 }
 
 func resourceK8sCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	apiClient := meta.(*apiClient)
+	if _, err := scheduleNodePools(d, apiClient); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := extendNetwork(ctx, apiClient.substrateConn, apiClient, d.Get("network_name").(string), k8sNodes(d)); err != nil {
+		return diag.FromErr(err)
+	}
+	if d.Get("ssh_private_key").(string) == "" {
+		key, err := generateSSHKey()
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		d.Set("generated_ssh_private_key", key)
+	}
 	deployer, err := NewK8sDeployer(d, apiClient)
 	if err != nil {
 		return diag.FromErr(errors.Wrap(err, "couldn't load deployer data"))
@@ -1016,6 +1981,9 @@ func resourceK8sCreate(ctx context.Context, d *schema.ResourceData, meta interfa
 		} else {
 			return diag.FromErr(err)
 		}
+	} else {
+		diags = append(diags, deployer.kubeconfigDiags(ctx)...)
+		diags = append(diags, deployer.k3sDiags(ctx)...)
 	}
 	deployer.storeState(d, apiClient)
 	d.SetId(uuid.New().String())
@@ -1025,6 +1993,13 @@ func resourceK8sCreate(ctx context.Context, d *schema.ResourceData, meta interfa
 func resourceK8sUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	apiClient := meta.(*apiClient)
+	removed, err := scheduleNodePools(d, apiClient)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if err := extendNetwork(ctx, apiClient.substrateConn, apiClient, d.Get("network_name").(string), k8sNodes(d)); err != nil {
+		return diag.FromErr(err)
+	}
 	deployer, err := NewK8sDeployer(d, apiClient)
 	if err != nil {
 		return diag.FromErr(errors.Wrap(err, "couldn't load deployer data"))
@@ -1037,10 +2012,18 @@ func resourceK8sUpdate(ctx context.Context, d *schema.ResourceData, meta interfa
 	if err := deployer.invalidateBrokenAttributes(apiClient.substrateConn); err != nil {
 		return diag.FromErr(errors.Wrap(err, "couldn't invalidate broken attributes"))
 	}
+	diags = append(diags, deployer.drainDiags(removed)...)
 
 	err = deployer.Deploy(ctx, apiClient.substrateConn, d, apiClient)
 	if err != nil {
-		diags = diag.FromErr(err)
+		diags = append(diags, diag.FromErr(err)...)
+	} else {
+		if deployer.secrets.Kubeconfig == "" || d.HasChanges("master", "masters", "ssh_private_key") {
+			diags = append(diags, deployer.kubeconfigDiags(ctx)...)
+		}
+		if d.HasChanges(k3sCheckedAttributes...) {
+			diags = append(diags, deployer.k3sDiags(ctx)...)
+		}
 	}
 	deployer.storeState(d, apiClient)
 	return diags
@@ -1095,3 +2078,79 @@ func resourceK8sDelete(ctx context.Context, d *schema.ResourceData, meta interfa
 	}
 	return diags
 }
+
+// resourceK8sImport adopts a cluster using an id listing the contracts of its deployments
+// as <contract_id>,<contract_id>... The master is the vm that isn't joining another server, the masters
+// joining it make a highly available cluster
+func resourceK8sImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	apiClient := meta.(*apiClient)
+	contracts, err := parseContractList(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	nodeDeploymentID := make(map[string]interface{})
+	var master map[string]interface{}
+	joining := make([]interface{}, 0)
+	workers := make([]interface{}, 0)
+	apiEndpoint := ""
+	for _, contractID := range contracts {
+		imported, err := importDeployment(ctx, apiClient, contractID)
+		if err != nil {
+			return nil, err
+		}
+		nodeDeploymentID[fmt.Sprint(imported.Node)] = int(contractID)
+		setImportedDeploymentData(d, imported.Data)
+		for _, wl := range imported.Deployment.Workloads {
+			if wl.Type != zos.ZMachineType {
+				continue
+			}
+			data, err := wl.WorkloadData()
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldn't parse vm %s", wl.Name)
+			}
+			vm := data.(*zos.ZMachine)
+			if len(vm.Network.Interfaces) == 0 {
+				continue
+			}
+			networkName := string(vm.Network.Interfaces[0].Network)
+			if err := importNodeSubnet(ctx, apiClient, networkName, imported.Node, vm.Network.Interfaces[0].IP); err != nil {
+				return nil, err
+			}
+			d.Set("network_name", networkName)
+			d.Set("token", vm.Env["K3S_TOKEN"])
+			d.Set("ssh_key", vm.Env["SSH_KEY"])
+			node := map[string]interface{}{
+				"name": string(wl.Name),
+				"node": int(imported.Node),
+			}
+			switch {
+			case vm.Env["K3S_URL"] == "":
+				if master != nil {
+					return nil, fmt.Errorf("found more than one master: %s and %s", master["name"], wl.Name)
+				}
+				master = node
+			case vm.Env["K3S_MASTER"] == "true":
+				joining = append(joining, node)
+			default:
+				workers = append(workers, node)
+				apiEndpoint = strings.TrimPrefix(vm.Env["K3S_URL"], "https://")
+			}
+		}
+	}
+	if master == nil {
+		return nil, errors.New("couldn't find the master of the cluster")
+	}
+	d.SetId(uuid.New().String())
+	if len(joining) != 0 {
+		if host, port, err := net.SplitHostPort(apiEndpoint); err == nil && port == "6443" {
+			apiEndpoint = host
+		}
+		d.Set("masters", append([]interface{}{master}, joining...))
+		d.Set("api_endpoint", apiEndpoint)
+	} else {
+		d.Set("master", []interface{}{master})
+	}
+	d.Set("workers", workers)
+	d.Set("node_deployment_id", nodeDeploymentID)
+	return readImported(ctx, d, meta, resourceK8sRead)
+}
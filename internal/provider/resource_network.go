@@ -4,8 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"net"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -15,6 +20,7 @@ import (
 	"github.com/threefoldtech/terraform-provider-grid/pkg/deployer"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/workloads"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
 	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -32,6 +38,12 @@ func resourceNetwork() *schema.Resource {
 		UpdateContext: resourceNetworkUpdate,
 		DeleteContext: resourceNetworkDelete,
 
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceNetworkImport,
+		},
+
+		CustomizeDiff: resourceNetworkCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -57,22 +69,95 @@ func resourceNetwork() *schema.Resource {
 				},
 				Description: "List of nodes to add to the network",
 			},
+			"auto_extend": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Extend the network to the nodes of the deployments using it, so they don't have to be listed in nodes",
+			},
+			"extended_nodes": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Description: "Nodes added to the network by the deployments using it (with auto_extend)",
+			},
 			"ip_range": {
 				Type:        schema.TypeString,
 				Required:    true,
 				Description: "Network ip range",
 			},
+			"node_subnet_prefix": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     24,
+				Description: "Prefix length of the subnets given to the nodes out of the network ip range (18 to 28), the nodes already in the network keep their subnets when it's changed",
+			},
+			"reserved_ips": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "IPs and CIDRs of the network ip range that are never assigned to the workloads",
+			},
 			"add_wg_access": {
 				Type:        schema.TypeBool,
 				Optional:    true,
 				Default:     false,
 				Description: "Whether to add a public node to network and use it to generate a wg config",
 			},
+			"ipv6": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Route the private IPv6 subnets of the nodes through the wireguard mesh and add them to the access config",
+			},
+			"ipv6_range": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "ULA IPv6 range of the network, zos derives it from the network name",
+			},
 			"access_wg_config": {
 				Type:        schema.TypeString,
 				Computed:    true,
 				Description: "WG config for access",
 			},
+			"access_peers": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Wireguard peers given access to the network through the public node, each with its own key and ip",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Peer name, the peers keep their keys and ips by name when the others are added or removed",
+						},
+						"public_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Wireguard public key of the peer, a key pair is generated if it's not set",
+						},
+						"private_key": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Sensitive:   true,
+							Description: "Generated private key of the peer, empty if the public key is set",
+						},
+						"subnet": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Subnet of the network ip range assigned to the peer",
+						},
+						"wg_config": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Sensitive:   true,
+							Description: "WG config of the peer, without the private key if the public key is set",
+						},
+					},
+				},
+			},
 			"external_ip": {
 				Type:        schema.TypeString,
 				Computed:    true,
@@ -83,11 +168,30 @@ func resourceNetwork() *schema.Resource {
 				Computed:    true,
 				Description: "Access point private key (the one to use in the local wireguard config to access the network)",
 			},
+			"external_pk": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Access point public key, when set no private key is generated and access_wg_config is a template without the PrivateKey line",
+			},
 			"public_node_id": {
 				Type:        schema.TypeInt,
 				Computed:    true,
 				Description: "Public node id (in case it's added). Used for wireguard access and supporting hidden nodes.",
 			},
+			"access_nodes": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Description: "Public nodes giving access to the network in order of preference, they all connect the access peers and each hidden node connects to one of them. The first one is the public node, the provider picks a single one if it's empty",
+			},
+			"hidden_nodes_access": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Description: "Access node each hidden node connects to, it only changes when the access node is removed from access_nodes or another one is added",
+			},
 			"nodes_ip_range": {
 				Type:        schema.TypeMap,
 				Computed:    true,
@@ -95,29 +199,64 @@ func resourceNetwork() *schema.Resource {
 				Elem:        &schema.Schema{Type: schema.TypeString},
 				Description: "Computed values of nodes' ip ranges after deployment",
 			},
+			"nodes_ipv6_range": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "IPv6 /64 of each node, derived from its ip range",
+			},
 			"node_deployment_id": {
 				Type:        schema.TypeMap,
 				Computed:    true,
 				Elem:        &schema.Schema{Type: schema.TypeInt},
 				Description: "Mapping from each node to its deployment id",
 			},
+			"topology_json": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "JSON description of the wireguard mesh: the subnet, port and endpoint of each node, its peers with their allowed ips and the ranges it routes through other nodes",
+			},
+			"topology_dot": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The network topology as a graphviz graph, can be rendered with `dot -Tsvg`",
+			},
 		},
 	}
 }
 
 type NetworkDeployer struct {
-	Name        string
-	Description string
-	Nodes       []uint32
-	IPRange     gridtypes.IPNet
-	AddWGAccess bool
-
-	AccessWGConfig   string
-	ExternalIP       *gridtypes.IPNet
-	ExternalSK       wgtypes.Key
-	PublicNodeID     uint32
+	Name         string
+	Description  string
+	SolutionType string
+	Nodes        []uint32
+	IPRange      gridtypes.IPNet
+	// NodeSubnetPrefix is the prefix length of the subnets assigned to new nodes
+	NodeSubnetPrefix int
+	ReservedIPs      []string
+	AddWGAccess      bool
+	IPv6             bool
+	AutoExtend       bool
+	// ExtendedNodes are the nodes of Nodes added by the deployments using the network
+	ExtendedNodes []uint32
+
+	AccessWGConfig string
+	AccessPeers    []AccessPeer
+	ExternalIP     *gridtypes.IPNet
+	ExternalSK     wgtypes.Key
+	// ExternalPK is the public key of the access point provided by the user or read from the local state,
+	// ExternalSK isn't used when it's set
+	ExternalPK   string
+	PublicNodeID uint32
+	// AccessNodes are the public nodes designated by the user, PublicNodeID is the first one
+	AccessNodes []uint32
+	// HiddenAccess is the access node of each hidden node
+	HiddenAccess     map[uint32]uint32
 	NodeDeploymentID map[uint32]uint64
 	NodesIPRange     map[uint32]gridtypes.IPNet
+	// TopologyJSON and TopologyDOT describe the last generated deployments
+	TopologyJSON string
+	TopologyDOT  string
 
 	WGPort    map[uint32]int
 	Keys      map[uint32]wgtypes.Key
@@ -126,6 +265,74 @@ type NetworkDeployer struct {
 	deployer  deployer.Deployer
 }
 
+// AccessPeer is a wireguard peer with its own key and subnet given access to the network through the public node
+type AccessPeer struct {
+	Name string
+	// PublicKey is the key provided by the user, PrivateKey is generated when it's not set
+	PublicKey  string
+	PrivateKey string
+	Subnet     *gridtypes.IPNet
+	WGConfig   string
+}
+
+func (p *AccessPeer) publicKey() string {
+	if p.PrivateKey == "" {
+		return p.PublicKey
+	}
+	key, err := wgtypes.ParseKey(p.PrivateKey)
+	if err != nil {
+		return ""
+	}
+	return key.PublicKey().String()
+}
+
+// getAccessPeers loads the access peers of the config with the keys and subnets stored for their names,
+// so removing a peer doesn't change the others
+func getAccessPeers(d *schema.ResourceData) ([]AccessPeer, error) {
+	old, _ := d.GetChange("access_peers")
+	stored := make(map[string]map[string]interface{})
+	for _, p := range old.([]interface{}) {
+		m := p.(map[string]interface{})
+		stored[m["name"].(string)] = m
+	}
+	peers := make([]AccessPeer, 0)
+	for _, p := range d.Get("access_peers").([]interface{}) {
+		m := p.(map[string]interface{})
+		peer := AccessPeer{
+			Name:      m["name"].(string),
+			PublicKey: m["public_key"].(string),
+		}
+		if s, ok := stored[peer.Name]; ok {
+			if subnet := s["subnet"].(string); subnet != "" {
+				ip, err := gridtypes.ParseIPNet(subnet)
+				if err != nil {
+					return nil, errors.Wrapf(err, "couldn't parse subnet of access peer %s", peer.Name)
+				}
+				peer.Subnet = &ip
+			}
+			if peer.PublicKey == "" {
+				peer.PrivateKey = s["private_key"].(string)
+			}
+			peer.WGConfig = s["wg_config"].(string)
+		}
+		if peer.PublicKey != "" {
+			if _, err := wgtypes.ParseKey(peer.PublicKey); err != nil {
+				return nil, errors.Wrapf(err, "invalid public key of access peer %s", peer.Name)
+			}
+		} else if peer.PrivateKey == "" {
+			key, err := wgtypes.GeneratePrivateKey()
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldn't generate key of access peer %s", peer.Name)
+			}
+			peer.PrivateKey = key.String()
+		} else if _, err := wgtypes.ParseKey(peer.PrivateKey); err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse private key of access peer %s", peer.Name)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
 func NewNetworkDeployer(ctx context.Context, d *schema.ResourceData, apiClient *apiClient) (NetworkDeployer, error) {
 	var err error
 	nodesIf := d.Get("nodes").([]interface{})
@@ -144,6 +351,46 @@ func NewNetworkDeployer(ctx context.Context, d *schema.ResourceData, apiClient *
 		deploymentID := uint64(id.(int))
 		nodeDeploymentID[uint32(nodeInt)] = deploymentID
 	}
+	autoExtend := d.Get("auto_extend").(bool)
+	extendedNodes := make([]uint32, 0)
+	if autoExtend {
+		for _, n := range d.Get("extended_nodes").([]interface{}) {
+			extendedNodes = append(extendedNodes, uint32(n.(int)))
+		}
+		// pick up the nodes added by the deployments since the network was stored
+		config := apiClient.state.GetNetworkState().GetNetwork(d.Get("name").(string)).GetConfig()
+		if config != nil {
+			for _, node := range config.ExtendedNodes {
+				if !isInUint32(extendedNodes, node) {
+					extendedNodes = append(extendedNodes, node)
+				}
+			}
+			for node, id := range config.NodeDeploymentID {
+				if _, ok := nodeDeploymentID[node]; !ok {
+					nodeDeploymentID[node] = id
+				}
+			}
+		}
+	}
+	userNodes := nodes
+	nodes = append([]uint32{}, userNodes...)
+	for _, node := range extendedNodes {
+		if !isInUint32(userNodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	accessNodes := make([]uint32, 0)
+	for _, n := range d.Get("access_nodes").([]interface{}) {
+		accessNodes = append(accessNodes, uint32(n.(int)))
+	}
+	hiddenAccess := make(map[uint32]uint32)
+	for node, access := range d.Get("hidden_nodes_access").(map[string]interface{}) {
+		nodeInt, err := strconv.ParseUint(node, 10, 32)
+		if err != nil {
+			return NetworkDeployer{}, errors.Wrap(err, "couldn't parse node id")
+		}
+		hiddenAccess[uint32(nodeInt)] = uint32(access.(int))
+	}
 	nodesIPRange := make(map[uint32]gridtypes.IPNet)
 	nodesIPRangeIf := d.Get("nodes_ip_range").(map[string]interface{})
 	for node, r := range nodesIPRangeIf {
@@ -170,7 +417,10 @@ func NewNetworkDeployer(ctx context.Context, d *schema.ResourceData, apiClient *
 		externalIP = &ip
 	}
 	var externalSK wgtypes.Key
-	if d.Get("external_sk").(string) != "" {
+	externalPK := d.Get("external_pk").(string)
+	if externalPK != "" {
+		_, err = wgtypes.ParseKey(externalPK)
+	} else if d.Get("external_sk").(string) != "" {
 		externalSK, err = wgtypes.ParseKey(d.Get("external_sk").(string))
 	} else {
 		externalSK, err = wgtypes.GeneratePrivateKey()
@@ -183,6 +433,14 @@ func NewNetworkDeployer(ctx context.Context, d *schema.ResourceData, apiClient *
 	if err != nil {
 		return NetworkDeployer{}, errors.Wrap(err, "couldn't parse network ip range")
 	}
+	accessPeers, err := getAccessPeers(d)
+	if err != nil {
+		return NetworkDeployer{}, err
+	}
+	reservedIPs := make([]string, 0)
+	for _, r := range d.Get("reserved_ips").([]interface{}) {
+		reservedIPs = append(reservedIPs, r.(string))
+	}
 	pool := client.NewNodeClientPool(apiClient.rmb)
 	deploymentData := DeploymentData{
 		Name:        d.Get("name").(string),
@@ -196,15 +454,27 @@ func NewNetworkDeployer(ctx context.Context, d *schema.ResourceData, apiClient *
 	deployer := NetworkDeployer{
 		Name:             d.Get("name").(string),
 		Description:      d.Get("description").(string),
+		SolutionType:     d.Get("solution_type").(string),
 		Nodes:            nodes,
 		IPRange:          ipRange,
+		NodeSubnetPrefix: d.Get("node_subnet_prefix").(int),
+		ReservedIPs:      reservedIPs,
 		AddWGAccess:      addWGAccess,
+		IPv6:             d.Get("ipv6").(bool),
+		AutoExtend:       autoExtend,
+		ExtendedNodes:    extendedNodes,
 		AccessWGConfig:   d.Get("access_wg_config").(string),
+		AccessPeers:      accessPeers,
 		ExternalIP:       externalIP,
 		ExternalSK:       externalSK,
+		ExternalPK:       externalPK,
 		PublicNodeID:     uint32(d.Get("public_node_id").(int)),
+		AccessNodes:      accessNodes,
+		HiddenAccess:     hiddenAccess,
 		NodesIPRange:     nodesIPRange,
 		NodeDeploymentID: nodeDeploymentID,
+		TopologyJSON:     d.Get("topology_json").(string),
+		TopologyDOT:      d.Get("topology_dot").(string),
 		Keys:             make(map[uint32]wgtypes.Key),
 		WGPort:           make(map[uint32]int),
 		APIClient:        apiClient,
@@ -231,28 +501,34 @@ func (k *NetworkDeployer) invalidateBrokenAttributes(sub subi.SubstrateExt) erro
 	if k.ExternalIP != nil && !k.IPRange.Contains(k.ExternalIP.IP) {
 		k.ExternalIP = nil
 	}
+	for idx, peer := range k.AccessPeers {
+		if peer.Subnet != nil && !k.IPRange.Contains(peer.Subnet.IP) {
+			k.AccessPeers[idx].Subnet = nil
+		}
+	}
 	for node, ip := range k.NodesIPRange {
 		if !k.IPRange.Contains(ip.IP) {
 			delete(k.NodesIPRange, node)
 		}
 	}
-	if k.PublicNodeID != 0 {
-		// TODO: add a check that the node is still public
-		cl, err := k.ncPool.GetNodeClient(sub, k.PublicNodeID)
-		if err != nil {
-			// whatever the error, delete it and it will get reassigned later
-			k.PublicNodeID = 0
-		}
-		if err := isNodeUp(context.Background(), cl); err != nil {
-			k.PublicNodeID = 0
-		}
-	}
-
 	if !k.AddWGAccess {
 		k.ExternalIP = nil
 	}
 	return nil
 }
+
+// failoverPublicNode drops the public node picked by the provider if it's down so another one gets picked,
+// the plan shows it changing. The designated access nodes are only changed by the user
+func (k *NetworkDeployer) failoverPublicNode(ctx context.Context, sub subi.SubstrateExt) {
+	if len(k.AccessNodes) != 0 || k.PublicNodeID == 0 {
+		return
+	}
+	if err := isNodesUp(ctx, sub, []uint32{k.PublicNodeID}, k.ncPool); err != nil {
+		log.Printf("public node %d is down, picking another one: %s", k.PublicNodeID, err)
+		k.PublicNodeID = 0
+	}
+}
+
 func (k *NetworkDeployer) Validate(ctx context.Context, sub subi.SubstrateExt) error {
 	if err := validateAccountMoneyForExtrinsics(sub, k.APIClient.identity); err != nil {
 		return err
@@ -261,8 +537,36 @@ func (k *NetworkDeployer) Validate(ctx context.Context, sub subi.SubstrateExt) e
 	if ones, _ := mask.Size(); ones != 16 {
 		return fmt.Errorf("subnet in iprange %s should be 16", k.IPRange.String())
 	}
+	if k.NodeSubnetPrefix < 18 || k.NodeSubnetPrefix > 28 {
+		return fmt.Errorf("node subnet prefix %d should be between 18 and 28", k.NodeSubnetPrefix)
+	}
+	if k.IPv6 && k.NodeSubnetPrefix < 24 {
+		// zos derives the ipv6 /64 of a node from the third octet of its subnet only
+		return fmt.Errorf("ipv6 can't be routed with node subnets bigger than a /24")
+	}
+	for idx, node := range k.AccessNodes {
+		if isInUint32(k.AccessNodes[:idx], node) {
+			return fmt.Errorf("access node %d is listed more than once", node)
+		}
+	}
+	names := make(map[string]bool)
+	for _, peer := range k.AccessPeers {
+		if names[peer.Name] {
+			return fmt.Errorf("access peer name %s is used more than once", peer.Name)
+		}
+		names[peer.Name] = true
+	}
+	for _, r := range k.ReservedIPs {
+		if err := state.ValidateRange(r); err != nil {
+			return errors.Wrap(err, "invalid reserved ip")
+		}
+		ip := net.ParseIP(strings.Split(r, "/")[0])
+		if !k.IPRange.Contains(ip) {
+			return fmt.Errorf("reserved ip %s is not in the network ip range %s", r, k.IPRange.String())
+		}
+	}
 
-	return isNodesUp(ctx, sub, k.Nodes, k.ncPool)
+	return isNodesUp(ctx, sub, append(append([]uint32{}, k.Nodes...), k.AccessNodes...), k.ncPool)
 }
 
 func (k *NetworkDeployer) ValidateDelete(ctx context.Context) error {
@@ -277,8 +581,10 @@ func (k *NetworkDeployer) storeState(d *schema.ResourceData, state state.StateI)
 	}
 
 	nodesIPRange := make(map[string]interface{})
+	nodesIP6Range := make(map[string]interface{})
 	for node, r := range k.NodesIPRange {
 		nodesIPRange[fmt.Sprintf("%d", node)] = r.String()
+		nodesIP6Range[fmt.Sprintf("%d", node)] = k.nodeIP6Range(r).String()
 	}
 
 	nodes := make([]uint32, 0)
@@ -289,7 +595,7 @@ func (k *NetworkDeployer) storeState(d *schema.ResourceData, state state.StateI)
 	}
 	for node := range k.NodeDeploymentID {
 		if !isInUint32(nodes, node) {
-			if k.PublicNodeID == node {
+			if k.PublicNodeID == node || isInUint32(k.AccessNodes, node) {
 				continue
 			}
 			nodes = append(nodes, node)
@@ -301,74 +607,188 @@ func (k *NetworkDeployer) storeState(d *schema.ResourceData, state state.StateI)
 
 	k.Nodes = nodes
 
+	userNodes := make([]uint32, 0)
+	extendedNodes := make([]uint32, 0)
+	for _, node := range nodes {
+		if isInUint32(k.ExtendedNodes, node) {
+			extendedNodes = append(extendedNodes, node)
+		} else {
+			userNodes = append(userNodes, node)
+		}
+	}
 	log.Printf("storing nodes: %v\n", nodes)
-	d.Set("nodes", nodes)
+	d.Set("nodes", userNodes)
+	d.Set("extended_nodes", extendedNodes)
 	d.Set("ip_range", k.IPRange.String())
+	d.Set("node_subnet_prefix", k.NodeSubnetPrefix)
+	d.Set("reserved_ips", k.ReservedIPs)
+	d.Set("ipv6", k.IPv6)
+	d.Set("ipv6_range", workloads.NetworkIP6Range(k.APIClient.twin_id, k.Name).String())
 	d.Set("access_wg_config", k.AccessWGConfig)
+	accessPeers := make([]interface{}, 0)
+	for _, peer := range k.AccessPeers {
+		subnet := ""
+		if peer.Subnet != nil {
+			subnet = peer.Subnet.String()
+		}
+		accessPeers = append(accessPeers, map[string]interface{}{
+			"name":        peer.Name,
+			"public_key":  peer.PublicKey,
+			"private_key": peer.PrivateKey,
+			"subnet":      subnet,
+			"wg_config":   peer.WGConfig,
+		})
+	}
+	d.Set("access_peers", accessPeers)
 	if k.ExternalIP == nil {
 		d.Set("external_ip", nil)
 	} else {
 
 		d.Set("external_ip", k.ExternalIP.String())
 	}
-	d.Set("external_sk", k.ExternalSK.String())
+	d.Set("external_sk", k.externalSK())
+	d.Set("external_pk", k.ExternalPK)
 	d.Set("public_node_id", k.PublicNodeID)
+	d.Set("access_nodes", k.AccessNodes)
+	hiddenAccess := make(map[string]interface{})
+	for node, access := range k.HiddenAccess {
+		hiddenAccess[fmt.Sprint(node)] = int(access)
+	}
+	d.Set("hidden_nodes_access", hiddenAccess)
 	// plural or singular?
 	d.Set("nodes_ip_range", nodesIPRange)
+	d.Set("nodes_ipv6_range", nodesIP6Range)
 	d.Set("node_deployment_id", nodeDeploymentID)
+	d.Set("topology_json", k.TopologyJSON)
+	d.Set("topology_dot", k.TopologyDOT)
 }
 
 func (k *NetworkDeployer) updateNetworkLocalState(state state.StateI) {
-	ns := state.GetNetworkState()
-	ns.DeleteNetwork(k.Name)
-	network := ns.GetNetwork(k.Name)
+	// the used ips are kept, they're owned by the deployments using the network
+	network := state.GetNetworkState().GetNetwork(k.Name)
+	for nodeID := range network.GetSubnets() {
+		if _, ok := k.NodesIPRange[nodeID]; !ok {
+			network.DeleteNodeSubnet(nodeID)
+		}
+	}
 	for nodeID, subnet := range k.NodesIPRange {
 		network.SetNodeSubnet(nodeID, subnet.String())
 	}
+	network.SetReservedIPs(k.ReservedIPs)
+	network.SetNodeDeploymentIDs(k.NodeDeploymentID)
+	if k.AutoExtend {
+		network.SetConfig(k.config())
+	} else {
+		network.SetConfig(nil)
+	}
 }
 
-func nextFreeOctet(used []byte, start *byte) error {
-	for isInByte(used, *start) && *start <= 254 {
-		*start += 1
+// config returns the configuration used by the deployments to extend the network
+func (k *NetworkDeployer) config() *state.NetworkConfig {
+	nodes := make([]uint32, 0)
+	for _, node := range k.Nodes {
+		if !isInUint32(k.ExtendedNodes, node) {
+			nodes = append(nodes, node)
+		}
 	}
-	if *start == 255 {
-		return errors.New("couldn't find a free ip to add node")
+	externalIP := ""
+	if k.ExternalIP != nil {
+		externalIP = k.ExternalIP.String()
+	}
+	nodeDeploymentID := make(map[uint32]uint64)
+	for node, id := range k.NodeDeploymentID {
+		nodeDeploymentID[node] = id
+	}
+	accessPeers := make([]state.AccessPeer, 0)
+	for _, peer := range k.AccessPeers {
+		subnet := ""
+		if peer.Subnet != nil {
+			subnet = peer.Subnet.String()
+		}
+		accessPeers = append(accessPeers, state.AccessPeer{
+			Name:      peer.Name,
+			PublicKey: peer.publicKey(),
+			Subnet:    subnet,
+		})
+	}
+	return &state.NetworkConfig{
+		IPRange:          k.IPRange.String(),
+		NodeSubnetPrefix: k.NodeSubnetPrefix,
+		Description:      k.Description,
+		SolutionType:     k.SolutionType,
+		AddWGAccess:      k.AddWGAccess,
+		IPv6:             k.IPv6,
+		ExternalIP:       externalIP,
+		ExternalPK:       k.externalPK(),
+		PublicNodeID:     k.PublicNodeID,
+		AccessNodes:      append([]uint32{}, k.AccessNodes...),
+		Nodes:            nodes,
+		ExtendedNodes:    append([]uint32{}, k.ExtendedNodes...),
+		NodeDeploymentID: nodeDeploymentID,
+		AccessPeers:      accessPeers,
 	}
-	return nil
+}
+
+// externalSK returns the private key of the access point, it's empty if only its public key is known
+func (k *NetworkDeployer) externalSK() string {
+	if k.ExternalPK != "" {
+		return ""
+	}
+	return k.ExternalSK.String()
+}
+
+// externalPK returns the public key of the access point
+func (k *NetworkDeployer) externalPK() string {
+	if k.ExternalPK != "" {
+		return k.ExternalPK
+	}
+	return k.ExternalSK.PublicKey().String()
 }
 
 func (k *NetworkDeployer) assignNodesIPs(nodes []uint32) error {
 	ips := make(map[uint32]gridtypes.IPNet)
-	l := len(k.IPRange.IP)
-	usedIPs := make([]byte, 0) // the third octet
+	used := make([]gridtypes.IPNet, 0)
 	for node, ip := range k.NodesIPRange {
 		if isInUint32(nodes, node) {
-			usedIPs = append(usedIPs, ip.IP[l-2])
+			used = append(used, ip)
 			ips[node] = ip
 		}
 	}
-	var cur byte = 2
+	for _, peer := range k.AccessPeers {
+		if peer.Subnet != nil {
+			used = append(used, *peer.Subnet)
+		}
+	}
 	if k.AddWGAccess {
 		if k.ExternalIP != nil {
-			usedIPs = append(usedIPs, k.ExternalIP.IP[l-2])
+			used = append(used, *k.ExternalIP)
 		} else {
-			err := nextFreeOctet(usedIPs, &cur)
+			ip, err := nextFreeSubnet(k.IPRange, k.NodeSubnetPrefix, used)
 			if err != nil {
-				return err
+				return errors.Wrap(err, "couldn't find a free subnet for the access peer")
 			}
-			usedIPs = append(usedIPs, cur)
-			ip := ipNet(k.IPRange.IP[l-4], k.IPRange.IP[l-3], cur, k.IPRange.IP[l-1], 24)
+			used = append(used, ip)
 			k.ExternalIP = &ip
 		}
 	}
 	for _, node := range nodes {
 		if _, ok := ips[node]; !ok {
-			err := nextFreeOctet(usedIPs, &cur)
+			ip, err := nextFreeSubnet(k.IPRange, k.NodeSubnetPrefix, used)
+			if err != nil {
+				return errors.Wrapf(err, "couldn't find a free subnet for node %d", node)
+			}
+			used = append(used, ip)
+			ips[node] = ip
+		}
+	}
+	for idx, peer := range k.AccessPeers {
+		if peer.Subnet == nil {
+			ip, err := nextFreeSubnet(k.IPRange, k.NodeSubnetPrefix, used)
 			if err != nil {
-				return err
+				return errors.Wrapf(err, "couldn't find a free subnet for access peer %s", peer.Name)
 			}
-			usedIPs = append(usedIPs, cur)
-			ips[node] = ipNet(k.IPRange.IP[l-4], k.IPRange.IP[l-3], cur, k.IPRange.IP[l-2], 24)
+			used = append(used, ip)
+			k.AccessPeers[idx].Subnet = &ip
 		}
 	}
 	k.NodesIPRange = ips
@@ -416,6 +836,7 @@ func (k *NetworkDeployer) readNodesConfig(ctx context.Context, sub subi.Substrat
 	}
 	printDeployments(nodeDeployments)
 	WGAccess := false
+	peers := make([]zos.Peer, 0)
 	for node, dl := range nodeDeployments {
 		for _, wl := range dl.Workloads {
 			if wl.Type != zos.NetworkType {
@@ -433,18 +854,44 @@ func (k *NetworkDeployer) readNodesConfig(ctx context.Context, sub subi.Substrat
 				return errors.Wrap(err, "couldn't parse wg private key from workload object")
 			}
 			nodesIPRange[node] = d.Subnet
-			// this will fail when hidden node is supported
-			for _, peer := range d.Peers {
-				if peer.Endpoint == "" {
-					WGAccess = true
-				}
-			}
+			peers = append(peers, d.Peers...)
+		}
+	}
+	// hidden nodes are peers without endpoints too, the access peer is the one that isn't a node
+	subnets := make(map[string]bool)
+	for _, subnet := range nodesIPRange {
+		subnets[subnet.String()] = true
+	}
+	for _, peer := range k.AccessPeers {
+		if peer.Subnet != nil {
+			subnets[peer.Subnet.String()] = true
+		}
+	}
+	for node := range nodesIPRange {
+		for _, peer := range k.APIClient.state.GetNetworkState().GetNetwork(k.Name).GetPeers(node) {
+			subnets[peer.Subnet] = true
+		}
+	}
+	for _, peer := range peers {
+		if peer.Endpoint == "" && !subnets[peer.Subnet.String()] {
+			WGAccess = true
 		}
 	}
 	k.Keys = keys
 	k.WGPort = WGPort
 	k.NodesIPRange = nodesIPRange
 	k.AddWGAccess = WGAccess
+	// networks with a single node have no peers to tell whether ipv6 is routed
+	if len(peers) != 0 {
+		k.IPv6 = false
+		for _, peer := range peers {
+			for _, ip := range peer.AllowedIPs {
+				if ip.IP.To4() == nil {
+					k.IPv6 = true
+				}
+			}
+		}
+	}
 	if !WGAccess {
 		k.AccessWGConfig = ""
 	}
@@ -477,9 +924,20 @@ func (k *NetworkDeployer) GenerateVersionlessDeployments(ctx context.Context, su
 			endpoints[node] = fmt.Sprintf("[%s]", endpoint.String())
 		}
 	}
-	needsIPv4Access := k.AddWGAccess || (len(hiddenNodes) != 0 && len(hiddenNodes)+len(accessibleNodes) > 1)
+	needsIPv4Access := k.AddWGAccess || len(k.AccessPeers) != 0 || (len(hiddenNodes) != 0 && len(hiddenNodes)+len(accessibleNodes) > 1)
 	if needsIPv4Access {
-		if k.PublicNodeID != 0 { // it's set
+		if len(k.AccessNodes) != 0 {
+			// the designated access nodes are all added, the first one is the public node
+			k.PublicNodeID = k.AccessNodes[0]
+			for _, node := range k.AccessNodes {
+				if isInUint32(hiddenNodes, node) {
+					return nil, fmt.Errorf("access node %d has no public endpoint", node)
+				}
+				if !isInUint32(accessibleNodes, node) {
+					accessibleNodes = append(accessibleNodes, node)
+				}
+			}
+		} else if k.PublicNodeID != 0 { // it's set
 			// if public node id is already set, it should be added to accessible nodes
 			if !isInUint32(accessibleNodes, k.PublicNodeID) {
 				accessibleNodes = append(accessibleNodes, k.PublicNodeID)
@@ -494,16 +952,20 @@ func (k *NetworkDeployer) GenerateVersionlessDeployments(ctx context.Context, su
 			k.PublicNodeID = publicNode
 			accessibleNodes = append(accessibleNodes, publicNode)
 		}
-		if endpoints[k.PublicNodeID] == "" { // old or new outsider
-			cl, err := k.ncPool.GetNodeClient(sub, k.PublicNodeID)
+		for _, node := range k.accessNodes() {
+			if endpoints[node] != "" {
+				continue
+			}
+			// old or new outsider
+			cl, err := k.ncPool.GetNodeClient(sub, node)
 			if err != nil {
-				return nil, errors.Wrapf(err, "couldn't get node %d client", k.PublicNodeID)
+				return nil, errors.Wrapf(err, "couldn't get node %d client", node)
 			}
-			endpoint, err := getNodeEndpoint(ctx, cl)
+			endpoint, err := getNodeWGEndpoint(ctx, cl)
 			if err != nil {
-				return nil, errors.Wrapf(err, "failed to get node %d endpoint", k.PublicNodeID)
+				return nil, errors.Wrapf(err, "failed to get node %d endpoint", node)
 			}
-			endpoints[k.PublicNodeID] = endpoint.String()
+			endpoints[node] = endpoint
 		}
 	}
 	all := append(hiddenNodes, accessibleNodes...)
@@ -516,30 +978,42 @@ func (k *NetworkDeployer) GenerateVersionlessDeployments(ctx context.Context, su
 	if err := k.assignNodesWGPort(ctx, sub, all); err != nil {
 		return nil, errors.Wrap(err, "couldn't assign node wg ports")
 	}
-	nonAccessibleIPRanges := []gridtypes.IPNet{}
+	// the hidden nodes are spread over the access nodes, so losing one of them only cuts its share.
+	// wireguard routes a range through a single peer, so a hidden node can't connect to all of them
+	accessNodes := k.accessNodes()
+	hiddenAccess := make(map[uint32]uint32)
+	// routed holds the ranges of the hidden nodes routed through each access node
+	routed := make(map[uint32][]gridtypes.IPNet)
 	for _, node := range hiddenNodes {
-		r := k.NodesIPRange[node]
-		nonAccessibleIPRanges = append(nonAccessibleIPRanges, r)
-		nonAccessibleIPRanges = append(nonAccessibleIPRanges, wgIP(r))
+		access, ok := hiddenNodeAccess(node, accessNodes)
+		if !ok {
+			break
+		}
+		hiddenAccess[node] = access
+		routed[access] = append(routed[access], k.allowedIPs(k.NodesIPRange[node])...)
 	}
+	k.HiddenAccess = hiddenAccess
+	// the access peers are peers of all access nodes, the other nodes reach them through the public node
+	externalIPRanges := []gridtypes.IPNet{}
 	if k.AddWGAccess {
-		r := k.ExternalIP
-		nonAccessibleIPRanges = append(nonAccessibleIPRanges, *r)
-		nonAccessibleIPRanges = append(nonAccessibleIPRanges, wgIP(*r))
+		externalIPRanges = append(externalIPRanges, k.allowedIPs(*k.ExternalIP)...)
+	}
+	for _, peer := range k.AccessPeers {
+		externalIPRanges = append(externalIPRanges, k.allowedIPs(*peer.Subnet)...)
 	}
 	log.Printf("hidden nodes: %v\n", hiddenNodes)
 	log.Printf("public node: %v\n", k.PublicNodeID)
+	log.Printf("access nodes: %v\n", accessNodes)
 	log.Printf("accessible nodes: %v\n", accessibleNodes)
-	log.Printf("non accessible ip ranges: %v\n", nonAccessibleIPRanges)
+	log.Printf("hidden nodes access: %v\n", hiddenAccess)
+	log.Printf("external ip ranges: %v\n", externalIPRanges)
 
+	configPeers := k.accessConfigPeers(accessNodes, endpoints, routed)
 	if k.AddWGAccess {
-		k.AccessWGConfig = generateWGConfig(
-			wgIP(*k.ExternalIP).IP.String(),
-			k.ExternalSK.String(),
-			k.Keys[k.PublicNodeID].PublicKey().String(),
-			fmt.Sprintf("%s:%d", endpoints[k.PublicNodeID], k.WGPort[k.PublicNodeID]),
-			k.IPRange.String(),
-		)
+		k.AccessWGConfig = k.accessConfig(*k.ExternalIP, k.externalSK(), configPeers)
+	}
+	for idx, peer := range k.AccessPeers {
+		k.AccessPeers[idx].WGConfig = k.accessConfig(*peer.Subnet, peer.PrivateKey, configPeers)
 	}
 
 	for _, node := range accessibleNodes {
@@ -548,43 +1022,31 @@ func (k *NetworkDeployer) GenerateVersionlessDeployments(ctx context.Context, su
 			if neigh == node {
 				continue
 			}
-			neighIPRange := k.NodesIPRange[neigh]
-			allowed_ips := []gridtypes.IPNet{
-				neighIPRange,
-				wgIP(neighIPRange),
+			allowed_ips := k.allowedIPs(k.NodesIPRange[neigh])
+			allowed_ips = append(allowed_ips, routed[neigh]...)
+			if neigh == k.PublicNodeID && !isInUint32(accessNodes, node) {
+				allowed_ips = append(allowed_ips, externalIPRanges...)
 			}
-			if neigh == k.PublicNodeID {
-				allowed_ips = append(allowed_ips, nonAccessibleIPRanges...)
-			}
-			peers = append(peers, zos.Peer{
-				Subnet:      k.NodesIPRange[neigh],
-				WGPublicKey: k.Keys[neigh].PublicKey().String(),
-				Endpoint:    fmt.Sprintf("%s:%d", endpoints[neigh], k.WGPort[neigh]),
-				AllowedIPs:  allowed_ips,
-			})
+			peers = append(peers, wgPeer(k.NodesIPRange[neigh], k.Keys[neigh].PublicKey().String(), endpoints[neigh], k.WGPort[neigh], allowed_ips))
 		}
-		if node == k.PublicNodeID {
+		if isInUint32(accessNodes, node) {
 			// external node
 			if k.AddWGAccess {
-				peers = append(peers, zos.Peer{
-					Subnet:      *k.ExternalIP,
-					WGPublicKey: k.ExternalSK.PublicKey().String(),
-					AllowedIPs:  []gridtypes.IPNet{*k.ExternalIP, wgIP(*k.ExternalIP)},
-				})
+				peers = append(peers, wgPeer(*k.ExternalIP, k.externalPK(), "", 0, k.allowedIPs(*k.ExternalIP)))
+			}
+			for _, peer := range k.AccessPeers {
+				peers = append(peers, wgPeer(*peer.Subnet, peer.publicKey(), "", 0, k.allowedIPs(*peer.Subnet)))
 			}
 			// hidden nodes
 			for _, neigh := range hiddenNodes {
+				if hiddenAccess[neigh] != node {
+					continue
+				}
 				neighIPRange := k.NodesIPRange[neigh]
-				peers = append(peers, zos.Peer{
-					Subnet:      neighIPRange,
-					WGPublicKey: k.Keys[neigh].PublicKey().String(),
-					AllowedIPs: []gridtypes.IPNet{
-						neighIPRange,
-						wgIP(neighIPRange),
-					},
-				})
+				peers = append(peers, wgPeer(neighIPRange, k.Keys[neigh].PublicKey().String(), "", 0, k.allowedIPs(neighIPRange)))
 			}
 		}
+		peers = append(peers, k.peeringPeers(node)...)
 
 		workload := gridtypes.Workload{
 			Version:     0,
@@ -622,17 +1084,17 @@ func (k *NetworkDeployer) GenerateVersionlessDeployments(ctx context.Context, su
 	for _, node := range hiddenNodes {
 		nodeIPRange := k.NodesIPRange[node]
 		peers := make([]zos.Peer, 0)
-		if k.PublicNodeID != 0 {
-			peers = append(peers, zos.Peer{
-				WGPublicKey: k.Keys[k.PublicNodeID].PublicKey().String(),
-				Subnet:      nodeIPRange,
-				AllowedIPs: []gridtypes.IPNet{
-					k.IPRange,
-					ipNet(100, 64, 0, 0, 16),
-				},
-				Endpoint: fmt.Sprintf("%s:%d", endpoints[k.PublicNodeID], k.WGPort[k.PublicNodeID]),
-			})
+		if access, ok := hiddenAccess[node]; ok {
+			allowedIPs := []gridtypes.IPNet{
+				k.IPRange,
+				ipNet(100, 64, 0, 0, 16),
+			}
+			if k.IPv6 {
+				allowedIPs = append(allowedIPs, workloads.NetworkIP6Range(k.APIClient.twin_id, k.Name))
+			}
+			peers = append(peers, wgPeer(nodeIPRange, k.Keys[access].PublicKey().String(), endpoints[access], k.WGPort[access], allowedIPs))
 		}
+		peers = append(peers, k.peeringPeers(node)...)
 		workload := gridtypes.Workload{
 			Version:     0,
 			Type:        zos.NetworkType,
@@ -664,8 +1126,115 @@ func (k *NetworkDeployer) GenerateVersionlessDeployments(ctx context.Context, su
 		}
 		deployments[node] = deployment
 	}
+	topology, err := k.topology(deployments, endpoints)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't generate network topology")
+	}
+	k.TopologyJSON, err = topology.JSON()
+	if err != nil {
+		return nil, err
+	}
+	k.TopologyDOT = topology.DOT(k.Name)
 	return deployments, nil
 }
+
+// peeringPeers returns the peers added to the node by the peerings with other networks
+func (k *NetworkDeployer) peeringPeers(node uint32) []zos.Peer {
+	peers := make([]zos.Peer, 0)
+	network := k.APIClient.state.GetNetworkState().GetNetwork(k.Name)
+	for _, p := range network.GetPeers(node) {
+		peer, err := zosPeer(p)
+		if err != nil {
+			log.Printf("skipping peer of peering %s: %s", p.Peering, err)
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// allowedIPs returns the ips routed to the peer with the ip range r
+func (k *NetworkDeployer) allowedIPs(r gridtypes.IPNet) []gridtypes.IPNet {
+	ips := []gridtypes.IPNet{r, wgIP(r)}
+	if k.IPv6 {
+		ips = append(ips, k.nodeIP6Range(r))
+	}
+	return ips
+}
+
+// nodeIP6Range returns the ipv6 /64 zos derives from the ip range of a node
+func (k *NetworkDeployer) nodeIP6Range(r gridtypes.IPNet) gridtypes.IPNet {
+	return workloads.NodeIP6Range(k.APIClient.twin_id, k.Name, r)
+}
+
+// hiddenNodeAccess picks the access node of a hidden node by rendezvous hashing, so the pick only depends
+// on the node and the access nodes. Adding an access node only moves the hidden nodes it picks and removing
+// one only moves the hidden nodes that were on it
+func hiddenNodeAccess(node uint32, accessNodes []uint32) (uint32, bool) {
+	var picked uint32
+	var pickedWeight uint64
+	for _, access := range accessNodes {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d:%d", node, access)
+		if weight := h.Sum64(); picked == 0 || weight > pickedWeight {
+			picked, pickedWeight = access, weight
+		}
+	}
+	return picked, picked != 0
+}
+
+// accessNodes returns the nodes the access peers and the hidden nodes connect to, the first one is the public node
+func (k *NetworkDeployer) accessNodes() []uint32 {
+	if len(k.AccessNodes) != 0 {
+		return k.AccessNodes
+	}
+	if k.PublicNodeID != 0 {
+		return []uint32{k.PublicNodeID}
+	}
+	return nil
+}
+
+// accessConfigPeers returns the access nodes as peers of the wg configs, the public node routes the whole
+// network and the other access nodes their own subnets and the hidden nodes connected to them
+func (k *NetworkDeployer) accessConfigPeers(accessNodes []uint32, endpoints map[uint32]string, routed map[uint32][]gridtypes.IPNet) []wgConfigPeer {
+	peers := make([]wgConfigPeer, 0, len(accessNodes))
+	for _, node := range accessNodes {
+		allowedIPs := make([]string, 0)
+		if node == k.PublicNodeID {
+			allowedIPs = append(allowedIPs, k.IPRange.String())
+			if k.IPv6 {
+				allowedIPs = append(allowedIPs, workloads.NetworkIP6Range(k.APIClient.twin_id, k.Name).String())
+			}
+			allowedIPs = append(allowedIPs, "100.64.0.0/16")
+		} else {
+			for _, ip := range append(k.allowedIPs(k.NodesIPRange[node]), routed[node]...) {
+				allowedIPs = append(allowedIPs, ip.String())
+			}
+		}
+		peers = append(peers, wgConfigPeer{
+			PublicKey:  k.Keys[node].PublicKey().String(),
+			Endpoint:   fmt.Sprintf("%s:%d", endpoints[node], k.WGPort[node]),
+			AllowedIPs: strings.Join(allowedIPs, ", "),
+		})
+	}
+	return peers
+}
+
+// accessConfig generates the wg config of an access peer with the subnet, connecting it to the access nodes
+func (k *NetworkDeployer) accessConfig(subnet gridtypes.IPNet, privateKey string, peers []wgConfigPeer) string {
+	address := wgIP(subnet).IP.String()
+	if k.IPv6 {
+		address = fmt.Sprintf("%s, %s", address, k.peerIP6(subnet))
+	}
+	return generateWGConfig(address, privateKey, peers)
+}
+
+// peerIP6 returns the ipv6 of an access peer in the /64 of its subnet
+func (k *NetworkDeployer) peerIP6(subnet gridtypes.IPNet) string {
+	ip := subnet.IP.To4()
+	return workloads.PrivateIP6(k.APIClient.twin_id, k.Name, net.IPv4(ip[0], ip[1], ip[2], 1).String())
+}
+
 func (k *NetworkDeployer) Deploy(ctx context.Context, sub subi.SubstrateExt) error {
 	newDeployments, err := k.GenerateVersionlessDeployments(ctx, sub)
 	if err != nil {
@@ -698,6 +1267,68 @@ func (k *NetworkDeployer) Cancel(ctx context.Context, sub subi.SubstrateExt) err
 	return err
 }
 
+// hiddenNodesAccessDiff shows the hidden nodes moving to other access nodes in the plan, the nodes added
+// to the network aren't known to be hidden before they're deployed
+func hiddenNodesAccessDiff(d *schema.ResourceDiff) error {
+	if d.Id() == "" || !d.HasChanges("nodes", "access_nodes") {
+		return nil
+	}
+	current := d.Get("hidden_nodes_access").(map[string]interface{})
+	accessNodes := make([]uint32, 0)
+	for _, n := range d.Get("access_nodes").([]interface{}) {
+		accessNodes = append(accessNodes, uint32(n.(int)))
+	}
+	if !d.NewValueKnown("nodes") || !d.NewValueKnown("access_nodes") || len(accessNodes) == 0 {
+		// the public node is picked while deploying
+		return d.SetNewComputed("hidden_nodes_access")
+	}
+	known := d.Get("nodes_ip_range").(map[string]interface{})
+	nodes := append(d.Get("nodes").([]interface{}), d.Get("extended_nodes").([]interface{})...)
+	hiddenAccess := make(map[string]interface{})
+	for _, n := range nodes {
+		node := fmt.Sprint(n)
+		if _, ok := known[node]; !ok {
+			return d.SetNewComputed("hidden_nodes_access")
+		}
+		if _, ok := current[node]; !ok {
+			continue
+		}
+		access, _ := hiddenNodeAccess(uint32(n.(int)), accessNodes)
+		hiddenAccess[node] = int(access)
+	}
+	if reflect.DeepEqual(current, hiddenAccess) {
+		return nil
+	}
+	if err := d.SetNew("hidden_nodes_access", hiddenAccess); err != nil {
+		return errors.Wrap(err, "couldn't set the access nodes of the hidden nodes")
+	}
+	// the access nodes route other hidden nodes in the wg config
+	return d.SetNewComputed("access_wg_config")
+}
+
+// resourceNetworkCustomizeDiff shows the public node picked by the provider as changing when it's down,
+// instead of replacing it silently on the next apply
+func resourceNetworkCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if err := hiddenNodesAccessDiff(d); err != nil {
+		return err
+	}
+	publicNode := uint32(d.Get("public_node_id").(int))
+	if d.Id() == "" || publicNode == 0 || len(d.Get("access_nodes").([]interface{})) != 0 {
+		return nil
+	}
+	apiClient := meta.(*apiClient)
+	pool := client.NewNodeClientPool(apiClient.rmb)
+	if err := isNodesUp(ctx, apiClient.substrateConn, []uint32{publicNode}, pool); err == nil {
+		return nil
+	}
+	for _, key := range []string{"public_node_id", "access_wg_config", "hidden_nodes_access"} {
+		if err := d.SetNewComputed(key); err != nil {
+			return errors.Wrapf(err, "couldn't mark %s as changing", key)
+		}
+	}
+	return nil
+}
+
 func resourceNetworkCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	apiClient := meta.(*apiClient)
@@ -736,6 +1367,14 @@ func resourceNetworkUpdate(ctx context.Context, d *schema.ResourceData, meta int
 	if err := deployer.invalidateBrokenAttributes(apiClient.substrateConn); err != nil {
 		return diag.FromErr(errors.Wrap(err, "couldn't invalidate broken attributes"))
 	}
+	deployer.failoverPublicNode(ctx, apiClient.substrateConn)
+	// keep the keys and ports of the deployed nodes so the access configs stay valid
+	addWGAccess, ipv6 := deployer.AddWGAccess, deployer.IPv6
+	if err := deployer.readNodesConfig(ctx, apiClient.substrateConn); err != nil {
+		// the nodes that are down get new keys
+		log.Printf("couldn't read network nodes: %s", err)
+	}
+	deployer.AddWGAccess, deployer.IPv6 = addWGAccess, ipv6
 
 	err = deployer.Deploy(ctx, apiClient.substrateConn)
 	if err != nil {
@@ -790,3 +1429,154 @@ func resourceNetworkDelete(ctx context.Context, d *schema.ResourceData, meta int
 	}
 	return diags
 }
+
+// resourceNetworkImport adopts a network using an id of the form <network_name>:<contract_id>,<contract_id>...
+// listing the contracts of the network deployments on all of its nodes. The private key of the
+// wireguard access peer isn't stored on the grid, a new one is generated on the next apply
+func resourceNetworkImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	apiClient := meta.(*apiClient)
+	parts := strings.SplitN(d.Id(), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid import id %s, expected <network_name>:<contract_id>,<contract_id>...", d.Id())
+	}
+	name := parts[0]
+	contracts, err := parseContractList(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]uint32, 0)
+	nodeDeploymentID := make(map[string]interface{})
+	subnets := make(map[string]bool)
+	peers := make(map[uint32][]zos.Peer)
+	var network *zos.Network
+	var imported importedDeployment
+	for _, contractID := range contracts {
+		imported, err = importDeployment(ctx, apiClient, contractID)
+		if err != nil {
+			return nil, err
+		}
+		wl, err := imported.Deployment.Get(gridtypes.Name(name))
+		if err != nil || wl.Type != zos.NetworkType {
+			return nil, fmt.Errorf("deployment %d has no network named %s", contractID, name)
+		}
+		data, err := wl.WorkloadData()
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse network workload of deployment %d", contractID)
+		}
+		network = data.(*zos.Network)
+		nodes = append(nodes, imported.Node)
+		nodeDeploymentID[fmt.Sprint(imported.Node)] = int(contractID)
+		subnets[network.Subnet.String()] = true
+		peers[imported.Node] = network.Peers
+		d.Set("description", wl.Description)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	// only the access node of the network has peers without endpoints, these are either
+	// hidden nodes or the wireguard access peer
+	var publicNode uint32
+	externalIP := ""
+	for node, nodePeers := range peers {
+		for _, peer := range nodePeers {
+			if peer.Endpoint != "" {
+				continue
+			}
+			publicNode = node
+			if !subnets[peer.Subnet.String()] {
+				externalIP = peer.Subnet.String()
+			}
+		}
+	}
+	d.SetId(uuid.New().String())
+	d.Set("name", name)
+	d.Set("nodes", nodes)
+	d.Set("ip_range", network.NetworkIPRange.String())
+	ones, _ := network.Subnet.Mask.Size()
+	d.Set("node_subnet_prefix", ones)
+	d.Set("node_deployment_id", nodeDeploymentID)
+	d.Set("public_node_id", publicNode)
+	d.Set("add_wg_access", externalIP != "")
+	d.Set("external_ip", externalIP)
+	if imported.Data.ProjectName != "" {
+		d.Set("solution_type", imported.Data.ProjectName)
+	}
+	return readImported(ctx, d, meta, resourceNetworkRead)
+}
+
+// extendNetwork adds the nodes missing from a network created with auto_extend, the nodes
+// of other networks are left for the validation of the resources using them
+func extendNetwork(ctx context.Context, sub subi.SubstrateExt, apiClient *apiClient, networkName string, nodes []uint32) error {
+	apiClient.networkLock.Lock()
+	defer apiClient.networkLock.Unlock()
+	network := apiClient.state.GetNetworkState().GetNetwork(networkName)
+	config := network.GetConfig()
+	if config == nil {
+		return nil
+	}
+	missing := make([]uint32, 0)
+	for _, node := range nodes {
+		if network.GetNodeSubnet(node) == "" && !isInUint32(missing, node) {
+			missing = append(missing, node)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	log.Printf("extending network %s to nodes %v", networkName, missing)
+	nodeDeploymentID := make(map[string]interface{})
+	for node, id := range config.NodeDeploymentID {
+		nodeDeploymentID[fmt.Sprint(node)] = int(id)
+	}
+	d := resourceNetwork().Data(nil)
+	d.Set("name", networkName)
+	d.Set("description", config.Description)
+	d.Set("solution_type", config.SolutionType)
+	d.Set("ip_range", config.IPRange)
+	prefix := config.NodeSubnetPrefix
+	if prefix == 0 {
+		// stored before the prefix was configurable
+		prefix = 24
+	}
+	d.Set("node_subnet_prefix", prefix)
+	d.Set("reserved_ips", network.GetReservedIPs())
+	d.Set("nodes", config.Nodes)
+	d.Set("auto_extend", true)
+	d.Set("extended_nodes", append(append([]uint32{}, config.ExtendedNodes...), missing...))
+	d.Set("add_wg_access", config.AddWGAccess)
+	d.Set("ipv6", config.IPv6)
+	d.Set("external_ip", config.ExternalIP)
+	d.Set("public_node_id", config.PublicNodeID)
+	d.Set("access_nodes", config.AccessNodes)
+	d.Set("node_deployment_id", nodeDeploymentID)
+	k, err := NewNetworkDeployer(ctx, d, apiClient)
+	if err != nil {
+		return errors.Wrap(err, "couldn't load network data")
+	}
+	// the private keys aren't stored, the access configs are generated again by the network resource
+	k.ExternalPK = config.ExternalPK
+	for _, peer := range config.AccessPeers {
+		accessPeer := AccessPeer{
+			Name:      peer.Name,
+			PublicKey: peer.PublicKey,
+		}
+		if subnet, err := gridtypes.ParseIPNet(peer.Subnet); err == nil {
+			accessPeer.Subnet = &subnet
+		}
+		k.AccessPeers = append(k.AccessPeers, accessPeer)
+	}
+	// keep the keys, ports and subnets of the current nodes
+	if err := k.readNodesConfig(ctx, sub); err != nil {
+		return errors.Wrap(err, "couldn't read network nodes")
+	}
+	if err := k.Validate(ctx, sub); err != nil {
+		return err
+	}
+	err = k.Deploy(ctx, sub)
+	// store whatever got deployed, the network resource picks it up on its next refresh
+	k.AddWGAccess = config.AddWGAccess
+	for node, subnet := range k.NodesIPRange {
+		network.SetNodeSubnet(node, subnet.String())
+	}
+	network.SetConfig(k.config())
+	return errors.Wrapf(err, "couldn't extend network %s to nodes %v", networkName, missing)
+}
@@ -0,0 +1,424 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/deployer"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var errNetworkNotDeployed = errors.New("network isn't deployed on the node")
+
+func resourceNetworkPeering() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "Network peering resource, connects a node of a network to a node of another network of the same twin so the workloads on them can reach each other. The workloads need a route to the subnet of the other node through their network gateway.",
+
+		CreateContext: resourceNetworkPeeringCreate,
+		ReadContext:   resourceNetworkPeeringRead,
+		DeleteContext: resourceNetworkPeeringDelete,
+
+		Schema: map[string]*schema.Schema{
+			"network_a": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the first network",
+			},
+			"node_a": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Node of the first network connected to node_b",
+			},
+			"network_b": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the second network, its ip range can't overlap the one of the first network",
+			},
+			"node_b": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Node of the second network connected to node_a",
+			},
+			"subnet_a": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Subnet of node_a in the first network, routed to it on node_b",
+			},
+			"subnet_b": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Subnet of node_b in the second network, routed to it on node_a",
+			},
+		},
+	}
+}
+
+// NetworkPeering connects the network resources of two networks on their nodes by adding each of them as a
+// wireguard peer of the other. The peers are kept in the local state of the networks so they're added
+// again when the networks are updated
+type NetworkPeering struct {
+	ID        string
+	Sides     [2]*peeringSide
+	APIClient *apiClient
+	ncPool    *client.NodeClientPool
+	deployer  deployer.Deployer
+}
+
+// peeringSide is the network resource of one of the peered networks on its node
+type peeringSide struct {
+	Network    string
+	Node       uint32
+	ContractID uint64
+
+	deployment gridtypes.Deployment
+	resource   *zos.Network
+	// endpoint is the host of the node wireguard endpoint, it's empty if the node is hidden
+	endpoint string
+}
+
+func newNetworkPeering(d *schema.ResourceData, apiClient *apiClient) *NetworkPeering {
+	pool := client.NewNodeClientPool(apiClient.rmb)
+	p := &NetworkPeering{
+		ID:        d.Id(),
+		APIClient: apiClient,
+		ncPool:    pool,
+		deployer:  deployer.NewDeployer(apiClient.identity, apiClient.twin_id, apiClient.grid_client, pool, true, nil, ""),
+	}
+	for idx, suffix := range []string{"a", "b"} {
+		p.Sides[idx] = &peeringSide{
+			Network: d.Get("network_" + suffix).(string),
+			Node:    uint32(d.Get("node_" + suffix).(int)),
+		}
+	}
+	return p
+}
+
+// load reads the network resource of the side from its node
+func (p *NetworkPeering) load(ctx context.Context, sub subi.SubstrateExt, side *peeringSide) error {
+	network := p.APIClient.state.GetNetworkState().GetNetwork(side.Network)
+	side.ContractID = network.GetNodeDeploymentID(side.Node)
+	if side.ContractID == 0 {
+		return errors.Wrapf(errNetworkNotDeployed, "network %s on node %d", side.Network, side.Node)
+	}
+	valid, err := sub.IsValidContract(side.ContractID)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't check contract %d of network %s", side.ContractID, side.Network)
+	}
+	if !valid {
+		return errors.Wrapf(errNetworkNotDeployed, "network %s on node %d", side.Network, side.Node)
+	}
+	dls, err := p.deployer.GetDeploymentObjects(ctx, sub, map[uint32]uint64{side.Node: side.ContractID})
+	if err != nil {
+		return errors.Wrapf(err, "couldn't get network %s deployment on node %d", side.Network, side.Node)
+	}
+	side.deployment = dls[side.Node]
+	side.resource = nil
+	for _, wl := range side.deployment.Workloads {
+		if wl.Type != zos.NetworkType || wl.Name.String() != side.Network {
+			continue
+		}
+		data, err := wl.WorkloadData()
+		if err != nil {
+			return errors.Wrapf(err, "couldn't parse network %s workload on node %d", side.Network, side.Node)
+		}
+		side.resource = data.(*zos.Network)
+	}
+	if side.resource == nil {
+		return fmt.Errorf("deployment %d of node %d has no network %s", side.ContractID, side.Node, side.Network)
+	}
+	cl, err := p.ncPool.GetNodeClient(sub, side.Node)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't get node %d client", side.Node)
+	}
+	side.endpoint, err = getNodeWGEndpoint(ctx, cl)
+	if errors.Is(err, ErrNoAccessibleInterfaceFound) {
+		side.endpoint = ""
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to get node %d endpoint", side.Node)
+	}
+	return nil
+}
+
+func (p *NetworkPeering) Validate() error {
+	a, b := p.Sides[0], p.Sides[1]
+	if a.Network == b.Network {
+		return fmt.Errorf("network %s can't be peered with itself", a.Network)
+	}
+	if a.resource.NetworkIPRange.Contains(b.resource.NetworkIPRange.IP) || b.resource.NetworkIPRange.Contains(a.resource.NetworkIPRange.IP) {
+		return fmt.Errorf("ip range %s of network %s overlaps ip range %s of network %s", a.resource.NetworkIPRange.String(), a.Network, b.resource.NetworkIPRange.String(), b.Network)
+	}
+	if a.endpoint == "" && b.endpoint == "" {
+		return fmt.Errorf("nodes %d and %d are both hidden, one of them needs a public endpoint", a.Node, b.Node)
+	}
+	for idx, side := range p.Sides {
+		other := p.Sides[1-idx]
+		key := other.publicKey()
+		for _, peer := range side.resource.Peers {
+			if peer.WGPublicKey == key {
+				continue
+			}
+			for _, ip := range peer.AllowedIPs {
+				if ip.Contains(other.resource.Subnet.IP) || other.resource.Subnet.Contains(ip.IP) {
+					return fmt.Errorf("subnet %s of network %s is already routed to %s on node %d of network %s", other.resource.Subnet.String(), other.Network, peer.Subnet.String(), side.Node, side.Network)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// peers returns the peers added to the side, the node of the other side with its subnet
+func (p *NetworkPeering) peers(idx int) []state.Peer {
+	side, other := p.Sides[idx], p.Sides[1-idx]
+	peer := wgPeer(other.resource.Subnet, other.publicKey(), other.endpoint, int(other.resource.WGListenPort), []gridtypes.IPNet{other.resource.Subnet})
+	return []state.Peer{statePeer(side.Node, other.Network, other.Node, peer)}
+}
+
+// deploySide sets the peers of the peering on the network resource of the side, replacing the ones it had
+func (p *NetworkPeering) deploySide(ctx context.Context, sub subi.SubstrateExt, side *peeringSide, peers []state.Peer) error {
+	network := p.APIClient.state.GetNetworkState().GetNetwork(side.Network)
+	removed := make(map[string]bool)
+	for _, peer := range network.GetPeers(side.Node) {
+		if peer.Peering == p.ID {
+			removed[peer.PublicKey] = true
+		}
+	}
+	for _, peer := range peers {
+		removed[peer.PublicKey] = true
+	}
+	resource := *side.resource
+	resource.Peers = make([]zos.Peer, 0, len(side.resource.Peers)+len(peers))
+	for _, peer := range side.resource.Peers {
+		if !removed[peer.WGPublicKey] {
+			resource.Peers = append(resource.Peers, peer)
+		}
+	}
+	for _, peer := range peers {
+		zp, err := zosPeer(peer)
+		if err != nil {
+			return err
+		}
+		resource.Peers = append(resource.Peers, zp)
+	}
+	dl := updatedDeployment(side.deployment, side.Network, resource)
+	if _, err := p.deployer.Deploy(ctx, sub, map[uint32]uint64{side.Node: side.ContractID}, map[uint32]gridtypes.Deployment{side.Node: dl}); err != nil {
+		return errors.Wrapf(err, "couldn't update network %s on node %d", side.Network, side.Node)
+	}
+	network.SetPeers(p.ID, peers)
+	return nil
+}
+
+func (p *NetworkPeering) Deploy(ctx context.Context, sub subi.SubstrateExt) error {
+	for idx, side := range p.Sides {
+		if err := p.deploySide(ctx, sub, side, p.peers(idx)); err != nil {
+			if idx != 0 {
+				// a half peering is useless, remove it from the first network
+				if rerr := p.deploySide(ctx, sub, p.Sides[0], nil); rerr != nil {
+					return fmt.Errorf("%w; failed to revert network %s: %s", err, p.Sides[0].Network, rerr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *NetworkPeering) Cancel(ctx context.Context, sub subi.SubstrateExt) error {
+	for _, side := range p.Sides {
+		err := p.load(ctx, sub, side)
+		if errors.Is(err, errNetworkNotDeployed) {
+			// the network is gone with its peers
+			p.APIClient.state.GetNetworkState().GetNetwork(side.Network).SetPeers(p.ID, nil)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := p.deploySide(ctx, sub, side, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forget removes the peers of the peering from the local state of the networks
+func (p *NetworkPeering) forget() {
+	for _, side := range p.Sides {
+		p.APIClient.state.GetNetworkState().GetNetwork(side.Network).SetPeers(p.ID, nil)
+	}
+}
+
+func (p *NetworkPeering) storeState(d *schema.ResourceData) {
+	for idx, suffix := range []string{"a", "b"} {
+		subnet := ""
+		if p.Sides[idx].resource != nil {
+			subnet = p.Sides[idx].resource.Subnet.String()
+		}
+		d.Set("subnet_"+suffix, subnet)
+	}
+}
+
+// publicKey returns the wireguard public key of the network resource
+func (s *peeringSide) publicKey() string {
+	key, err := wgtypes.ParseKey(s.resource.WGPrivateKey)
+	if err != nil {
+		return ""
+	}
+	return key.PublicKey().String()
+}
+
+// updatedDeployment returns the deployment to send to the node to replace the network resource
+func updatedDeployment(dl gridtypes.Deployment, network string, resource zos.Network) gridtypes.Deployment {
+	workloads := make([]gridtypes.Workload, 0, len(dl.Workloads))
+	for _, wl := range dl.Workloads {
+		data := wl.Data
+		if wl.Type == zos.NetworkType && wl.Name.String() == network {
+			data = gridtypes.MustMarshal(resource)
+		}
+		workloads = append(workloads, gridtypes.Workload{
+			Version:     0,
+			Name:        wl.Name,
+			Type:        wl.Type,
+			Data:        data,
+			Metadata:    wl.Metadata,
+			Description: wl.Description,
+		})
+	}
+	return gridtypes.Deployment{
+		Version:     0,
+		TwinID:      dl.TwinID,
+		Metadata:    dl.Metadata,
+		Description: dl.Description,
+		Workloads:   workloads,
+		SignatureRequirement: gridtypes.SignatureRequirement{
+			WeightRequired: 1,
+			Requests: []gridtypes.SignatureRequest{
+				{
+					TwinID: dl.TwinID,
+					Weight: 1,
+				},
+			},
+		},
+	}
+}
+
+// statePeer returns the peer to keep in the local state of the network
+func statePeer(node uint32, network string, peerNode uint32, peer zos.Peer) state.Peer {
+	allowedIPs := make([]string, 0, len(peer.AllowedIPs))
+	for _, ip := range peer.AllowedIPs {
+		allowedIPs = append(allowedIPs, ip.String())
+	}
+	return state.Peer{
+		Node:       node,
+		Network:    network,
+		PeerNode:   peerNode,
+		Subnet:     peer.Subnet.String(),
+		PublicKey:  peer.WGPublicKey,
+		Endpoint:   peer.Endpoint,
+		AllowedIPs: allowedIPs,
+	}
+}
+
+// zosPeer returns the peer of the local state of the network as a peer of its network resource
+func zosPeer(peer state.Peer) (zos.Peer, error) {
+	subnet, err := gridtypes.ParseIPNet(peer.Subnet)
+	if err != nil {
+		return zos.Peer{}, errors.Wrapf(err, "invalid subnet of peer %s", peer.PublicKey)
+	}
+	allowedIPs := make([]gridtypes.IPNet, 0, len(peer.AllowedIPs))
+	for _, r := range peer.AllowedIPs {
+		ip, err := gridtypes.ParseIPNet(r)
+		if err != nil {
+			return zos.Peer{}, errors.Wrapf(err, "invalid allowed ip of peer %s", peer.PublicKey)
+		}
+		allowedIPs = append(allowedIPs, ip)
+	}
+	return zos.Peer{
+		Subnet:      subnet,
+		WGPublicKey: peer.PublicKey,
+		Endpoint:    peer.Endpoint,
+		AllowedIPs:  allowedIPs,
+	}, nil
+}
+
+func resourceNetworkPeeringCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	apiClient := meta.(*apiClient)
+	p := newNetworkPeering(d, apiClient)
+	p.ID = uuid.New().String()
+	for _, side := range p.Sides {
+		if err := p.load(ctx, apiClient.substrateConn, side); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	if err := p.Validate(); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := p.Deploy(ctx, apiClient.substrateConn); err != nil {
+		return diag.FromErr(err)
+	}
+	p.storeState(d)
+	d.SetId(p.ID)
+	return nil
+}
+
+func resourceNetworkPeeringRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	apiClient := meta.(*apiClient)
+	p := newNetworkPeering(d, apiClient)
+	for _, side := range p.Sides {
+		err := p.load(ctx, apiClient.substrateConn, side)
+		if errors.Is(err, errNetworkNotDeployed) {
+			log.Printf("peering %s is gone: %s", p.ID, err)
+			p.forget()
+			d.SetId("")
+			return nil
+		} else if err != nil {
+			return diag.Diagnostics{{
+				Severity: diag.Warning,
+				Summary:  "Error reading data from remote, terraform state might be out of sync with the remote state",
+				Detail:   err.Error(),
+			}}
+		}
+	}
+	// the peering is created again if a network was deployed without it
+	for idx, side := range p.Sides {
+		key := p.Sides[1-idx].publicKey()
+		found := false
+		for _, peer := range side.resource.Peers {
+			if peer.WGPublicKey == key {
+				found = true
+			}
+		}
+		if !found {
+			log.Printf("network %s on node %d lost peering %s", side.Network, side.Node, p.ID)
+			p.forget()
+			d.SetId("")
+			return nil
+		}
+	}
+	p.storeState(d)
+	return nil
+}
+
+func resourceNetworkPeeringDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	apiClient := meta.(*apiClient)
+	p := newNetworkPeering(d, apiClient)
+	if err := p.Cancel(ctx, apiClient.substrateConn); err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId("")
+	return nil
+}
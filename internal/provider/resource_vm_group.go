@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+func resourceVMGroup() *schema.Resource {
+	deployment := resourceDeployment().Schema
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "VM group resource (vms + disks + zdbs placed on multiple nodes, with one deployment per node).",
+
+		CreateContext: ResourceFunc(resourceVMGroupCreate),
+		ReadContext:   ResourceReadFunc(resourceVMGroupRead),
+		UpdateContext: ResourceFunc(resourceVMGroupUpdate),
+		DeleteContext: ResourceFunc(resourceVMGroupDelete),
+
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceVMGroupImport,
+		},
+
+		CustomizeDiff: resourceVMGroupCustomizeDiff,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(45 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "vm_group",
+			},
+			"solution_type":     deployment["solution_type"],
+			"solution_provider": deployment["solution_provider"],
+			"network_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Network to use for Zmachines, it must contain the nodes of the vms",
+			},
+			"disks": withNode(deployment["disks"]),
+			"zdbs":  withNode(deployment["zdbs"]),
+			"vms":   withNode(deployment["vms"]),
+			"node_deployment_id": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Description: "Mapping from each node to its deployment id",
+			},
+		},
+	}
+}
+
+// withNode copies a workload list schema of grid_deployment adding the node to place each workload on
+func withNode(s *schema.Schema) *schema.Schema {
+	elem := s.Elem.(*schema.Resource)
+	fields := make(map[string]*schema.Schema, len(elem.Schema)+1)
+	for key, field := range elem.Schema {
+		fields[key] = field
+	}
+	fields["node"] = &schema.Schema{
+		Type:        schema.TypeInt,
+		Required:    true,
+		Description: "Node id to place the workload on",
+	}
+	return &schema.Schema{
+		Type:        s.Type,
+		Optional:    true,
+		Description: s.Description,
+		Elem:        &schema.Resource{Schema: fields},
+	}
+}
+
+// resourceVMGroupCustomizeDiff reports the conflicting static ips of the vms in the plan
+func resourceVMGroupCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	apiClient := meta.(*apiClient)
+	networkName := d.Get("network_name").(string)
+	if networkName == "" {
+		return nil
+	}
+	nodeDeploymentID := d.Get("node_deployment_id").(map[string]interface{})
+	nodeVMs := make(map[uint32][]map[string]interface{})
+	for _, vm := range d.Get("vms").([]interface{}) {
+		m := vm.(map[string]interface{})
+		node := uint32(m["node"].(int))
+		nodeVMs[node] = append(nodeVMs[node], m)
+	}
+	network := apiClient.state.GetNetworkState().GetNetwork(networkName)
+	for node, vms := range nodeVMs {
+		owner := ""
+		if id, ok := nodeDeploymentID[fmt.Sprint(node)]; ok {
+			owner = fmt.Sprint(id)
+		}
+		if err := checkVMIPs(network, node, owner, vms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resourceVMGroupCreate(ctx context.Context, sub subi.SubstrateExt, d *schema.ResourceData, apiClient *apiClient) (Marshalable, error) {
+	deployer, err := NewVMGroupDeployer(d, apiClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't load deployer data")
+	}
+	return &deployer, deployer.Deploy(ctx, sub)
+}
+
+func resourceVMGroupRead(ctx context.Context, sub subi.SubstrateExt, d *schema.ResourceData, apiClient *apiClient) (Marshalable, error) {
+	deployer, err := NewVMGroupDeployer(d, apiClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't load deployer data")
+	}
+	return &deployer, nil
+}
+
+func resourceVMGroupUpdate(ctx context.Context, sub subi.SubstrateExt, d *schema.ResourceData, apiClient *apiClient) (Marshalable, error) {
+	deployer, err := NewVMGroupDeployer(d, apiClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't load deployer data")
+	}
+	return &deployer, deployer.Deploy(ctx, sub)
+}
+
+func resourceVMGroupDelete(ctx context.Context, sub subi.SubstrateExt, d *schema.ResourceData, apiClient *apiClient) (Marshalable, error) {
+	deployer, err := NewVMGroupDeployer(d, apiClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't load deployer data")
+	}
+	return &deployer, deployer.Cancel(ctx, sub)
+}
+
+// resourceVMGroupImport adopts a group using an id listing the contracts of its deployments
+// as <contract_id>,<contract_id>...
+func resourceVMGroupImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	apiClient := meta.(*apiClient)
+	contracts, err := parseContractList(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	nodeDeploymentID := make(map[string]interface{})
+	for _, contractID := range contracts {
+		imported, err := importDeployment(ctx, apiClient, contractID)
+		if err != nil {
+			return nil, err
+		}
+		nodeDeploymentID[fmt.Sprint(imported.Node)] = int(contractID)
+		setImportedDeploymentData(d, imported.Data)
+		for _, wl := range imported.Deployment.Workloads {
+			if wl.Type != zos.ZMachineType {
+				continue
+			}
+			data, err := wl.WorkloadData()
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldn't parse vm %s", wl.Name)
+			}
+			vm := data.(*zos.ZMachine)
+			if len(vm.Network.Interfaces) == 0 {
+				continue
+			}
+			networkName := string(vm.Network.Interfaces[0].Network)
+			if err := importNodeSubnet(ctx, apiClient, networkName, imported.Node, vm.Network.Interfaces[0].IP); err != nil {
+				return nil, err
+			}
+			d.Set("network_name", networkName)
+		}
+	}
+	d.SetId(uuid.New().String())
+	d.Set("node_deployment_id", nodeDeploymentID)
+	return readImported(ctx, d, meta, resourceFunc(resourceVMGroupRead, true))
+}
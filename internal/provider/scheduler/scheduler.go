@@ -12,6 +12,7 @@ import (
 // NodeInfo related to scheduling
 type nodeInfo struct {
 	FreeCapacity *Capacity
+	Cores        uint64
 	FarmID       int
 	HasIPv4      bool
 	HasDomain    bool
@@ -77,6 +78,7 @@ func (n *Scheduler) addNodes(nodes []proxytypes.Node) {
 			cap := freeCapacity(&node)
 			n.nodes[uint32(node.NodeID)] = nodeInfo{
 				FreeCapacity: &cap,
+				Cores:        node.TotalResources.CRU,
 				HasIPv4:      node.PublicConfig.Ipv4 != "",
 				HasDomain:    node.PublicConfig.Domain != "",
 				FarmID:       node.FarmID,
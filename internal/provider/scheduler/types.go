@@ -1,6 +1,8 @@
 package scheduler
 
 type Capacity struct {
+	// Cru is the number of vcpus, the nodes overprovision their cpus so it's only checked against their cores
+	Cru    uint64
 	Memory uint64
 	Sru    uint64
 	Hru    uint64
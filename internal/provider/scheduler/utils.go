@@ -20,7 +20,8 @@ func freeCapacity(node *proxytypes.Node) Capacity {
 }
 
 func fullfils(node *nodeInfo, r *Request) bool {
-	if r.Cap.Memory > node.FreeCapacity.Memory ||
+	if r.Cap.Cru > node.Cores ||
+		r.Cap.Memory > node.FreeCapacity.Memory ||
 		r.Cap.Hru > node.FreeCapacity.Hru ||
 		r.Cap.Sru > node.FreeCapacity.Sru ||
 		(r.farmID != 0 && node.FarmID != r.farmID) ||
@@ -54,6 +54,7 @@ func TestFullfilsFail(t *testing.T) {
 	cap := freeCapacity(&node)
 	nodeInfo := nodeInfo{
 		FreeCapacity: &cap,
+		Cores:        2,
 		FarmID:       1,
 		HasIPv4:      false,
 		HasDomain:    false,
@@ -61,6 +62,7 @@ func TestFullfilsFail(t *testing.T) {
 
 	req := Request{
 		Cap: Capacity{
+			Cru:    2,
 			Memory: 3,
 			Sru:    8,
 			Hru:    3,
@@ -70,6 +72,7 @@ func TestFullfilsFail(t *testing.T) {
 		HasDomain: false,
 	}
 	violations := map[string]func(r *Request){
+		"cru":     func(r *Request) { r.Cap.Cru = 3 },
 		"mru":     func(r *Request) { r.Cap.Memory = 4 },
 		"sru":     func(r *Request) { r.Cap.Sru = 9 },
 		"hru":     func(r *Request) { r.Cap.Hru = 4 },
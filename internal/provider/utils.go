@@ -9,15 +9,6 @@ func isIn(l []uint16, i uint16) bool {
 	return false
 }
 
-func isInByte(l []byte, i byte) bool {
-	for _, x := range l {
-		if i == x {
-			return true
-		}
-	}
-	return false
-}
-
 func isInUint32(l []uint32, i uint32) bool {
 	for _, x := range l {
 		if i == x {
@@ -115,9 +115,10 @@ func validateRMBProxy(apiClient *apiClient) error {
 }
 
 func preValidate(apiClient *apiClient, sub subi.SubstrateExt) error {
-	if apiClient.use_rmb_proxy {
+	switch apiClient.rmb_transport {
+	case RMBTransportProxy:
 		return validateRMBProxy(apiClient)
-	} else {
+	default:
 		return validateRMB(apiClient, sub)
 	}
 }
@@ -0,0 +1,296 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/deployer"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/workloads"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+// VMGroupDeployer deploys vms, disks and zdbs placed on different nodes, the workloads
+// of each node are handled by a DeploymentDeployer and deployed in one deployment per node
+type VMGroupDeployer struct {
+	Id               string
+	NetworkName      string
+	Deployments      map[uint32]*DeploymentDeployer
+	NodeDeploymentID map[uint32]uint64
+
+	// order is the position of each workload in the input, used to keep the state lists ordered
+	order     map[string]int
+	APIClient *apiClient
+	ncPool    client.NodeClientCollection
+	deployer  deployer.Deployer
+}
+
+func NewVMGroupDeployer(d *schema.ResourceData, apiClient *apiClient) (VMGroupDeployer, error) {
+	nodeDeploymentIDIf := d.Get("node_deployment_id").(map[string]interface{})
+	nodeDeploymentID := make(map[uint32]uint64)
+	for node, id := range nodeDeploymentIDIf {
+		nodeInt, err := strconv.ParseUint(node, 10, 32)
+		if err != nil {
+			return VMGroupDeployer{}, errors.Wrap(err, "couldn't parse node id")
+		}
+		deploymentID := uint64(id.(int))
+		nodeDeploymentID[uint32(nodeInt)] = deploymentID
+	}
+	pool := client.NewNodeClientPool(apiClient.rmb)
+	solutionProviderVal := uint64(d.Get("solution_provider").(int))
+	var solutionProvider *uint64
+	if solutionProviderVal != 0 {
+		solutionProvider = &solutionProviderVal
+	}
+	deploymentData := DeploymentData{
+		Name:        d.Get("name").(string),
+		Type:        "vm",
+		ProjectName: d.Get("solution_type").(string),
+	}
+	deploymentDataStr, err := json.Marshal(deploymentData)
+	if err != nil {
+		log.Printf("error parsing deploymentdata: %s", err.Error())
+	}
+	g := VMGroupDeployer{
+		Id:               d.Id(),
+		NetworkName:      d.Get("network_name").(string),
+		Deployments:      make(map[uint32]*DeploymentDeployer),
+		NodeDeploymentID: nodeDeploymentID,
+		order:            make(map[string]int),
+		APIClient:        apiClient,
+		ncPool:           pool,
+		deployer:         deployer.NewDeployer(apiClient.identity, apiClient.twin_id, apiClient.grid_client, pool, true, solutionProvider, string(deploymentDataStr)),
+	}
+	for _, disk := range d.Get("disks").([]interface{}) {
+		m := disk.(map[string]interface{})
+		data := workloads.GetDiskData(m)
+		dd := g.nodeDeployment(uint32(m["node"].(int)))
+		dd.Disks = append(dd.Disks, data)
+		g.order[data.Name] = len(g.order)
+	}
+	for _, zdb := range d.Get("zdbs").([]interface{}) {
+		m := zdb.(map[string]interface{})
+		data := workloads.GetZdbData(m)
+		dd := g.nodeDeployment(uint32(m["node"].(int)))
+		dd.ZDBs = append(dd.ZDBs, data)
+		g.order[data.Name] = len(g.order)
+	}
+	for _, vm := range d.Get("vms").([]interface{}) {
+		m := vm.(map[string]interface{})
+		data := workloads.NewVMFromSchema(m).WithNetworkName(g.NetworkName)
+		dd := g.nodeDeployment(uint32(m["node"].(int)))
+		dd.VMs = append(dd.VMs, *data)
+		g.order[data.Name] = len(g.order)
+	}
+	return g, nil
+}
+
+// nodeDeployment returns the deployer of the workloads on the node
+func (g *VMGroupDeployer) nodeDeployment(node uint32) *DeploymentDeployer {
+	if dd, ok := g.Deployments[node]; ok {
+		return dd
+	}
+	network := g.APIClient.state.GetNetworkState().GetNetwork(g.NetworkName)
+	dd := &DeploymentDeployer{
+		Node:        node,
+		IPRange:     network.GetNodeSubnet(node),
+		NetworkName: g.NetworkName,
+		APIClient:   g.APIClient,
+		ncPool:      g.ncPool,
+	}
+	if id, ok := g.NodeDeploymentID[node]; ok {
+		dd.Id = fmt.Sprint(id)
+	}
+	g.Deployments[node] = dd
+	return dd
+}
+
+func (g *VMGroupDeployer) nodes() []uint32 {
+	nodes := make([]uint32, 0, len(g.Deployments))
+	for node := range g.Deployments {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	return nodes
+}
+
+func (g *VMGroupDeployer) validate() error {
+	names := make(map[string]uint32)
+	for _, node := range g.nodes() {
+		dd := g.Deployments[node]
+		if err := dd.validate(); err != nil {
+			return err
+		}
+		if len(dd.VMs) != 0 && dd.IPRange == "" {
+			return fmt.Errorf("node %d is not part of network %s", node, g.NetworkName)
+		}
+		disks := make(map[string]bool)
+		for _, disk := range dd.Disks {
+			disks[disk.Name] = true
+		}
+		for _, vm := range dd.VMs {
+			for _, mount := range vm.Mounts {
+				if !disks[mount.DiskName] {
+					return fmt.Errorf("vm %s mounts disk %s which is not on node %d", vm.Name, mount.DiskName, node)
+				}
+			}
+		}
+		for _, name := range dd.workloadNames() {
+			if other, ok := names[name]; ok {
+				return fmt.Errorf("workload name %s is used on nodes %d and %d", name, other, node)
+			}
+			names[name] = node
+		}
+	}
+	return nil
+}
+
+func (d *DeploymentDeployer) workloadNames() []string {
+	names := make([]string, 0)
+	for _, disk := range d.Disks {
+		names = append(names, disk.Name)
+	}
+	for _, zdb := range d.ZDBs {
+		names = append(names, zdb.Name)
+	}
+	for _, vm := range d.VMs {
+		names = append(names, vm.Name)
+	}
+	return names
+}
+
+func (g *VMGroupDeployer) GenerateVersionlessDeployments(ctx context.Context) (map[uint32]gridtypes.Deployment, error) {
+	deployments := make(map[uint32]gridtypes.Deployment)
+	for _, node := range g.nodes() {
+		dd := g.Deployments[node]
+		if len(dd.workloadNames()) == 0 {
+			continue
+		}
+		dls, err := dd.GenerateVersionlessDeployments(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't generate node %d deployment", node)
+		}
+		deployments[node] = dls[node]
+	}
+	return deployments, nil
+}
+
+func (g *VMGroupDeployer) Deploy(ctx context.Context, sub subi.SubstrateExt) error {
+	nodes := make([]uint32, 0)
+	for _, node := range g.nodes() {
+		if len(g.Deployments[node].VMs) != 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	if err := extendNetwork(ctx, sub, g.APIClient, g.NetworkName, nodes); err != nil {
+		return err
+	}
+	network := g.APIClient.state.GetNetworkState().GetNetwork(g.NetworkName)
+	for _, node := range nodes {
+		if g.Deployments[node].IPRange == "" {
+			g.Deployments[node].IPRange = network.GetNodeSubnet(node)
+		}
+	}
+	if err := g.validate(); err != nil {
+		return err
+	}
+	newDeployments, err := g.GenerateVersionlessDeployments(ctx)
+	if err != nil {
+		return errors.Wrap(err, "couldn't generate deployments data")
+	}
+	g.NodeDeploymentID, err = g.deployer.Deploy(ctx, sub, g.NodeDeploymentID, newDeployments)
+	if g.Id == "" && len(g.NodeDeploymentID) != 0 {
+		g.Id = uuid.New().String()
+	}
+	return err
+}
+
+func (g *VMGroupDeployer) Cancel(ctx context.Context, sub subi.SubstrateExt) (err error) {
+	g.NodeDeploymentID, err = g.deployer.Deploy(ctx, sub, g.NodeDeploymentID, map[uint32]gridtypes.Deployment{})
+	if len(g.NodeDeploymentID) == 0 {
+		g.Id = ""
+	}
+	return err
+}
+
+func (g *VMGroupDeployer) sync(ctx context.Context, sub subi.SubstrateExt, cl *apiClient) error {
+	if err := sub.DeleteInvalidContracts(g.NodeDeploymentID); err != nil {
+		return errors.Wrap(err, "couldn't sync contracts")
+	}
+	dls, err := g.deployer.GetDeploymentObjects(ctx, sub, g.NodeDeploymentID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get deployments to update local state")
+	}
+	for node, dd := range g.Deployments {
+		if _, ok := g.NodeDeploymentID[node]; !ok {
+			dd.Nullify()
+		}
+	}
+	for node, contractID := range g.NodeDeploymentID {
+		dd := g.nodeDeployment(node)
+		dd.Id = fmt.Sprint(contractID)
+		dd.syncDeployment(dls[node], cl)
+	}
+	if len(g.NodeDeploymentID) == 0 {
+		g.Id = ""
+	}
+	return nil
+}
+
+// sortByOrder orders the workloads as they are in the input, the ones that are not part of it are pushed after
+func (g *VMGroupDeployer) sortByOrder(l []interface{}) {
+	position := func(i int) int {
+		if pos, ok := g.order[l[i].(map[string]interface{})["name"].(string)]; ok {
+			return pos
+		}
+		return len(g.order)
+	}
+	sort.SliceStable(l, func(i, j int) bool {
+		return position(i) < position(j)
+	})
+}
+
+func (g *VMGroupDeployer) Marshal(r *schema.ResourceData) {
+	vms := make([]interface{}, 0)
+	disks := make([]interface{}, 0)
+	zdbs := make([]interface{}, 0)
+	for _, node := range g.nodes() {
+		dd := g.Deployments[node]
+		for _, vm := range dd.VMs {
+			m := vm.Dictify()
+			m["node"] = int(node)
+			vms = append(vms, m)
+		}
+		for _, disk := range dd.Disks {
+			m := disk.Dictify()
+			m["node"] = int(node)
+			disks = append(disks, m)
+		}
+		for _, zdb := range dd.ZDBs {
+			m := zdb.Dictify()
+			m["node"] = int(node)
+			zdbs = append(zdbs, m)
+		}
+	}
+	g.sortByOrder(vms)
+	g.sortByOrder(disks)
+	g.sortByOrder(zdbs)
+	nodeDeploymentID := make(map[string]interface{})
+	for node, id := range g.NodeDeploymentID {
+		nodeDeploymentID[fmt.Sprint(node)] = int(id)
+	}
+	r.Set("vms", vms)
+	r.Set("disks", disks)
+	r.Set("zdbs", zdbs)
+	r.Set("network_name", g.NetworkName)
+	r.Set("node_deployment_id", nodeDeploymentID)
+	r.SetId(g.Id)
+}
@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func groupVM(name string, node int, mounts ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   name,
+		"node":   node,
+		"flist":  "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+		"cpu":    1,
+		"memory": 1024,
+		"mounts": mounts,
+	}
+}
+
+func TestVMGroup(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	network := createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "net",
+		"nodes":    []interface{}{1, 2},
+		"ip_range": "10.1.0.0/16",
+	})
+	networkContracts := len(network.Get("node_deployment_id").(map[string]interface{}))
+
+	r := p.ResourcesMap["grid_vm_group"]
+	d := createTestResource(t, p, "grid_vm_group", map[string]interface{}{
+		"network_name": "net",
+		"disks": []interface{}{
+			map[string]interface{}{"name": "data", "node": 2, "size": 10},
+		},
+		"zdbs": []interface{}{
+			map[string]interface{}{"name": "zdb", "node": 1, "size": 1, "password": "secret", "mode": "user"},
+		},
+		"vms": []interface{}{
+			groupVM("b", 2, map[string]interface{}{"disk_name": "data", "mount_point": "/data"}),
+			groupVM("a", 1),
+			groupVM("c", 2),
+		},
+	})
+	assert.NotEmpty(t, d.Id())
+	assert.Len(t, d.Get("node_deployment_id"), 2)
+	assert.Equal(t, networkContracts+2, len(grid.Substrate.Contracts()))
+
+	// the input order is kept and every vm gets an ip from the subnet of its node
+	subnets := network.Get("nodes_ip_range").(map[string]interface{})
+	for idx, expected := range []struct {
+		name   string
+		subnet string
+	}{{"b", "2"}, {"a", "1"}, {"c", "2"}} {
+		vm := d.Get("vms").([]interface{})[idx].(map[string]interface{})
+		assert.Equal(t, expected.name, vm["name"])
+		_, subnet, err := net.ParseCIDR(subnets[expected.subnet].(string))
+		assert.NoError(t, err)
+		assert.True(t, subnet.Contains(net.ParseIP(vm["ip"].(string))), "%s not in %s", vm["ip"], subnet)
+	}
+	assert.NotEqual(t, d.Get("vms.0.ip"), d.Get("vms.2.ip"))
+	assert.Equal(t, 9900, d.Get("zdbs.0.port"))
+
+	// moving everything to node 2 removes the deployment on node 1
+	raw := map[string]interface{}{
+		"network_name": "net",
+		"disks": []interface{}{
+			map[string]interface{}{"name": "data", "node": 2, "size": 10},
+		},
+		"vms": []interface{}{
+			groupVM("b", 2, map[string]interface{}{"disk_name": "data", "mount_point": "/data"}),
+			groupVM("a", 2),
+		},
+	}
+	updated := schema.TestResourceDataRaw(t, r.Schema, raw)
+	updated.SetId(d.Id())
+	updated.Set("node_deployment_id", d.Get("node_deployment_id"))
+	diags := r.UpdateContext(context.Background(), updated, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Len(t, updated.Get("node_deployment_id"), 1)
+	assert.Equal(t, "a", updated.Get("vms.1.name"))
+	assert.Equal(t, 0, len(updated.Get("zdbs").([]interface{})))
+
+	diags = r.DeleteContext(context.Background(), updated, p.Meta())
+	assert.False(t, diags.HasError(), "%v", diags)
+	assert.Empty(t, updated.Id())
+	active := 0
+	for _, c := range grid.Substrate.Contracts() {
+		if !c.Deleted {
+			active++
+		}
+	}
+	assert.Equal(t, networkContracts, active)
+}
+
+func TestVMGroupValidation(t *testing.T) {
+	grid, _, _ := accGrid(t)
+	p := configureTestProvider(t, grid)
+	createTestResource(t, p, "grid_network", map[string]interface{}{
+		"name":     "net",
+		"nodes":    []interface{}{2},
+		"ip_range": "10.1.0.0/16",
+	})
+	r := p.ResourcesMap["grid_vm_group"]
+	for name, raw := range map[string]map[string]interface{}{
+		"disk on another node": {
+			"network_name": "net",
+			"disks":        []interface{}{map[string]interface{}{"name": "data", "node": 1, "size": 10}},
+			"vms":          []interface{}{groupVM("vm", 2, map[string]interface{}{"disk_name": "data", "mount_point": "/data"})},
+		},
+		"node outside the network": {
+			"network_name": "net",
+			"vms":          []interface{}{groupVM("vm", 1)},
+		},
+		"duplicate names": {
+			"network_name": "net",
+			"disks":        []interface{}{map[string]interface{}{"name": "vm", "node": 1, "size": 10}},
+			"vms":          []interface{}{groupVM("vm", 2)},
+		},
+	} {
+		d := schema.TestResourceDataRaw(t, r.Schema, raw)
+		diags := r.CreateContext(context.Background(), d, p.Meta())
+		assert.True(t, diags.HasError(), name)
+		assert.Empty(t, d.Id(), name)
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"flag"
 	"log"
-	"os"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
 	"github.com/threefoldtech/terraform-provider-grid/internal/provider"
 	"github.com/threefoldtech/terraform-provider-grid/pkg/state"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
 )
 
 // Run "go generate" to format example terraform files and generate the docs for the registry/website
@@ -44,18 +44,14 @@ func main() {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	network := determineSubstrateNetwork()
-
-	subext, err := provider.SubstrateVersion[network](provider.SUBSTRATE_URL[network]).SubstrateExt()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer subext.Close()
-	opts := &plugin.ServeOpts{ProviderFunc: provider.New(version, subext, db.GetState())}
+	// substrate connections are opened by the provider configuration
+	conns := subi.NewConnections()
+	opts := &plugin.ServeOpts{ProviderFunc: provider.New(version, conns, db.GetState())}
 
 	if debugMode {
 		// TODO: update this string with the full name of your provider as used in your configs
 		err := plugin.Debug(context.Background(), "registry.terraform.io/hashicorp/scaffolding", opts)
+		conns.Close()
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -63,19 +59,9 @@ func main() {
 	}
 
 	plugin.Serve(opts)
+	conns.Close()
 	err = db.Save()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 }
-
-func determineSubstrateNetwork() string {
-	network := os.Getenv("NETWORK")
-	if network == "" {
-		network = "dev"
-	}
-	if network != "dev" && network != "qa" && network != "test" && network != "main" {
-		log.Fatal("network must be one of dev, qa, test, or main")
-	}
-	return network
-}
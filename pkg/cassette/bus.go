@@ -0,0 +1,43 @@
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ReplayBus is an rmb client serving the calls from a cassette. Calls are matched by
+// their destination twin and command in the recorded order, the request data is ignored
+// since it carries signatures and redacted secrets
+type ReplayBus struct {
+	cassette *Cassette
+}
+
+func NewReplayBus(c *Cassette) *ReplayBus {
+	return &ReplayBus{cassette: c}
+}
+
+func (b *ReplayBus) Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	i, err := b.cassette.next(fmt.Sprintf("rmb call %s to twin %d", fn, twin), func(i *Interaction) bool {
+		return i.Kind == KindRMB && i.Twin == twin && i.Command == fn
+	})
+	if err != nil {
+		return err
+	}
+	if i.Error != "" {
+		return errors.New(i.Error)
+	}
+	// not expecting a result
+	if result == nil {
+		return nil
+	}
+	if len(i.Response) == 0 {
+		return fmt.Errorf("no response body was returned")
+	}
+	if err := json.Unmarshal(i.Response, result); err != nil {
+		return errors.Wrap(err, "failed to decode response body")
+	}
+	return nil
+}
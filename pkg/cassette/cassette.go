@@ -0,0 +1,159 @@
+// Package cassette records the rmb and grid proxy traffic of the provider to a file
+// and replays it, so a failed apply can be reproduced locally without the nodes.
+//
+// A cassette is a file of json lines, one interaction each. Secrets found in the
+// requests and responses are redacted before they are written.
+package cassette
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	KindRMB   = "rmb"
+	KindProxy = "proxy"
+)
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Kind string `json:"kind"`
+	// Twin is the destination twin of rmb calls
+	Twin uint32 `json:"twin,omitempty"`
+	// Command is the rmb command or the grid proxy client method
+	Command  string          `json:"command"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Recorder appends interactions to a cassette file as they happen, so the
+// traffic of an apply that crashed is kept
+type Recorder struct {
+	m sync.Mutex
+	f *os.File
+}
+
+// NewRecorder opens the cassette file for appending. Terraform starts the provider for
+// each operation of a command, appending keeps the traffic of the whole command
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create cassette")
+	}
+	return &Recorder{f: f}, nil
+}
+
+// Record redacts and writes an interaction
+func (r *Recorder) Record(i Interaction) error {
+	var err error
+	if i.Request, err = Redact(i.Request); err != nil {
+		return errors.Wrap(err, "couldn't redact request")
+	}
+	if i.Response, err = Redact(i.Response); err != nil {
+		return errors.Wrap(err, "couldn't redact response")
+	}
+	line, err := json.Marshal(i)
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode interaction")
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, err := r.f.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "couldn't write interaction")
+	}
+	return nil
+}
+
+// RecordCall records an rmb call, the reply is the raw data returned by the node
+func (r *Recorder) RecordCall(twin uint32, cmd string, data interface{}, reply []byte, err error) {
+	i := Interaction{Kind: KindRMB, Twin: twin, Command: cmd}
+	if data != nil {
+		bs, merr := json.Marshal(data)
+		if merr != nil {
+			logError(merr)
+			return
+		}
+		i.Request = bs
+	}
+	if len(reply) != 0 && json.Valid(reply) {
+		i.Response = reply
+	}
+	if err != nil {
+		i.Error = err.Error()
+	}
+	logError(r.Record(i))
+}
+
+func (r *Recorder) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.f.Close()
+}
+
+// Cassette is a loaded recording. Every interaction is served once, in the recorded order
+type Cassette struct {
+	m            sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// Load reads a cassette file
+func Load(path string) (*Cassette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open cassette")
+	}
+	defer f.Close()
+	c := &Cassette{}
+	scanner := bufio.NewScanner(f)
+	// deployments with many workloads don't fit the default buffer
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var i Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			return nil, errors.Wrapf(err, "invalid interaction at line %d", line)
+		}
+		c.interactions = append(c.interactions, i)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "couldn't read cassette")
+	}
+	c.used = make([]bool, len(c.interactions))
+	return c, nil
+}
+
+// next returns the first unused interaction accepted by match
+func (c *Cassette) next(desc string, match func(i *Interaction) bool) (Interaction, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for idx := range c.interactions {
+		if c.used[idx] || !match(&c.interactions[idx]) {
+			continue
+		}
+		c.used[idx] = true
+		return c.interactions[idx], nil
+	}
+	return Interaction{}, fmt.Errorf("no recorded interaction left for %s", desc)
+}
+
+// Remaining returns the number of interactions that weren't replayed
+func (c *Cassette) Remaining() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	count := 0
+	for _, used := range c.used {
+		if !used {
+			count++
+		}
+	}
+	return count
+}
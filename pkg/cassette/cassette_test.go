@@ -0,0 +1,118 @@
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	proxy "github.com/threefoldtech/grid_proxy_server/pkg/client"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/gridtest"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+func TestRedact(t *testing.T) {
+	dl := gridtypes.Deployment{
+		Workloads: []gridtypes.Workload{
+			{Name: "zdb", Type: zos.ZDBType, Data: gridtypes.MustMarshal(zos.ZDB{Size: 1, Password: "zdb-pass"})},
+			{Name: "net", Type: zos.NetworkType, Data: gridtypes.MustMarshal(zos.Network{WGPrivateKey: "wg-key"})},
+			{Name: "vm", Type: zos.ZMachineType, Data: gridtypes.MustMarshal(zos.ZMachine{Env: map[string]string{"K3S_TOKEN": "k3s-token", "SSH_KEY": "ssh-rsa"}})},
+		},
+	}
+	doc, err := json.Marshal(dl)
+	assert.NoError(t, err)
+	redacted, err := Redact(doc)
+	assert.NoError(t, err)
+	for _, secret := range []string{"zdb-pass", "wg-key", "k3s-token"} {
+		assert.NotContains(t, string(redacted), secret)
+	}
+	assert.Contains(t, string(redacted), "ssh-rsa")
+
+	// the redacted document still decodes
+	var decoded gridtypes.Deployment
+	assert.NoError(t, json.Unmarshal(redacted, &decoded))
+	data, err := decoded.Workloads[0].WorkloadData()
+	assert.NoError(t, err)
+	assert.Equal(t, Redacted, data.(*zos.ZDB).Password)
+}
+
+func TestRecordReplay(t *testing.T) {
+	grid := gridtest.NewGrid()
+	defer grid.Close()
+	public := &client.PublicConfig{Domain: "gent01.gridtest.grid.tf"}
+	node, err := grid.AddNode(grid.AddFarm("farm"), gridtypes.Capacity{CRU: 4, MRU: 8 * gridtypes.Gigabyte}, public)
+	assert.NoError(t, err)
+	identity, err := subi.NewIdentityFromSr25519Phrase("//Alice")
+	assert.NoError(t, err)
+	twin := grid.Substrate.AddTwin(identity, "", 1000000000)
+
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+	recorder, err := NewRecorder(path)
+	assert.NoError(t, err)
+	opts := client.DefaultProxyBusOptions()
+	opts.PollInterval = time.Millisecond
+	opts.Recorder = recorder
+	bus, err := client.NewProxyBus([]string{grid.URL()}, twin, grid.Substrate, identity, true, opts)
+	assert.NoError(t, err)
+	gridClient := NewRecordingClient(proxy.NewClient(grid.URL()), recorder)
+
+	ctx := context.Background()
+	recordedCfg, err := client.NewNodeClient(node.TwinID, bus).NetworkGetPublicConfig(ctx)
+	assert.NoError(t, err)
+	_, recordedErr := client.NewNodeClient(node.TwinID, bus).DeploymentGet(ctx, 42)
+	assert.Error(t, recordedErr)
+	recordedNode, err := gridClient.Node(node.ID)
+	assert.NoError(t, err)
+	up := "up"
+	recordedNodes, count, err := gridClient.Nodes(proxytypes.NodeFilter{Status: &up}, proxytypes.Limit{})
+	assert.NoError(t, err)
+	assert.NoError(t, recorder.Close())
+
+	// the nodes are gone, everything is served from the cassette
+	grid.Close()
+	c, err := Load(path)
+	assert.NoError(t, err)
+	replayed := client.NewNodeClient(node.TwinID, NewReplayBus(c))
+	cfg, err := replayed.NetworkGetPublicConfig(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, recordedCfg, cfg)
+	_, err = replayed.DeploymentGet(ctx, 42)
+	assert.EqualError(t, err, recordedErr.Error())
+
+	replayClient := NewReplayClient(c)
+	nodeInfo, err := replayClient.Node(node.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, recordedNode, nodeInfo)
+	nodes, replayedCount, err := replayClient.Nodes(proxytypes.NodeFilter{Status: &up}, proxytypes.Limit{})
+	assert.NoError(t, err)
+	assert.Equal(t, recordedNodes, nodes)
+	assert.Equal(t, count, replayedCount)
+	assert.Equal(t, 0, c.Remaining())
+
+	// unrecorded calls fail instead of reaching the network
+	_, err = replayed.NetworkListWGPorts(ctx)
+	assert.Error(t, err)
+	_, err = replayClient.Node(node.ID + 1)
+	assert.Error(t, err)
+}
+
+func TestRecorderAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+	for i := 0; i < 2; i++ {
+		recorder, err := NewRecorder(path)
+		assert.NoError(t, err)
+		recorder.RecordCall(1, "zos.statistics.get", nil, []byte(`{}`), nil)
+		assert.NoError(t, recorder.Close())
+	}
+	bs, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(bs), "\n"))
+}
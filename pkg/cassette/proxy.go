@@ -0,0 +1,183 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	proxy "github.com/threefoldtech/grid_proxy_server/pkg/client"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+)
+
+type listArgs struct {
+	Filter interface{} `json:"filter"`
+	Limit  interface{} `json:"limit"`
+}
+
+type listResult struct {
+	Result interface{} `json:"result"`
+	Count  int         `json:"count"`
+}
+
+// RecordingClient is a grid proxy client recording the calls of the wrapped client
+type RecordingClient struct {
+	client   proxy.Client
+	recorder *Recorder
+}
+
+func NewRecordingClient(client proxy.Client, recorder *Recorder) *RecordingClient {
+	return &RecordingClient{client: client, recorder: recorder}
+}
+
+func (c *RecordingClient) record(method string, args interface{}, res interface{}, err error) {
+	i := Interaction{Kind: KindProxy, Command: method}
+	if args != nil {
+		bs, merr := json.Marshal(args)
+		if merr != nil {
+			logError(merr)
+			return
+		}
+		i.Request = bs
+	}
+	if err != nil {
+		i.Error = err.Error()
+	} else if res != nil {
+		bs, merr := json.Marshal(res)
+		if merr != nil {
+			logError(merr)
+			return
+		}
+		i.Response = bs
+	}
+	logError(c.recorder.Record(i))
+}
+
+func (c *RecordingClient) Ping() error {
+	err := c.client.Ping()
+	c.record("Ping", nil, nil, err)
+	return err
+}
+
+func (c *RecordingClient) Nodes(filter proxytypes.NodeFilter, pagination proxytypes.Limit) ([]proxytypes.Node, int, error) {
+	res, count, err := c.client.Nodes(filter, pagination)
+	c.record("Nodes", listArgs{filter, pagination}, listResult{res, count}, err)
+	return res, count, err
+}
+
+func (c *RecordingClient) Farms(filter proxytypes.FarmFilter, pagination proxytypes.Limit) ([]proxytypes.Farm, int, error) {
+	res, count, err := c.client.Farms(filter, pagination)
+	c.record("Farms", listArgs{filter, pagination}, listResult{res, count}, err)
+	return res, count, err
+}
+
+func (c *RecordingClient) Contracts(filter proxytypes.ContractFilter, pagination proxytypes.Limit) ([]proxytypes.Contract, int, error) {
+	res, count, err := c.client.Contracts(filter, pagination)
+	c.record("Contracts", listArgs{filter, pagination}, listResult{res, count}, err)
+	return res, count, err
+}
+
+func (c *RecordingClient) Twins(filter proxytypes.TwinFilter, pagination proxytypes.Limit) ([]proxytypes.Twin, int, error) {
+	res, count, err := c.client.Twins(filter, pagination)
+	c.record("Twins", listArgs{filter, pagination}, listResult{res, count}, err)
+	return res, count, err
+}
+
+func (c *RecordingClient) Node(nodeID uint32) (proxytypes.NodeWithNestedCapacity, error) {
+	res, err := c.client.Node(nodeID)
+	c.record("Node", nodeID, res, err)
+	return res, err
+}
+
+func (c *RecordingClient) NodeStatus(nodeID uint32) (proxytypes.NodeStatus, error) {
+	res, err := c.client.NodeStatus(nodeID)
+	c.record("NodeStatus", nodeID, res, err)
+	return res, err
+}
+
+func (c *RecordingClient) Counters(filter proxytypes.StatsFilter) (proxytypes.Counters, error) {
+	res, err := c.client.Counters(filter)
+	c.record("Counters", filter, res, err)
+	return res, err
+}
+
+// ReplayClient is a grid proxy client serving the calls from a cassette. Calls are
+// matched by their method and arguments in the recorded order
+type ReplayClient struct {
+	cassette *Cassette
+}
+
+func NewReplayClient(c *Cassette) *ReplayClient {
+	return &ReplayClient{cassette: c}
+}
+
+// replay decodes the response of the next call of method with args into res
+func (c *ReplayClient) replay(method string, args interface{}, res interface{}) error {
+	var req json.RawMessage
+	if args != nil {
+		bs, err := json.Marshal(args)
+		if err != nil {
+			return errors.Wrap(err, "couldn't encode arguments")
+		}
+		// recorded requests went through the redaction
+		if req, err = Redact(bs); err != nil {
+			return errors.Wrap(err, "couldn't encode arguments")
+		}
+	}
+	i, err := c.cassette.next(fmt.Sprintf("grid proxy call %s(%s)", method, req), func(i *Interaction) bool {
+		return i.Kind == KindProxy && i.Command == method && bytes.Equal(i.Request, req)
+	})
+	if err != nil {
+		return err
+	}
+	if i.Error != "" {
+		return errors.New(i.Error)
+	}
+	if res == nil || len(i.Response) == 0 {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(i.Response, res), "couldn't decode recorded response")
+}
+
+func (c *ReplayClient) Ping() error {
+	return c.replay("Ping", nil, nil)
+}
+
+func (c *ReplayClient) Nodes(filter proxytypes.NodeFilter, pagination proxytypes.Limit) (res []proxytypes.Node, totalCount int, err error) {
+	list := listResult{Result: &res}
+	err = c.replay("Nodes", listArgs{filter, pagination}, &list)
+	return res, list.Count, err
+}
+
+func (c *ReplayClient) Farms(filter proxytypes.FarmFilter, pagination proxytypes.Limit) (res []proxytypes.Farm, totalCount int, err error) {
+	list := listResult{Result: &res}
+	err = c.replay("Farms", listArgs{filter, pagination}, &list)
+	return res, list.Count, err
+}
+
+func (c *ReplayClient) Contracts(filter proxytypes.ContractFilter, pagination proxytypes.Limit) (res []proxytypes.Contract, totalCount int, err error) {
+	list := listResult{Result: &res}
+	err = c.replay("Contracts", listArgs{filter, pagination}, &list)
+	return res, list.Count, err
+}
+
+func (c *ReplayClient) Twins(filter proxytypes.TwinFilter, pagination proxytypes.Limit) (res []proxytypes.Twin, totalCount int, err error) {
+	list := listResult{Result: &res}
+	err = c.replay("Twins", listArgs{filter, pagination}, &list)
+	return res, list.Count, err
+}
+
+func (c *ReplayClient) Node(nodeID uint32) (res proxytypes.NodeWithNestedCapacity, err error) {
+	err = c.replay("Node", nodeID, &res)
+	return res, err
+}
+
+func (c *ReplayClient) NodeStatus(nodeID uint32) (res proxytypes.NodeStatus, err error) {
+	err = c.replay("NodeStatus", nodeID, &res)
+	return res, err
+}
+
+func (c *ReplayClient) Counters(filter proxytypes.StatsFilter) (res proxytypes.Counters, err error) {
+	err = c.replay("Counters", filter, &res)
+	return res, err
+}
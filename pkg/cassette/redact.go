@@ -0,0 +1,64 @@
+package cassette
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// Redacted replaces the secret values in recorded interactions
+const Redacted = "REDACTED"
+
+// secretKeys are the parts of the field names holding secrets, like zdb passwords,
+// wireguard private keys or k3s tokens passed as environment variables
+var secretKeys = []string{"password", "private_key", "secret", "token", "mnemonic"}
+
+func isSecret(parent string, key string) bool {
+	key = strings.ToLower(key)
+	// qsfs encryption keys are stored as {"encryption": {"key": ...}}
+	if parent == "encryption" && key == "key" {
+		return true
+	}
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces the string values of secret fields in a json document
+func Redact(doc json.RawMessage) (json.RawMessage, error) {
+	if len(doc) == 0 {
+		return doc, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redact("", v))
+}
+
+func redact(parent string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if _, ok := value.(string); ok && isSecret(parent, key) {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = redact(strings.ToLower(key), value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redact(parent, v[i])
+		}
+	}
+	return v
+}
+
+func logError(err error) {
+	if err != nil {
+		log.Printf("couldn't record interaction: %s", err)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -30,6 +32,16 @@ type DeployerImpl struct {
 	revertOnFailure  bool
 	solutionProvider *uint64
 	deploymentData   string
+	rollingUpdate    *RollingUpdate
+}
+
+// RollingUpdate is the strategy of updating the existing deployments a few nodes at a time,
+// waiting for the updated nodes to be back before updating the next ones
+type RollingUpdate struct {
+	// MaxUnavailable is the number of nodes updated at the same time, 1 if it's not set
+	MaxUnavailable int
+	// Ready is called once the workloads of an updated deployment are ok, it blocks until the node is back in service
+	Ready func(ctx context.Context, node uint32, dl gridtypes.Deployment) error
 }
 
 func NewDeployer(
@@ -49,9 +61,26 @@ func NewDeployer(
 		revertOnFailure,
 		solutionProvider,
 		deploymentData,
+		nil,
 	}
 }
 
+// NewRollingDeployer returns a deployer updating the existing deployments with the rolling update strategy
+func NewRollingDeployer(
+	identity substrate.Identity,
+	twinID uint32,
+	gridClient proxy.Client,
+	ncPool client.NodeClientCollection,
+	revertOnFailure bool,
+	solutionProvider *uint64,
+	deploymentData string,
+	rollingUpdate RollingUpdate,
+) Deployer {
+	d := NewDeployer(identity, twinID, gridClient, ncPool, revertOnFailure, solutionProvider, deploymentData).(*DeployerImpl)
+	d.rollingUpdate = &rollingUpdate
+	return d
+}
+
 func (d *DeployerImpl) Deploy(ctx context.Context, sub subi.SubstrateExt, oldDeploymentIDs map[uint32]uint64, newDeployments map[uint32]gridtypes.Deployment) (map[uint32]uint64, error) {
 	oldDeployments, oldErr := d.GetDeploymentObjects(ctx, sub, oldDeploymentIDs)
 	if oldErr == nil {
@@ -159,92 +188,202 @@ func (d *DeployerImpl) deploy(
 	}
 
 	// updates
+	if d.rollingUpdate != nil {
+		return currentDeployments, d.rollingUpdates(ctx, sub, currentDeployments, oldDeployments, newDeployments)
+	}
 	for node, dl := range newDeployments {
 		if oldDeploymentID, ok := oldDeployments[node]; ok {
-			newDeploymentHash, err := hashDeployment(dl)
-			if err != nil {
-				return currentDeployments, errors.Wrap(err, "couldn't get deployment hash")
-			}
-
-			client, err := d.ncPool.GetNodeClient(sub, node)
-			if err != nil {
-				return currentDeployments, errors.Wrap(err, "failed to get node client")
-			}
-			oldDl, err := client.DeploymentGet(ctx, oldDeploymentID)
-			if err != nil {
-				return currentDeployments, errors.Wrap(err, "failed to get old deployment to update it")
-			}
-			oldDeploymentHash, err := hashDeployment(oldDl)
+			update, err := d.prepareUpdate(ctx, sub, node, oldDeploymentID, dl)
 			if err != nil {
-				return currentDeployments, errors.Wrap(err, "couldn't get deployment hash")
+				return currentDeployments, err
 			}
-			if oldDeploymentHash == newDeploymentHash && sameWorkloadsNames(dl, oldDl) {
+			if update == nil {
 				continue
 			}
-			oldHashes, err := constructWorkloadHashes(oldDl)
+			newWorkloadsVersions, err := d.sendUpdate(ctx, sub, update)
 			if err != nil {
-				return currentDeployments, errors.Wrap(err, "couldn't get old workloads hashes")
+				return currentDeployments, err
 			}
-			newHashes, err := constructWorkloadHashes(dl)
+			currentDeployments[node] = update.dl.ContractID
+
+			err = d.Wait(ctx, update.client, update.dl.ContractID, newWorkloadsVersions)
 			if err != nil {
-				return currentDeployments, errors.Wrap(err, "couldn't get new workloads hashes")
-			}
-			oldWorkloadsVersions := constructWorkloadVersions(oldDl)
-			newWorkloadsVersions := map[string]uint32{}
-			dl.Version = oldDl.Version + 1
-			dl.ContractID = oldDl.ContractID
-			for idx, w := range dl.Workloads {
-				newHash := newHashes[string(w.Name)]
-				oldHash, ok := oldHashes[string(w.Name)]
-				if !ok || newHash != oldHash {
-					dl.Workloads[idx].Version = dl.Version
-				} else if ok && newHash == oldHash {
-					dl.Workloads[idx].Version = oldWorkloadsVersions[string(w.Name)]
-				}
-				newWorkloadsVersions[w.Name.String()] = dl.Workloads[idx].Version
-			}
-			if err := dl.Sign(d.twinID, d.identity); err != nil {
-				return currentDeployments, errors.Wrap(err, "error signing deployment")
+				return currentDeployments, errors.Wrap(err, "error waiting deployment")
 			}
+		}
+	}
 
-			if err := dl.Valid(); err != nil {
-				return currentDeployments, errors.Wrap(err, "deployment is invalid")
-			}
+	return currentDeployments, nil
+}
 
-			log.Printf("%+v", dl)
-			hash, err := dl.ChallengeHash()
+// deploymentUpdate is a deployment to update on a node
+type deploymentUpdate struct {
+	node   uint32
+	client *client.NodeClient
+	oldDl  gridtypes.Deployment
+	dl     gridtypes.Deployment
+}
 
-			if err != nil {
-				return currentDeployments, errors.Wrap(err, "failed to create hash")
-			}
+// prepareUpdate fetches the old deployment of the node, it returns nil if the new deployment doesn't change it
+func (d *DeployerImpl) prepareUpdate(ctx context.Context, sub subi.SubstrateExt, node uint32, oldDeploymentID uint64, dl gridtypes.Deployment) (*deploymentUpdate, error) {
+	newDeploymentHash, err := hashDeployment(dl)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get deployment hash")
+	}
 
-			hashHex := hex.EncodeToString(hash)
-			log.Printf("[DEBUG] HASH: %s", hashHex)
-			// TODO: Destroy and create if publicIPCount is changed
-			// publicIPCount := countDeploymentPublicIPs(dl)
-			contractID, err := sub.UpdateNodeContract(d.identity, dl.ContractID, "", hashHex)
-			if err != nil {
-				return currentDeployments, errors.Wrap(err, "failed to update deployment")
-			}
-			dl.ContractID = contractID
-			sub, cancel := context.WithTimeout(ctx, 4*time.Minute)
-			defer cancel()
-			err = client.DeploymentUpdate(sub, dl)
+	client, err := d.ncPool.GetNodeClient(sub, node)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get node client")
+	}
+	oldDl, err := client.DeploymentGet(ctx, oldDeploymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get old deployment to update it")
+	}
+	oldDeploymentHash, err := hashDeployment(oldDl)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get deployment hash")
+	}
+	if oldDeploymentHash == newDeploymentHash && sameWorkloadsNames(dl, oldDl) {
+		return nil, nil
+	}
+	return &deploymentUpdate{node: node, client: client, oldDl: oldDl, dl: dl}, nil
+}
+
+// sendUpdate updates the contract and sends the new version of the deployment to the node,
+// it returns the versions of the workloads to wait for
+func (d *DeployerImpl) sendUpdate(ctx context.Context, sub subi.SubstrateExt, update *deploymentUpdate) (map[string]uint32, error) {
+	dl, oldDl := &update.dl, update.oldDl
+	oldHashes, err := constructWorkloadHashes(oldDl)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get old workloads hashes")
+	}
+	newHashes, err := constructWorkloadHashes(*dl)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get new workloads hashes")
+	}
+	oldWorkloadsVersions := constructWorkloadVersions(oldDl)
+	newWorkloadsVersions := map[string]uint32{}
+	dl.Version = oldDl.Version + 1
+	dl.ContractID = oldDl.ContractID
+	for idx, w := range dl.Workloads {
+		newHash := newHashes[string(w.Name)]
+		oldHash, ok := oldHashes[string(w.Name)]
+		if !ok || newHash != oldHash {
+			dl.Workloads[idx].Version = dl.Version
+		} else if ok && newHash == oldHash {
+			dl.Workloads[idx].Version = oldWorkloadsVersions[string(w.Name)]
+		}
+		newWorkloadsVersions[w.Name.String()] = dl.Workloads[idx].Version
+	}
+	if err := dl.Sign(d.twinID, d.identity); err != nil {
+		return nil, errors.Wrap(err, "error signing deployment")
+	}
+
+	if err := dl.Valid(); err != nil {
+		return nil, errors.Wrap(err, "deployment is invalid")
+	}
+
+	log.Printf("%+v", *dl)
+	hash, err := dl.ChallengeHash()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create hash")
+	}
+
+	hashHex := hex.EncodeToString(hash)
+	log.Printf("[DEBUG] HASH: %s", hashHex)
+	// TODO: Destroy and create if publicIPCount is changed
+	// publicIPCount := countDeploymentPublicIPs(dl)
+	contractID, err := sub.UpdateNodeContract(d.identity, dl.ContractID, "", hashHex)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update deployment")
+	}
+	dl.ContractID = contractID
+	subCtx, cancel := context.WithTimeout(ctx, 4*time.Minute)
+	defer cancel()
+	err = update.client.DeploymentUpdate(subCtx, *dl)
+	if err != nil {
+		// cancel previous contract
+		log.Printf("failed to send deployment update request to node %s", err)
+		return nil, errors.Wrap(err, "error sending deployment to the node")
+	}
+	return newWorkloadsVersions, nil
+}
+
+// rollingUpdates updates the changed deployments in batches of MaxUnavailable nodes ordered by node id,
+// a batch is updated once the workloads of the previous one are ok and its nodes are ready.
+// The updates of a batch are sent one after the other to keep the contract updates in order,
+// then they are waited for together. A failing batch stops the update so the next nodes are left untouched.
+// The contracts of the sent updates are set in currentDeployments like the updates done one by one
+func (d *DeployerImpl) rollingUpdates(ctx context.Context, sub subi.SubstrateExt, currentDeployments map[uint32]uint64, oldDeployments map[uint32]uint64, newDeployments map[uint32]gridtypes.Deployment) error {
+	nodes := make([]uint32, 0)
+	for node := range newDeployments {
+		if _, ok := oldDeployments[node]; ok {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	updates := make([]*deploymentUpdate, 0)
+	for _, node := range nodes {
+		update, err := d.prepareUpdate(ctx, sub, node, oldDeployments[node], newDeployments[node])
+		if err != nil {
+			return err
+		}
+		if update != nil {
+			updates = append(updates, update)
+		}
+	}
+
+	batchSize := d.rollingUpdate.MaxUnavailable
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	for start := 0; start < len(updates); start += batchSize {
+		end := start + batchSize
+		if end > len(updates) {
+			end = len(updates)
+		}
+		batch := updates[start:end]
+		versions := make([]map[string]uint32, len(batch))
+		for idx, update := range batch {
+			var err error
+			versions[idx], err = d.sendUpdate(ctx, sub, update)
 			if err != nil {
-				// cancel previous contract
-				log.Printf("failed to send deployment update request to node %s", err)
-				return currentDeployments, errors.Wrap(err, "error sending deployment to the node")
+				return errors.Wrapf(err, "couldn't update the deployment on node %d", update.node)
 			}
-			currentDeployments[node] = dl.ContractID
-
-			err = d.Wait(ctx, client, dl.ContractID, newWorkloadsVersions)
+			currentDeployments[update.node] = update.dl.ContractID
+		}
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for idx, update := range batch {
+			wg.Add(1)
+			go func(idx int, update *deploymentUpdate) {
+				defer wg.Done()
+				errs[idx] = d.waitReady(ctx, update, versions[idx])
+			}(idx, update)
+		}
+		wg.Wait()
+		for _, err := range errs {
 			if err != nil {
-				return currentDeployments, errors.Wrap(err, "error waiting deployment")
+				return err
 			}
 		}
 	}
+	return nil
+}
 
-	return currentDeployments, nil
+// waitReady waits for the workloads of the updated deployment to be ok then for its node to be ready
+func (d *DeployerImpl) waitReady(ctx context.Context, update *deploymentUpdate, versions map[string]uint32) error {
+	if err := d.Wait(ctx, update.client, update.dl.ContractID, versions); err != nil {
+		return errors.Wrapf(err, "error waiting deployment on node %d", update.node)
+	}
+	if d.rollingUpdate.Ready == nil {
+		return nil
+	}
+	if err := d.rollingUpdate.Ready(ctx, update.node, update.dl); err != nil {
+		return errors.Wrapf(err, "node %d isn't ready after its update", update.node)
+	}
+	return nil
 }
 
 type Progress struct {
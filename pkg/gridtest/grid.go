@@ -0,0 +1,415 @@
+// Package gridtest provides an in process fake of the grid: a chain, zos nodes
+// answering rmb calls and a grid proxy serving the nodes and farms. It lets the
+// provider and the deployer be exercised offline.
+//
+// The provider is pointed to a grid with the custom network, the Substrate as its
+// connector and the proxy rmb transport using the grid URL as the rmb proxy url.
+package gridtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/go-rmb"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+// Grid is a fake grid served over http
+type Grid struct {
+	Substrate *Substrate
+
+	m       sync.Mutex
+	farms   map[uint32]*Farm
+	nodes   map[uint32]*Node
+	replies map[string]rmb.Message
+	server  *httptest.Server
+}
+
+// NewGrid starts a grid with an empty chain, it must be closed after use
+func NewGrid() *Grid {
+	g := &Grid{
+		Substrate: NewSubstrate(),
+		farms:     make(map[uint32]*Farm),
+		nodes:     make(map[uint32]*Node),
+		replies:   make(map[string]rmb.Message),
+	}
+	g.Substrate.canceled = g.canceled
+	g.server = httptest.NewServer(g.routes())
+	return g
+}
+
+// URL is the base url of the rmb and grid proxy endpoints
+func (g *Grid) URL() string {
+	return g.server.URL + "/"
+}
+
+func (g *Grid) Close() {
+	g.server.Close()
+}
+
+// AddFarm creates a farm owning the given public ips, each ip is a cidr and its gateway
+func (g *Grid) AddFarm(name string, ips ...proxytypes.PublicIP) *Farm {
+	g.m.Lock()
+	defer g.m.Unlock()
+	id := uint32(len(g.farms) + 1)
+	farm := &Farm{ID: id, Name: name}
+	for i, ip := range ips {
+		ip.ID = fmt.Sprintf("%d-%d", id, i)
+		ip.FarmID = fmt.Sprint(id)
+		farm.ips = append(farm.ips, ip)
+	}
+	g.farms[id] = farm
+	return farm
+}
+
+// AddNode registers a node and its twin on the farm, public is nil for hidden nodes
+func (g *Grid) AddNode(farm *Farm, total gridtypes.Capacity, public *client.PublicConfig) (*Node, error) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	id := uint32(len(g.nodes) + 1)
+	identity, err := subi.NewIdentityFromEd25519Phrase(fmt.Sprintf("//Node%d", id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create node identity")
+	}
+	node := &Node{
+		ID:           id,
+		Identity:     identity,
+		Total:        total,
+		PublicConfig: public,
+		farm:         farm,
+		sub:          g.Substrate,
+		deployments:  make(map[uint64]*gridtypes.Deployment),
+		faults:       make(map[string]string),
+		ports:        make(map[uint64][]uint16),
+	}
+	node.TwinID = g.Substrate.AddTwin(identity, node.yggIP().String(), 0)
+	g.Substrate.addNode(id, node.TwinID)
+	g.nodes[id] = node
+	return node, nil
+}
+
+// Node returns the node with the given id
+func (g *Grid) Node(id uint32) *Node {
+	g.m.Lock()
+	defer g.m.Unlock()
+	return g.nodes[id]
+}
+
+func (g *Grid) nodeByTwin(twin uint32) *Node {
+	g.m.Lock()
+	defer g.m.Unlock()
+	for _, node := range g.nodes {
+		if node.TwinID == twin {
+			return node
+		}
+	}
+	return nil
+}
+
+// canceled decommissions the deployment of a canceled node contract
+func (g *Grid) canceled(contract *Contract) {
+	if node := g.Node(contract.Node); node != nil {
+		node.decommission(contract.ID)
+	}
+}
+
+func (g *Grid) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, "gridtest")
+	})
+	mux.HandleFunc("/nodes", g.listNodes)
+	mux.HandleFunc("/nodes/", g.getNode)
+	mux.HandleFunc("/farms", g.listFarms)
+	mux.HandleFunc("/contracts", g.listContracts)
+	mux.HandleFunc("/twin/", g.twin)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// sortedNodes returns the nodes ordered by id
+func (g *Grid) sortedNodes() []*Node {
+	g.m.Lock()
+	defer g.m.Unlock()
+	nodes := make([]*Node, 0, len(g.nodes))
+	for id := uint32(1); id <= uint32(len(g.nodes)); id++ {
+		nodes = append(nodes, g.nodes[id])
+	}
+	return nodes
+}
+
+// matches applies the node filters the provider uses
+func matches(info proxytypes.NodeWithNestedCapacity, query map[string][]string) bool {
+	get := func(key string) string {
+		if v, ok := query[key]; ok && len(v) != 0 {
+			return v[0]
+		}
+		return ""
+	}
+	free := func(key string, total, used gridtypes.Unit) bool {
+		v := get(key)
+		if v == "" {
+			return true
+		}
+		min, err := strconv.ParseUint(v, 10, 64)
+		return err == nil && uint64(total-used) >= min
+	}
+	if status := get("status"); status != "" && status != info.Status {
+		return false
+	}
+	if v := get("ipv4"); v != "" && (v == "true") != (info.PublicConfig.Ipv4 != "") {
+		return false
+	}
+	if v := get("domain"); v != "" && (v == "true") != (info.PublicConfig.Domain != "") {
+		return false
+	}
+	if v := get("farm_ids"); v != "" {
+		found := false
+		for _, id := range strings.Split(strings.Trim(v, "[]"), ",") {
+			if strings.TrimSpace(id) == fmt.Sprint(info.FarmID) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	total, used := info.Capacity.Total, info.Capacity.Used
+	return free("free_mru", total.MRU, used.MRU) &&
+		free("free_sru", total.SRU, used.SRU) &&
+		free("free_hru", total.HRU, used.HRU)
+}
+
+func (g *Grid) listNodes(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	res := []proxytypes.Node{}
+	for _, node := range g.sortedNodes() {
+		info := node.info()
+		if !matches(info, query) {
+			continue
+		}
+		res = append(res, proxytypes.Node{
+			ID:             info.ID,
+			NodeID:         info.NodeID,
+			FarmID:         info.FarmID,
+			TwinID:         info.TwinID,
+			TotalResources: info.Capacity.Total,
+			UsedResources:  info.Capacity.Used,
+			PublicConfig:   info.PublicConfig,
+			Status:         info.Status,
+		})
+	}
+	w.Header().Set("Count", fmt.Sprint(len(res)))
+	writeJSON(w, http.StatusOK, paginate(res, query))
+}
+
+// paginate returns the requested page of the nodes, all of them if no size is given
+func paginate(nodes []proxytypes.Node, query url.Values) []proxytypes.Node {
+	size, err := strconv.Atoi(query.Get("size"))
+	if err != nil || size <= 0 {
+		return nodes
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	start, end := (page-1)*size, page*size
+	if start >= len(nodes) {
+		return []proxytypes.Node{}
+	}
+	if end > len(nodes) {
+		end = len(nodes)
+	}
+	return nodes[start:end]
+}
+
+func (g *Grid) getNode(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/")
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid node id"))
+		return
+	}
+	node := g.Node(uint32(id))
+	if node == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("node %d not found", id))
+		return
+	}
+	info := node.info()
+	if len(parts) > 1 && parts[1] == "status" {
+		writeJSON(w, http.StatusOK, proxytypes.NodeStatus{Status: info.Status})
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (g *Grid) listFarms(w http.ResponseWriter, r *http.Request) {
+	g.m.Lock()
+	farms := make([]*Farm, 0, len(g.farms))
+	for id := uint32(1); id <= uint32(len(g.farms)); id++ {
+		farms = append(farms, g.farms[id])
+	}
+	g.m.Unlock()
+	filter := r.URL.Query().Get("farm_id")
+	name := r.URL.Query().Get("name")
+	res := []proxytypes.Farm{}
+	for _, farm := range farms {
+		if filter != "" && filter != fmt.Sprint(farm.ID) {
+			continue
+		}
+		if name != "" && name != farm.Name {
+			continue
+		}
+		res = append(res, farm.info())
+	}
+	w.Header().Set("Count", fmt.Sprint(len(res)))
+	writeJSON(w, http.StatusOK, res)
+}
+
+// listContracts serves the node and name contracts of the chain, filtered by twin, node,
+// type and state
+func (g *Grid) listContracts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	res := []proxytypes.Contract{}
+	for _, c := range g.Substrate.Contracts() {
+		contract := proxytypes.Contract{
+			ContractID: uint(c.ID),
+			TwinID:     uint(c.Twin),
+			State:      "Created",
+			Type:       "node",
+			Details: proxytypes.NodeContractDetails{
+				NodeID:            uint(c.Node),
+				DeploymentData:    c.Body,
+				DeploymentHash:    c.Hash,
+				NumberOfPublicIps: uint(c.PublicIPs),
+			},
+		}
+		if c.Deleted {
+			contract.State = "Deleted"
+		}
+		if c.Node == 0 {
+			contract.Type = "name"
+			contract.Details = proxytypes.NameContractDetails{Name: c.Name}
+		}
+		if v := query.Get("twin_id"); v != "" && v != fmt.Sprint(c.Twin) {
+			continue
+		}
+		if v := query.Get("node_id"); v != "" && v != fmt.Sprint(c.Node) {
+			continue
+		}
+		if v := query.Get("type"); v != "" && v != contract.Type {
+			continue
+		}
+		if v := query.Get("state"); v != "" && v != contract.State {
+			continue
+		}
+		res = append(res, contract)
+	}
+	w.Header().Set("Count", fmt.Sprint(len(res)))
+	writeJSON(w, http.StatusOK, res)
+}
+
+// twin implements the rmb proxy, requests are answered right away and their
+// replies are kept until they are polled
+func (g *Grid) twin(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/twin/"), "/")
+	twin, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid twin id"))
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1:
+		g.request(w, r, uint32(twin))
+	case r.Method == http.MethodGet && len(parts) == 2:
+		g.m.Lock()
+		reply, ok := g.replies[parts[1]]
+		delete(g.replies, parts[1])
+		g.m.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusOK, []rmb.Message{})
+			return
+		}
+		writeJSON(w, http.StatusOK, []rmb.Message{reply})
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (g *Grid) request(w http.ResponseWriter, r *http.Request, twin uint32) {
+	var msg rmb.Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid message"))
+		return
+	}
+	node := g.nodeByTwin(twin)
+	if node == nil || node.isDown() {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("twin %d is not reachable", twin))
+		return
+	}
+	pk, err := g.Substrate.GetTwinPK(uint32(msg.TwinSrc))
+	if err == nil {
+		err = msg.Verify(pk)
+	}
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, errors.Wrap(err, "invalid message signature"))
+		return
+	}
+	reply := rmb.Message{
+		Version:    msg.Version,
+		UID:        uuid.NewString(),
+		Command:    msg.Command,
+		Expiration: msg.Expiration,
+		TwinSrc:    int(twin),
+		TwinDst:    []int{msg.TwinSrc},
+		Retqueue:   uuid.NewString(),
+		Epoch:      time.Now().Unix(),
+		Proxy:      true,
+	}
+	data, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid message data"))
+		return
+	}
+	result, err := node.handle(uint32(msg.TwinSrc), msg.Command, data)
+	if err != nil {
+		reply.Err = err.Error()
+	} else if result != nil {
+		bs, err := json.Marshal(result)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errors.Wrap(err, "failed to encode reply"))
+			return
+		}
+		reply.Data = base64.StdEncoding.EncodeToString(bs)
+	}
+	if err := reply.Sign(node.Identity); err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "failed to sign reply"))
+		return
+	}
+	g.m.Lock()
+	g.replies[reply.Retqueue] = reply
+	g.m.Unlock()
+	writeJSON(w, http.StatusOK, client.ProxyResponse{Retqueue: reply.Retqueue})
+}
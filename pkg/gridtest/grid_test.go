@@ -0,0 +1,179 @@
+package gridtest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	proxy "github.com/threefoldtech/grid_proxy_server/pkg/client"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/deployer"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+func testGrid(t *testing.T) (*Grid, *Node, deployer.Deployer, uint32) {
+	grid := NewGrid()
+	t.Cleanup(grid.Close)
+	farm := grid.AddFarm("farm", proxytypes.PublicIP{IP: "185.206.122.33/24", Gateway: "185.206.122.1"})
+	node, err := grid.AddNode(farm, gridtypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 512 * gridtypes.Gigabyte}, nil)
+	assert.NoError(t, err)
+
+	identity, twin, pool := testTwin(t, grid)
+	d := deployer.NewDeployer(identity, twin, proxy.NewClient(grid.URL()), pool, true, nil, "")
+	return grid, node, d, twin
+}
+
+func testTwin(t *testing.T, grid *Grid) (subi.Identity, uint32, *client.NodeClientPool) {
+	identity, err := subi.NewIdentityFromSr25519Phrase("//Alice")
+	assert.NoError(t, err)
+	twin := grid.Substrate.AddTwin(identity, "", 1000000000)
+	opts := client.DefaultProxyBusOptions()
+	opts.PollInterval = time.Millisecond
+	opts.MaxPollInterval = 10 * time.Millisecond
+	bus, err := client.NewProxyBus([]string{grid.URL()}, twin, grid.Substrate, identity, true, opts)
+	assert.NoError(t, err)
+	return identity, twin, client.NewNodeClientPool(bus)
+}
+
+func diskDeployment(twin uint32, size gridtypes.Unit) gridtypes.Deployment {
+	return gridtypes.Deployment{
+		TwinID: twin,
+		Workloads: []gridtypes.Workload{{
+			Name: "disk",
+			Type: zos.ZMountType,
+			Data: gridtypes.MustMarshal(zos.ZMount{Size: size}),
+		}},
+		SignatureRequirement: gridtypes.SignatureRequirement{
+			WeightRequired: 1,
+			Requests:       []gridtypes.SignatureRequest{{TwinID: twin, Weight: 1}},
+		},
+	}
+}
+
+func TestDeployLifecycle(t *testing.T) {
+	grid, node, d, twin := testGrid(t)
+	ctx := context.Background()
+
+	dls, err := d.Deploy(ctx, grid.Substrate, nil, map[uint32]gridtypes.Deployment{node.ID: diskDeployment(twin, gridtypes.Gigabyte)})
+	assert.NoError(t, err)
+	contractID := dls[node.ID]
+	dl, ok := node.Deployment(contractID)
+	assert.True(t, ok)
+	assert.Equal(t, gridtypes.StateOk, dl.Workloads[0].Result.State)
+	assert.Equal(t, gridtypes.Gigabyte, node.info().Capacity.Used.SRU)
+
+	dls, err = d.Deploy(ctx, grid.Substrate, dls, map[uint32]gridtypes.Deployment{node.ID: diskDeployment(twin, 2*gridtypes.Gigabyte)})
+	assert.NoError(t, err)
+	assert.Equal(t, contractID, dls[node.ID])
+	dl, _ = node.Deployment(contractID)
+	assert.Equal(t, uint32(1), dl.Version)
+	assert.Equal(t, 2*gridtypes.Gigabyte, node.info().Capacity.Used.SRU)
+
+	dls, err = d.Deploy(ctx, grid.Substrate, dls, map[uint32]gridtypes.Deployment{})
+	assert.NoError(t, err)
+	assert.Empty(t, dls)
+	valid, err := grid.Substrate.IsValidContract(contractID)
+	assert.NoError(t, err)
+	assert.False(t, valid)
+	dl, _ = node.Deployment(contractID)
+	assert.Equal(t, gridtypes.StateDeleted, dl.Workloads[0].Result.State)
+}
+
+func TestDeployFailureIsReverted(t *testing.T) {
+	grid, node, d, twin := testGrid(t)
+	node.Fail("disk", "no space left")
+
+	_, err := d.Deploy(context.Background(), grid.Substrate, nil, map[uint32]gridtypes.Deployment{node.ID: diskDeployment(twin, gridtypes.Gigabyte)})
+	assert.ErrorContains(t, err, "no space left")
+	contracts := grid.Substrate.Contracts()
+	assert.Len(t, contracts, 1)
+	assert.True(t, contracts[0].Deleted)
+}
+
+func TestDeployValidatesCapacity(t *testing.T) {
+	grid, node, d, twin := testGrid(t)
+
+	_, err := d.Deploy(context.Background(), grid.Substrate, nil, map[uint32]gridtypes.Deployment{node.ID: diskDeployment(twin, 2048*gridtypes.Gigabyte)})
+	assert.ErrorContains(t, err, "doesn't have enough resources")
+	assert.Empty(t, grid.Substrate.Contracts())
+}
+
+func TestDownNodeIsUnreachable(t *testing.T) {
+	grid, node, d, twin := testGrid(t)
+	node.SetDown(true)
+
+	_, err := d.Deploy(context.Background(), grid.Substrate, nil, map[uint32]gridtypes.Deployment{node.ID: diskDeployment(twin, gridtypes.Gigabyte)})
+	assert.Error(t, err)
+	assert.Equal(t, "down", node.info().Status)
+}
+
+func TestRollingUpdate(t *testing.T) {
+	grid := NewGrid()
+	t.Cleanup(grid.Close)
+	farm := grid.AddFarm("farm")
+	nodes := make([]*Node, 0)
+	for i := 0; i < 3; i++ {
+		node, err := grid.AddNode(farm, gridtypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 512 * gridtypes.Gigabyte}, nil)
+		assert.NoError(t, err)
+		nodes = append(nodes, node)
+	}
+	identity, twin, pool := testTwin(t, grid)
+	deployments := func(size gridtypes.Unit) map[uint32]gridtypes.Deployment {
+		dls := make(map[uint32]gridtypes.Deployment)
+		for _, node := range nodes {
+			dls[node.ID] = diskDeployment(twin, size)
+		}
+		return dls
+	}
+	version := func(node *Node, contracts map[uint32]uint64) uint32 {
+		dl, ok := node.Deployment(contracts[node.ID])
+		assert.True(t, ok)
+		return dl.Version
+	}
+	ctx := context.Background()
+	contracts, err := deployer.NewDeployer(identity, twin, proxy.NewClient(grid.URL()), pool, true, nil, "").
+		Deploy(ctx, grid.Substrate, nil, deployments(gridtypes.Gigabyte))
+	assert.NoError(t, err)
+
+	// the first two nodes are updated together, the last one once they are ready
+	var mu sync.Mutex
+	ready := make([]uint32, 0)
+	d := deployer.NewRollingDeployer(identity, twin, proxy.NewClient(grid.URL()), pool, true, nil, "", deployer.RollingUpdate{
+		MaxUnavailable: 2,
+		Ready: func(ctx context.Context, node uint32, dl gridtypes.Deployment) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if node != nodes[2].ID {
+				assert.Equal(t, uint32(0), version(nodes[2], contracts))
+			}
+			ready = append(ready, node)
+			return nil
+		},
+	})
+	contracts, err = d.Deploy(ctx, grid.Substrate, contracts, deployments(2*gridtypes.Gigabyte))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []uint32{nodes[0].ID, nodes[1].ID}, ready[:2])
+	assert.Equal(t, nodes[2].ID, ready[2])
+	for _, node := range nodes {
+		assert.Equal(t, uint32(1), version(node, contracts))
+	}
+
+	// a node that isn't ready stops the update before the next nodes
+	d = deployer.NewRollingDeployer(identity, twin, proxy.NewClient(grid.URL()), pool, false, nil, "", deployer.RollingUpdate{
+		MaxUnavailable: 1,
+		Ready: func(ctx context.Context, node uint32, dl gridtypes.Deployment) error {
+			return errors.New("node didn't rejoin")
+		},
+	})
+	_, err = d.Deploy(ctx, grid.Substrate, contracts, deployments(3*gridtypes.Gigabyte))
+	assert.ErrorContains(t, err, "node didn't rejoin")
+	assert.Equal(t, uint32(2), version(nodes[0], contracts))
+	assert.Equal(t, uint32(1), version(nodes[1], contracts))
+	assert.Equal(t, uint32(1), version(nodes[2], contracts))
+}
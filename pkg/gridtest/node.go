@@ -0,0 +1,468 @@
+package gridtest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	proxytypes "github.com/threefoldtech/grid_proxy_server/pkg/types"
+	client "github.com/threefoldtech/terraform-provider-grid/internal/node"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+// Farm holds the public ips that nodes hand out to public ip workloads
+type Farm struct {
+	ID     uint32
+	Name   string
+	TwinID uint32
+
+	m   sync.Mutex
+	ips []proxytypes.PublicIP
+}
+
+// reserve assigns a free ip of the farm to the contract
+func (f *Farm) reserve(contract uint64) (proxytypes.PublicIP, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for i := range f.ips {
+		if f.ips[i].ContractID == int(contract) {
+			return f.ips[i], nil
+		}
+	}
+	for i := range f.ips {
+		if f.ips[i].ContractID == 0 {
+			f.ips[i].ContractID = int(contract)
+			return f.ips[i], nil
+		}
+	}
+	return proxytypes.PublicIP{}, fmt.Errorf("farm %d has no free public ips", f.ID)
+}
+
+func (f *Farm) release(contract uint64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for i := range f.ips {
+		if f.ips[i].ContractID == int(contract) {
+			f.ips[i].ContractID = 0
+		}
+	}
+}
+
+func (f *Farm) info() proxytypes.Farm {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return proxytypes.Farm{
+		Name:      f.Name,
+		FarmID:    int(f.ID),
+		TwinID:    int(f.TwinID),
+		PublicIps: append([]proxytypes.PublicIP{}, f.ips...),
+	}
+}
+
+// Node is a fake zos node. Deployments are checked against their chain contract and
+// their workloads move from init to ok, or to error if a fault is set, on the first
+// poll of their changes
+type Node struct {
+	ID       uint32
+	TwinID   uint32
+	Identity subi.Identity
+	// Total capacity reported to the grid proxy
+	Total gridtypes.Capacity
+	// PublicConfig is nil for nodes without a public interface
+	PublicConfig *client.PublicConfig
+
+	farm *Farm
+	sub  *Substrate
+
+	m           sync.Mutex
+	down        bool
+	deployments map[uint64]*gridtypes.Deployment
+	faults      map[string]string
+	ports       map[uint64][]uint16
+}
+
+type contractArgs struct {
+	ContractID uint64 `json:"contract_id"`
+}
+
+type keyGetter struct {
+	sub *Substrate
+}
+
+func (k keyGetter) GetKey(twin uint32) ([]byte, error) {
+	return k.sub.GetTwinPK(twin)
+}
+
+// Fail makes the workloads with the given name end in the error state with msg
+func (n *Node) Fail(workload string, msg string) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.faults[workload] = msg
+}
+
+// SetDown makes the node stop answering rmb calls and report a down status
+func (n *Node) SetDown(down bool) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.down = down
+}
+
+func (n *Node) isDown() bool {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return n.down
+}
+
+// Deployment returns a copy of the deployment of the contract
+func (n *Node) Deployment(contractID uint64) (gridtypes.Deployment, bool) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	dl, ok := n.deployments[contractID]
+	if !ok {
+		return gridtypes.Deployment{}, false
+	}
+	return *dl, true
+}
+
+// used sums the capacity of the live deployments
+func (n *Node) used() gridtypes.Capacity {
+	n.m.Lock()
+	defer n.m.Unlock()
+	var used gridtypes.Capacity
+	for _, dl := range n.deployments {
+		for _, wl := range dl.Workloads {
+			if wl.Result.State == gridtypes.StateDeleted {
+				continue
+			}
+			c, err := wl.Capacity()
+			if err != nil {
+				continue
+			}
+			used.Add(&c)
+		}
+	}
+	return used
+}
+
+func (n *Node) info() proxytypes.NodeWithNestedCapacity {
+	used := n.used()
+	status := "up"
+	if n.isDown() {
+		status = "down"
+	}
+	info := proxytypes.NodeWithNestedCapacity{
+		ID:     fmt.Sprintf("node-%d", n.ID),
+		NodeID: int(n.ID),
+		FarmID: int(n.farm.ID),
+		TwinID: int(n.TwinID),
+		Capacity: proxytypes.CapacityResult{
+			Total: proxytypes.Capacity{CRU: n.Total.CRU, SRU: n.Total.SRU, HRU: n.Total.HRU, MRU: n.Total.MRU},
+			Used:  proxytypes.Capacity{CRU: used.CRU, SRU: used.SRU, HRU: used.HRU, MRU: used.MRU},
+		},
+		Status: status,
+	}
+	if n.PublicConfig != nil {
+		info.PublicConfig = proxytypes.PublicConfig{
+			Domain: n.PublicConfig.Domain,
+			Ipv4:   n.PublicConfig.IPv4.String(),
+			Ipv6:   n.PublicConfig.IPv6.String(),
+		}
+		if n.PublicConfig.GW4 != nil {
+			info.PublicConfig.Gw4 = n.PublicConfig.GW4.String()
+		}
+		if n.PublicConfig.GW6 != nil {
+			info.PublicConfig.Gw6 = n.PublicConfig.GW6.String()
+		}
+	}
+	return info
+}
+
+// handle runs an rmb command sent by twin and returns the reply data
+func (n *Node) handle(twin uint32, cmd string, data []byte) (interface{}, error) {
+	switch cmd {
+	case "zos.deployment.deploy":
+		return nil, n.deploy(twin, data)
+	case "zos.deployment.update":
+		return nil, n.update(twin, data)
+	case "zos.deployment.get":
+		dl, err := n.get(twin, data)
+		if err != nil {
+			return nil, err
+		}
+		return dl, nil
+	case "zos.deployment.changes":
+		return n.changes(twin, data)
+	case "zos.deployment.delete":
+		return nil, n.delete(twin, data)
+	case "zos.statistics.get":
+		return map[string]gridtypes.Capacity{"total": n.Total, "used": n.used()}, nil
+	case "zos.network.list_wg_ports":
+		return n.wgPorts(), nil
+	case "zos.network.interfaces":
+		return map[string][]net.IP{"ygg0": {n.yggIP()}}, nil
+	case "zos.network.list_public_ips":
+		return []string{}, nil
+	case "zos.network.public_config_get":
+		if n.PublicConfig == nil {
+			return nil, errors.New("no public config found")
+		}
+		return n.PublicConfig, nil
+	case "zos.system.hypervisor":
+		return "kvm", nil
+	}
+	return nil, fmt.Errorf("unknown command %s", cmd)
+}
+
+func (n *Node) yggIP() net.IP {
+	return net.ParseIP(fmt.Sprintf("300:%x::1", n.ID))
+}
+
+func (n *Node) wgPorts() []uint16 {
+	n.m.Lock()
+	defer n.m.Unlock()
+	ports := []uint16{}
+	for _, p := range n.ports {
+		ports = append(ports, p...)
+	}
+	return ports
+}
+
+// check verifies that the deployment matches its contract and is signed by its twin
+func (n *Node) check(twin uint32, dl *gridtypes.Deployment) error {
+	if dl.TwinID != twin {
+		return fmt.Errorf("deployment twin %d doesn't match the sender %d", dl.TwinID, twin)
+	}
+	if err := dl.Valid(); err != nil {
+		return errors.Wrap(err, "invalid deployment")
+	}
+	c, err := n.sub.GetContract(dl.ContractID)
+	if err != nil {
+		return errors.Wrapf(err, "contract %d", dl.ContractID)
+	}
+	contract := c.(*Contract)
+	if contract.Deleted || contract.Node != n.ID || contract.Twin != twin {
+		return fmt.Errorf("contract %d is not an active contract of twin %d on node %d", dl.ContractID, twin, n.ID)
+	}
+	hash, err := dl.ChallengeHash()
+	if err != nil {
+		return errors.Wrap(err, "failed to compute deployment hash")
+	}
+	if hex.EncodeToString(hash) != contract.Hash {
+		return errors.New("deployment hash doesn't match the contract hash")
+	}
+	if err := dl.Verify(keyGetter{n.sub}); err != nil {
+		return errors.Wrap(err, "failed to verify deployment signature")
+	}
+	return nil
+}
+
+func (n *Node) deploy(twin uint32, data []byte) error {
+	var dl gridtypes.Deployment
+	if err := json.Unmarshal(data, &dl); err != nil {
+		return errors.Wrap(err, "invalid deployment")
+	}
+	if err := n.check(twin, &dl); err != nil {
+		return err
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	if _, ok := n.deployments[dl.ContractID]; ok {
+		return fmt.Errorf("deployment with contract %d already exists", dl.ContractID)
+	}
+	for i := range dl.Workloads {
+		dl.Workloads[i].Result = gridtypes.Result{Created: gridtypes.Now(), State: gridtypes.StateInit}
+	}
+	n.deployments[dl.ContractID] = &dl
+	n.reservePorts(&dl)
+	return nil
+}
+
+func (n *Node) update(twin uint32, data []byte) error {
+	var dl gridtypes.Deployment
+	if err := json.Unmarshal(data, &dl); err != nil {
+		return errors.Wrap(err, "invalid deployment")
+	}
+	if err := n.check(twin, &dl); err != nil {
+		return err
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	old, ok := n.deployments[dl.ContractID]
+	if !ok {
+		return fmt.Errorf("deployment with contract %d not found", dl.ContractID)
+	}
+	if dl.Version <= old.Version {
+		return fmt.Errorf("deployment version %d must be greater than %d", dl.Version, old.Version)
+	}
+	results := make(map[gridtypes.Name]gridtypes.Result)
+	for _, wl := range old.Workloads {
+		results[wl.Name] = wl.Result
+	}
+	for i, wl := range dl.Workloads {
+		res, ok := results[wl.Name]
+		if !ok || wl.Version == dl.Version {
+			res = gridtypes.Result{Created: gridtypes.Now(), State: gridtypes.StateInit}
+		}
+		dl.Workloads[i].Result = res
+	}
+	n.deployments[dl.ContractID] = &dl
+	n.reservePorts(&dl)
+	return nil
+}
+
+// reservePorts keeps the wireguard ports of the network workloads taken, the lock must be held
+func (n *Node) reservePorts(dl *gridtypes.Deployment) {
+	var ports []uint16
+	for _, wl := range dl.Workloads {
+		if wl.Type != zos.NetworkType {
+			continue
+		}
+		data, err := wl.WorkloadData()
+		if err != nil {
+			continue
+		}
+		ports = append(ports, data.(*zos.Network).WGListenPort)
+	}
+	n.ports[dl.ContractID] = ports
+}
+
+// owned returns the deployment of the contract in the args if it belongs to twin, the lock must be held
+func (n *Node) owned(twin uint32, data []byte) (*gridtypes.Deployment, error) {
+	var args contractArgs
+	if err := json.Unmarshal(data, &args); err != nil {
+		return nil, errors.Wrap(err, "invalid arguments")
+	}
+	dl, ok := n.deployments[args.ContractID]
+	if !ok || dl.TwinID != twin {
+		return nil, fmt.Errorf("deployment with contract %d not found", args.ContractID)
+	}
+	return dl, nil
+}
+
+func (n *Node) get(twin uint32, data []byte) (gridtypes.Deployment, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	dl, err := n.owned(twin, data)
+	if err != nil {
+		return gridtypes.Deployment{}, err
+	}
+	return *dl, nil
+}
+
+func (n *Node) changes(twin uint32, data []byte) ([]gridtypes.Workload, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	dl, err := n.owned(twin, data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dl.Workloads {
+		wl := &dl.Workloads[i]
+		if wl.Result.State == gridtypes.StateInit {
+			wl.Result = n.provision(dl, wl)
+		}
+	}
+	return append([]gridtypes.Workload{}, dl.Workloads...), nil
+}
+
+func (n *Node) delete(twin uint32, data []byte) error {
+	n.m.Lock()
+	dl, err := n.owned(twin, data)
+	if err != nil {
+		n.m.Unlock()
+		return err
+	}
+	id := dl.ContractID
+	n.m.Unlock()
+	n.decommission(id)
+	return nil
+}
+
+// decommission marks all the workloads of the contract deployment as deleted
+func (n *Node) decommission(contractID uint64) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	dl, ok := n.deployments[contractID]
+	if !ok {
+		return
+	}
+	for i := range dl.Workloads {
+		dl.Workloads[i].Result = gridtypes.Result{
+			Created: gridtypes.Now(),
+			State:   gridtypes.StateDeleted,
+			Error:   "deployment contract is canceled",
+		}
+	}
+	delete(n.ports, contractID)
+	n.farm.release(contractID)
+}
+
+// provision returns the result of a workload once it's deployed, the lock must be held
+func (n *Node) provision(dl *gridtypes.Deployment, wl *gridtypes.Workload) gridtypes.Result {
+	res := gridtypes.Result{Created: gridtypes.Now(), State: gridtypes.StateOk}
+	if msg, ok := n.faults[wl.Name.String()]; ok {
+		res.State = gridtypes.StateError
+		res.Error = msg
+		return res
+	}
+	var data interface{}
+	switch wl.Type {
+	case zos.ZMachineType:
+		vm, err := wl.WorkloadData()
+		if err != nil {
+			break
+		}
+		machine := vm.(*zos.ZMachine)
+		result := zos.ZMachineResult{ID: fmt.Sprintf("%d-%s", dl.ContractID, wl.Name)}
+		if len(machine.Network.Interfaces) != 0 {
+			result.IP = machine.Network.Interfaces[0].IP.String()
+		}
+		if machine.Network.Planetary {
+			result.YggIP = fmt.Sprintf("300:%x:%x::%x", n.ID, dl.ContractID, wl.Version+1)
+		}
+		data = result
+	case zos.PublicIPType:
+		ip, err := n.farm.reserve(dl.ContractID)
+		if err != nil {
+			res.State = gridtypes.StateError
+			res.Error = err.Error()
+			return res
+		}
+		result := zos.PublicIPResult{Gateway: net.ParseIP(ip.Gateway)}
+		if parsed, err := gridtypes.ParseIPNet(ip.IP); err == nil {
+			result.IP = parsed
+		}
+		data = result
+	case zos.ZDBType:
+		data = zos.ZDBResult{
+			Namespace: fmt.Sprintf("%d-%s", dl.ContractID, wl.Name),
+			IPs:       []string{n.yggIP().String()},
+			Port:      9900,
+		}
+	case zos.GatewayNameProxyType:
+		gw, err := wl.WorkloadData()
+		if err != nil || n.PublicConfig == nil {
+			break
+		}
+		data = zos.GatewayProxyResult{FQDN: fmt.Sprintf("%s.%s", gw.(*zos.GatewayNameProxy).Name, n.PublicConfig.Domain)}
+	case zos.QuantumSafeFSType:
+		data = zos.QuatumSafeFSResult{
+			Path:            fmt.Sprintf("/var/run/qsfs/%d-%s", dl.ContractID, wl.Name),
+			MetricsEndpoint: fmt.Sprintf("http://[%s]:9100/metrics", n.yggIP()),
+		}
+	case zos.ZMountType:
+		data = zos.ZMountResult{ID: fmt.Sprintf("%d-%s", dl.ContractID, wl.Name)}
+	}
+	if data != nil {
+		bs, err := json.Marshal(data)
+		if err == nil {
+			res.Data = bs
+		}
+	}
+	return res
+}
@@ -0,0 +1,344 @@
+package gridtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/terraform-provider-grid/pkg/subi"
+)
+
+// ErrContractNotExists mimics the chain error returned for unknown contracts
+var ErrContractNotExists = errors.New("ContractNotExists")
+
+// Contract is a node or name contract stored on the fake chain
+type Contract struct {
+	ID        uint64
+	Twin      uint32
+	Node      uint32
+	Name      string
+	Body      string
+	Hash      string
+	PublicIPs uint32
+	Deleted   bool
+}
+
+func (c *Contract) IsDeleted() bool {
+	return c.Deleted
+}
+
+func (c *Contract) IsCreated() bool {
+	return !c.Deleted
+}
+
+func (c *Contract) TwinID() uint32 {
+	return c.Twin
+}
+
+func (c *Contract) PublicIPCount() uint32 {
+	return c.PublicIPs
+}
+
+func (c *Contract) NodeID() uint32 {
+	return c.Node
+}
+
+func (c *Contract) DeploymentData() string {
+	return c.Body
+}
+
+type twin struct {
+	pk []byte
+	ip string
+}
+
+// Substrate is an in memory chain implementing subi.SubstrateExt. It also implements
+// subi.Connector so it can be handed to the provider instead of the real connections
+type Substrate struct {
+	m         sync.Mutex
+	twins     map[uint32]twin
+	balances  map[string]*big.Int
+	nodes     map[uint32]uint32
+	contracts map[uint64]*Contract
+	names     map[string]uint64
+	lastTwin  uint32
+	lastID    uint64
+	// canceled is notified with the canceled node contracts so nodes can decommission them
+	canceled func(contract *Contract)
+}
+
+func NewSubstrate() *Substrate {
+	return &Substrate{
+		twins:     make(map[uint32]twin),
+		balances:  make(map[string]*big.Int),
+		nodes:     make(map[uint32]uint32),
+		contracts: make(map[uint64]*Contract),
+		names:     make(map[string]uint64),
+	}
+}
+
+// AddTwin registers a twin with the public key of the identity and funds its account
+func (s *Substrate) AddTwin(identity subi.Identity, ip string, balance uint64) uint32 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.lastTwin++
+	s.twins[s.lastTwin] = twin{pk: identity.PublicKey(), ip: ip}
+	s.balances[string(identity.PublicKey())] = new(big.Int).SetUint64(balance)
+	return s.lastTwin
+}
+
+// Contracts returns a copy of the stored contracts
+func (s *Substrate) Contracts() []Contract {
+	s.m.Lock()
+	defer s.m.Unlock()
+	res := make([]Contract, 0, len(s.contracts))
+	for id := uint64(1); id <= s.lastID; id++ {
+		if c, ok := s.contracts[id]; ok {
+			res = append(res, *c)
+		}
+	}
+	return res
+}
+
+func (s *Substrate) addNode(node uint32, twin uint32) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.nodes[node] = twin
+}
+
+// twinOf returns the twin owning the identity, the lock must be held
+func (s *Substrate) twinOf(identity subi.Identity) (uint32, error) {
+	pk := identity.PublicKey()
+	for id, t := range s.twins {
+		if bytes.Equal(t.pk, pk) {
+			return id, nil
+		}
+	}
+	return 0, subi.ErrNotFound
+}
+
+func (s *Substrate) Connect(version string, newManager func(url ...string) subi.Manager, urls ...string) (subi.SubstrateExt, error) {
+	return s, nil
+}
+
+func (s *Substrate) Close() {}
+
+func (s *Substrate) CancelContract(identity subi.Identity, contractID uint64) error {
+	s.m.Lock()
+	twin, err := s.twinOf(identity)
+	if err != nil {
+		s.m.Unlock()
+		return err
+	}
+	c, ok := s.contracts[contractID]
+	if !ok || c.Deleted {
+		s.m.Unlock()
+		return ErrContractNotExists
+	}
+	if c.Twin != twin {
+		s.m.Unlock()
+		return errors.New("TwinNotAuthorizedToCancelContract")
+	}
+	c.Deleted = true
+	if c.Name != "" {
+		delete(s.names, c.Name)
+	}
+	canceled := s.canceled
+	contract := *c
+	s.m.Unlock()
+	if canceled != nil && contract.Node != 0 {
+		canceled(&contract)
+	}
+	return nil
+}
+
+func (s *Substrate) CreateNodeContract(identity subi.Identity, node uint32, body string, hash string, publicIPs uint32, solutionProviderID *uint64) (uint64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	twin, err := s.twinOf(identity)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := s.nodes[node]; !ok {
+		return 0, errors.New("NodeNotExists")
+	}
+	for _, c := range s.contracts {
+		if !c.Deleted && c.Node == node && c.Hash == hash {
+			return 0, errors.New("ContractIsNotUnique")
+		}
+	}
+	s.lastID++
+	s.contracts[s.lastID] = &Contract{
+		ID:        s.lastID,
+		Twin:      twin,
+		Node:      node,
+		Body:      body,
+		Hash:      hash,
+		PublicIPs: publicIPs,
+	}
+	return s.lastID, nil
+}
+
+func (s *Substrate) UpdateNodeContract(identity subi.Identity, contract uint64, body string, hash string) (uint64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	twin, err := s.twinOf(identity)
+	if err != nil {
+		return 0, err
+	}
+	c, ok := s.contracts[contract]
+	if !ok || c.Deleted {
+		return 0, ErrContractNotExists
+	}
+	if c.Twin != twin {
+		return 0, errors.New("TwinNotAuthorizedToUpdateContract")
+	}
+	c.Body = body
+	c.Hash = hash
+	return contract, nil
+}
+
+func (s *Substrate) CreateNameContract(identity subi.Identity, name string) (uint64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	twin, err := s.twinOf(identity)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := s.names[name]; ok {
+		return 0, errors.New("NameExists")
+	}
+	s.lastID++
+	s.contracts[s.lastID] = &Contract{ID: s.lastID, Twin: twin, Name: name}
+	s.names[name] = s.lastID
+	return s.lastID, nil
+}
+
+func (s *Substrate) GetTwinByPubKey(pk []byte) (uint32, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for id, t := range s.twins {
+		if bytes.Equal(t.pk, pk) {
+			return id, nil
+		}
+	}
+	return 0, subi.ErrNotFound
+}
+
+func (s *Substrate) EnsureContractCanceled(identity subi.Identity, contractID uint64) error {
+	if contractID == 0 {
+		return nil
+	}
+	if err := s.CancelContract(identity, contractID); err != nil && err != ErrContractNotExists {
+		return err
+	}
+	return nil
+}
+
+func (s *Substrate) DeleteInvalidContracts(contracts map[uint32]uint64) error {
+	for node, contractID := range contracts {
+		valid, err := s.IsValidContract(contractID)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			delete(contracts, node)
+		}
+	}
+	return nil
+}
+
+func (s *Substrate) IsValidContract(contractID uint64) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	c, ok := s.contracts[contractID]
+	return ok && !c.Deleted, nil
+}
+
+func (s *Substrate) InvalidateNameContract(ctx context.Context, identity subi.Identity, contractID uint64, name string) (uint64, error) {
+	if contractID == 0 {
+		return 0, nil
+	}
+	s.m.Lock()
+	c, ok := s.contracts[contractID]
+	if !ok || c.Deleted {
+		s.m.Unlock()
+		return 0, nil
+	}
+	current := c.Name
+	s.m.Unlock()
+	if current != name {
+		if err := s.CancelContract(identity, contractID); err != nil {
+			return 0, errors.Wrap(err, "failed to cleanup unmatching name contract")
+		}
+		return 0, nil
+	}
+	return contractID, nil
+}
+
+func (s *Substrate) GetContract(id uint64) (subi.Contract, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	c, ok := s.contracts[id]
+	if !ok {
+		return nil, subi.ErrNotFound
+	}
+	contract := *c
+	return &contract, nil
+}
+
+func (s *Substrate) GetNodeTwin(id uint32) (uint32, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	twin, ok := s.nodes[id]
+	if !ok {
+		return 0, subi.ErrNotFound
+	}
+	return twin, nil
+}
+
+func (s *Substrate) GetAccount(identity subi.Identity) (types.AccountInfo, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	var info types.AccountInfo
+	balance, ok := s.balances[string(identity.PublicKey())]
+	if !ok {
+		return info, subi.ErrAccountNotFound
+	}
+	info.Data.Free = types.NewU128(*balance)
+	return info, nil
+}
+
+func (s *Substrate) GetTwinIP(twinID uint32) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	t, ok := s.twins[twinID]
+	if !ok {
+		return "", subi.ErrNotFound
+	}
+	return t.ip, nil
+}
+
+func (s *Substrate) GetContractIDByNameRegistration(name string) (uint64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	id, ok := s.names[name]
+	if !ok {
+		return 0, subi.ErrNotFound
+	}
+	return id, nil
+}
+
+func (s *Substrate) GetTwinPK(twinID uint32) ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	t, ok := s.twins[twinID]
+	if !ok {
+		return nil, fmt.Errorf("twin %d: %w", twinID, subi.ErrNotFound)
+	}
+	return t.pk, nil
+}
@@ -0,0 +1,189 @@
+package state
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IPAM allocates the ipv4 addresses of the workloads in the subnet of a node. The first
+// address after the network address is the gateway zos sets up for the subnet, so the
+// usable addresses are the ones after it up to the one before the broadcast address.
+// The ipv6 addresses zos gives the workloads are derived from the ipv4 ones so they
+// never need to be allocated
+type IPAM struct {
+	subnet   net.IPNet
+	reserved []net.IPNet
+	// owners maps each used ip to the deployment using it
+	owners map[string]string
+}
+
+// NewIPAM creates an allocator of the ipv4 subnet, of any prefix length up to /30
+func NewIPAM(subnet string) (*IPAM, error) {
+	ip, cidr, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid subnet %s", subnet)
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("subnet %s is not an ipv4 subnet", subnet)
+	}
+	if ones, _ := cidr.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("subnet %s is too small to have workloads", subnet)
+	}
+	cidr.IP = cidr.IP.To4()
+	return &IPAM{
+		subnet: *cidr,
+		owners: make(map[string]string),
+	}, nil
+}
+
+// Subnet returns the subnet of the allocator
+func (p *IPAM) Subnet() string {
+	return p.subnet.String()
+}
+
+// Reserve excludes an ip or a cidr from the allocation, the parts outside the subnet are ignored
+func (p *IPAM) Reserve(r string) error {
+	cidr, err := parseRange(r)
+	if err != nil {
+		return err
+	}
+	p.reserved = append(p.reserved, cidr)
+	return nil
+}
+
+// Assign marks a static ip as used by the owner, it fails if the ip can't be used
+// or is already used by another owner
+func (p *IPAM) Assign(ip string, owner string) error {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return fmt.Errorf("invalid ipv4 %s", ip)
+	}
+	if !p.usable(parsed) {
+		return fmt.Errorf("ip %s is not a usable address of subnet %s", ip, p.subnet.String())
+	}
+	if r := p.reservation(parsed); r != "" {
+		return fmt.Errorf("ip %s is in the reserved range %s", ip, r)
+	}
+	if other, ok := p.owners[parsed.String()]; ok && other != owner {
+		return fmt.Errorf("ip %s is already used by deployment %s", ip, other)
+	}
+	p.owners[parsed.String()] = owner
+	return nil
+}
+
+// Allocate assigns the first free ip of the subnet to the owner
+func (p *IPAM) Allocate(owner string) (string, error) {
+	for _, ip := range p.hosts() {
+		if p.isFree(ip) {
+			p.owners[ip.String()] = owner
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("all ips of subnet %s are used", p.subnet.String())
+}
+
+// Release frees the ips used by the owner
+func (p *IPAM) Release(owner string) {
+	for ip, o := range p.owners {
+		if o == owner {
+			delete(p.owners, ip)
+		}
+	}
+}
+
+// Owner returns the owner of the ip, it's empty if the ip is free
+func (p *IPAM) Owner(ip string) string {
+	return p.owners[net.ParseIP(ip).String()]
+}
+
+// Free lists the ips that can be allocated
+func (p *IPAM) Free() []string {
+	free := make([]string, 0)
+	for _, ip := range p.hosts() {
+		if p.isFree(ip) {
+			free = append(free, ip.String())
+		}
+	}
+	return free
+}
+
+// Used returns the used ips with their owners
+func (p *IPAM) Used() map[string]string {
+	used := make(map[string]string, len(p.owners))
+	for ip, owner := range p.owners {
+		used[ip] = owner
+	}
+	return used
+}
+
+func (p *IPAM) isFree(ip net.IP) bool {
+	_, used := p.owners[ip.String()]
+	return !used && p.reservation(ip) == ""
+}
+
+func (p *IPAM) reservation(ip net.IP) string {
+	for _, r := range p.reserved {
+		if r.Contains(ip) {
+			return r.String()
+		}
+	}
+	return ""
+}
+
+func (p *IPAM) usable(ip net.IP) bool {
+	if !p.subnet.Contains(ip) {
+		return false
+	}
+	first, last := p.bounds()
+	n := binary.BigEndian.Uint32(ip)
+	return n >= first && n <= last
+}
+
+// bounds returns the first and last usable addresses, skipping the network address,
+// the gateway and the broadcast address
+func (p *IPAM) bounds() (uint32, uint32) {
+	start := binary.BigEndian.Uint32(p.subnet.IP)
+	ones, bits := p.subnet.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	return start + 2, start + size - 2
+}
+
+func (p *IPAM) hosts() []net.IP {
+	first, last := p.bounds()
+	hosts := make([]net.IP, 0, last-first+1)
+	for n := first; n <= last; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		hosts = append(hosts, ip)
+	}
+	return hosts
+}
+
+// parseRange parses an ipv4 or an ipv4 cidr
+func parseRange(r string) (net.IPNet, error) {
+	if !strings.Contains(r, "/") {
+		ip := net.ParseIP(r).To4()
+		if ip == nil {
+			return net.IPNet{}, fmt.Errorf("invalid ipv4 %s", r)
+		}
+		return net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	ip, cidr, err := net.ParseCIDR(r)
+	if err != nil {
+		return net.IPNet{}, errors.Wrapf(err, "invalid range %s", r)
+	}
+	if ip.To4() == nil {
+		return net.IPNet{}, fmt.Errorf("range %s is not an ipv4 range", r)
+	}
+	return *cidr, nil
+}
+
+// ValidateRange checks r is an ipv4 or an ipv4 cidr
+func ValidateRange(r string) error {
+	_, err := parseRange(r)
+	return err
+}
@@ -0,0 +1,81 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPAMPrefixes(t *testing.T) {
+	for subnet, count := range map[string]int{
+		"10.1.2.0/24": 253,
+		"10.1.2.0/25": 125,
+		"10.1.2.0/23": 509,
+		"10.1.2.0/30": 1,
+	} {
+		ipam, err := NewIPAM(subnet)
+		assert.NoError(t, err)
+		free := ipam.Free()
+		assert.Len(t, free, count, subnet)
+		ip, err := ipam.Allocate("1")
+		assert.NoError(t, err)
+		assert.Equal(t, free[0], ip, subnet)
+	}
+	ipam, err := NewIPAM("10.1.2.0/23")
+	assert.NoError(t, err)
+	free := ipam.Free()
+	assert.Equal(t, "10.1.2.2", free[0])
+	assert.Equal(t, "10.1.3.254", free[len(free)-1])
+
+	_, err = NewIPAM("10.1.2.0/31")
+	assert.Error(t, err)
+	_, err = NewIPAM("fd00::/64")
+	assert.Error(t, err)
+}
+
+func TestIPAMAssignments(t *testing.T) {
+	ipam, err := NewIPAM("10.1.2.0/24")
+	assert.NoError(t, err)
+	assert.NoError(t, ipam.Reserve("10.1.2.2/31"))
+	assert.NoError(t, ipam.Reserve("10.1.2.10"))
+
+	ip, err := ipam.Allocate("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.2.4", ip)
+
+	// static assignments
+	assert.NoError(t, ipam.Assign("10.1.2.20", "2"))
+	assert.NoError(t, ipam.Assign("10.1.2.20", "2"))
+	assert.Error(t, ipam.Assign("10.1.2.20", "3"))
+	assert.Error(t, ipam.Assign("10.1.2.10", "3"))
+	assert.Error(t, ipam.Assign("10.1.2.1", "3"))
+	assert.Error(t, ipam.Assign("10.1.2.255", "3"))
+	assert.Error(t, ipam.Assign("10.1.3.5", "3"))
+	assert.Equal(t, "2", ipam.Owner("10.1.2.20"))
+	assert.NotContains(t, ipam.Free(), "10.1.2.20")
+	assert.NotContains(t, ipam.Free(), "10.1.2.3")
+
+	ipam.Release("2")
+	assert.Empty(t, ipam.Owner("10.1.2.20"))
+	assert.Equal(t, map[string]string{"10.1.2.4": "1"}, ipam.Used())
+
+	assert.Error(t, ipam.Reserve("10.1.2"))
+}
+
+func TestNodeIPAM(t *testing.T) {
+	st := NewState()
+	network := st.GetNetworkState().GetNetwork("net")
+	_, err := network.GetNodeIPAM(1)
+	assert.Error(t, err)
+
+	network.SetNodeSubnet(1, "10.1.2.0/24")
+	network.SetReservedIPs([]string{"10.1.2.2"})
+	network.SetDeploymentIPs(1, "10", []string{"10.1.2.3"})
+	ipam, err := network.GetNodeIPAM(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", ipam.Owner("10.1.2.3"))
+	ip, err := ipam.Allocate("11")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.2.4", ip)
+	assert.Error(t, ipam.Assign("10.1.2.3", "11"))
+}
@@ -1,18 +1,72 @@
 package state
 
-type networkingState map[string]network
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+type networkingState map[string]*network
 
 type network struct {
 	Subnets map[uint32]string `json:"subnets"`
 	NodeIPs NodeIPs           `json:"node_ips"`
+	// Reserved are the ips and cidrs excluded from the allocation on all nodes
+	Reserved []string       `json:"reserved,omitempty"`
+	Config   *NetworkConfig `json:"config,omitempty"`
+	// Deployments are the contracts of the network deployments on the nodes
+	Deployments map[uint32]uint64 `json:"deployments,omitempty"`
+	// Peers are the wireguard peers added to the network nodes by the peerings with other networks
+	Peers []Peer `json:"peers,omitempty"`
+}
+
+// NetworkConfig is the configuration of a network that can be extended by the resources using it.
+// It only has the public keys of the access point and the access peers, their private keys are
+// kept in the terraform state of the network
+type NetworkConfig struct {
+	IPRange          string            `json:"ip_range"`
+	NodeSubnetPrefix int               `json:"node_subnet_prefix"`
+	Description      string            `json:"description"`
+	SolutionType     string            `json:"solution_type"`
+	AddWGAccess      bool              `json:"add_wg_access"`
+	IPv6             bool              `json:"ipv6"`
+	ExternalIP       string            `json:"external_ip"`
+	ExternalPK       string            `json:"external_pk,omitempty"`
+	PublicNodeID     uint32            `json:"public_node_id"`
+	AccessNodes      []uint32          `json:"access_nodes,omitempty"`
+	Nodes            []uint32          `json:"nodes"`
+	ExtendedNodes    []uint32          `json:"extended_nodes"`
+	NodeDeploymentID map[uint32]uint64 `json:"node_deployment_id"`
+	AccessPeers      []AccessPeer      `json:"access_peers,omitempty"`
+}
+
+// AccessPeer is a wireguard peer given access to the network through its public node
+type AccessPeer struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+	Subnet    string `json:"subnet"`
+}
+
+// Peer is a wireguard peer added to a node of the network by a peering with a node of another network
+type Peer struct {
+	Peering string `json:"peering"`
+	Node    uint32 `json:"node"`
+	// Network and PeerNode are the peered network and its node
+	Network    string   `json:"network"`
+	PeerNode   uint32   `json:"peer_node"`
+	Subnet     string   `json:"subnet"`
+	PublicKey  string   `json:"public_key"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	AllowedIPs []string `json:"allowed_ips"`
 }
 
 type NodeIPs map[uint32]deploymentIPs
 
-type deploymentIPs map[string][]byte
+type deploymentIPs map[string][]string
 
-func NewNetwork() network {
-	return network{
+func NewNetwork() *network {
+	return &network{
 		Subnets: map[uint32]string{},
 		NodeIPs: NodeIPs{},
 	}
@@ -22,8 +76,7 @@ func (ns networkingState) GetNetwork(networkName string) Network {
 	if _, ok := ns[networkName]; !ok {
 		ns[networkName] = NewNetwork()
 	}
-	net := ns[networkName]
-	return &net
+	return ns[networkName]
 }
 
 func (ns networkingState) DeleteNetwork(networkName string) {
@@ -42,22 +95,26 @@ func (n *network) DeleteNodeSubnet(nodeID uint32) {
 	delete(n.Subnets, nodeID)
 }
 
-func (n *network) GetNodeIPsList(nodeID uint32) []byte {
-	ips := []byte{}
+func (n *network) GetSubnets() map[uint32]string {
+	return n.Subnets
+}
+
+func (n *network) GetNodeIPsList(nodeID uint32) []string {
+	ips := []string{}
 	for _, v := range n.NodeIPs[nodeID] {
 		ips = append(ips, v...)
 	}
 	return ips
 }
 
-func (n *network) GetDeploymentIPs(nodeID uint32, deploymentID string) []byte {
+func (n *network) GetDeploymentIPs(nodeID uint32, deploymentID string) []string {
 	if n.NodeIPs[nodeID] == nil {
-		return []byte{}
+		return []string{}
 	}
 	return n.NodeIPs[nodeID][deploymentID]
 }
 
-func (n *network) SetDeploymentIPs(nodeID uint32, deploymentID string, ips []byte) {
+func (n *network) SetDeploymentIPs(nodeID uint32, deploymentID string, ips []string) {
 	if n.NodeIPs[nodeID] == nil {
 		n.NodeIPs[nodeID] = deploymentIPs{}
 	}
@@ -70,3 +127,131 @@ func (n *network) DeleteDeployment(nodeID uint32, deploymentID string) {
 	}
 	delete(n.NodeIPs[nodeID], deploymentID)
 }
+
+func (n *network) GetConfig() *NetworkConfig {
+	return n.Config
+}
+
+func (n *network) SetConfig(config *NetworkConfig) {
+	n.Config = config
+}
+
+func (n *network) GetReservedIPs() []string {
+	return n.Reserved
+}
+
+func (n *network) SetReservedIPs(reserved []string) {
+	n.Reserved = reserved
+}
+
+func (n *network) GetNodeDeploymentID(nodeID uint32) uint64 {
+	return n.Deployments[nodeID]
+}
+
+func (n *network) SetNodeDeploymentIDs(ids map[uint32]uint64) {
+	n.Deployments = make(map[uint32]uint64, len(ids))
+	for node, id := range ids {
+		n.Deployments[node] = id
+	}
+}
+
+func (n *network) GetPeers(nodeID uint32) []Peer {
+	peers := make([]Peer, 0)
+	for _, peer := range n.Peers {
+		if peer.Node == nodeID {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (n *network) SetPeers(peering string, peers []Peer) {
+	kept := make([]Peer, 0, len(n.Peers)+len(peers))
+	for _, peer := range n.Peers {
+		if peer.Peering != peering {
+			kept = append(kept, peer)
+		}
+	}
+	for _, peer := range peers {
+		peer.Peering = peering
+		kept = append(kept, peer)
+	}
+	n.Peers = kept
+}
+
+func (n *network) GetNodeIPAM(nodeID uint32) (*IPAM, error) {
+	subnet := n.Subnets[nodeID]
+	if subnet == "" {
+		return nil, errors.Errorf("node %d is not part of the network", nodeID)
+	}
+	ipam, err := NewIPAM(subnet)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range n.Reserved {
+		if err := ipam.Reserve(r); err != nil {
+			return nil, err
+		}
+	}
+	for deploymentID, ips := range n.NodeIPs[nodeID] {
+		for _, ip := range ips {
+			// ips that aren't usable anymore (e.g. got reserved) are kept by their deployments
+			// but must not be handed to others
+			if err := ipam.Assign(ip, deploymentID); err != nil {
+				ipam.owners[net.ParseIP(ip).String()] = deploymentID
+			}
+		}
+	}
+	return ipam, nil
+}
+
+// UnmarshalJSON loads the network migrating the used ips of the states written before
+// they were stored as full addresses, when only the last octet of each ip was kept
+func (n *network) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Subnets     map[uint32]string                     `json:"subnets"`
+		NodeIPs     map[uint32]map[string]json.RawMessage `json:"node_ips"`
+		Reserved    []string                              `json:"reserved"`
+		Config      *NetworkConfig                        `json:"config"`
+		Deployments map[uint32]uint64                     `json:"deployments"`
+		Peers       []Peer                                `json:"peers"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	n.Subnets = raw.Subnets
+	if n.Subnets == nil {
+		n.Subnets = map[uint32]string{}
+	}
+	n.Reserved = raw.Reserved
+	n.Config = raw.Config
+	n.Deployments = raw.Deployments
+	n.Peers = raw.Peers
+	n.NodeIPs = NodeIPs{}
+	for node, deployments := range raw.NodeIPs {
+		n.NodeIPs[node] = deploymentIPs{}
+		for deploymentID, msg := range deployments {
+			var ips []string
+			if err := json.Unmarshal(msg, &ips); err == nil {
+				n.NodeIPs[node][deploymentID] = ips
+				continue
+			}
+			var octets []byte
+			if err := json.Unmarshal(msg, &octets); err != nil {
+				return errors.Wrapf(err, "couldn't parse ips of deployment %s on node %d", deploymentID, node)
+			}
+			_, subnet, err := net.ParseCIDR(n.Subnets[node])
+			if err != nil {
+				// the ips can't be restored without the subnet, they're read again from the grid
+				continue
+			}
+			for _, octet := range octets {
+				ip := subnet.IP.To4()
+				ip[3] = octet
+				ips = append(ips, ip.String())
+			}
+			n.NodeIPs[node][deploymentID] = ips
+		}
+	}
+	return nil
+}
@@ -17,7 +17,7 @@ func TestState(t *testing.T) {
 	network := ns.GetNetwork("abc")
 	network.SetNodeSubnet(32, "10.1.1.0/24")
 	network.SetNodeSubnet(15, "10.1.1.0/24")
-	network.SetDeploymentIPs(32, "12345", []byte{1, 2, 3})
+	network.SetDeploymentIPs(32, "12345", []string{"10.1.1.1", "10.1.1.2", "10.1.1.3"})
 	network.DeleteDeployment(32, "12345")
 	network.DeleteNodeSubnet(32)
 	err = f.Save()
@@ -32,3 +32,40 @@ func TestState(t *testing.T) {
 	assert.Equal(t, bt1, bt2)
 	assert.NoError(t, err)
 }
+
+func TestNetworkConfig(t *testing.T) {
+	st := NewState()
+	network := st.GetNetworkState().GetNetwork("abc")
+	assert.Nil(t, network.GetConfig())
+	config := &NetworkConfig{
+		IPRange:          "10.1.0.0/16",
+		Nodes:            []uint32{1},
+		ExtendedNodes:    []uint32{2},
+		NodeDeploymentID: map[uint32]uint64{1: 10, 2: 11},
+	}
+	network.SetConfig(config)
+	assert.Equal(t, config, st.GetNetworkState().GetNetwork("abc").GetConfig())
+
+	bt, err := st.Marshal()
+	assert.NoError(t, err)
+	loaded := NewState()
+	assert.NoError(t, loaded.Unmarshal(bt))
+	assert.Equal(t, config, loaded.GetNetworkState().GetNetwork("abc").GetConfig())
+}
+
+func TestLegacyNodeIPs(t *testing.T) {
+	// states written when only the last octet of the ips was stored
+	legacy := `{"networks":{"net":{"subnets":{"1":"10.1.2.0/24"},"node_ips":{"1":{"10":"AgM="},"2":{"11":"Ag=="}}}}}`
+	st := NewState()
+	assert.NoError(t, st.Unmarshal([]byte(legacy)))
+	network := st.GetNetworkState().GetNetwork("net")
+	assert.Equal(t, []string{"10.1.2.2", "10.1.2.3"}, network.GetDeploymentIPs(1, "10"))
+	// no subnet to restore the ips from
+	assert.Empty(t, network.GetDeploymentIPs(2, "11"))
+
+	bt, err := st.Marshal()
+	assert.NoError(t, err)
+	loaded := NewState()
+	assert.NoError(t, loaded.Unmarshal(bt))
+	assert.Equal(t, []string{"10.1.2.2", "10.1.2.3"}, loaded.GetNetworkState().GetNetwork("net").GetDeploymentIPs(1, "10"))
+}
@@ -44,14 +44,34 @@ type Network interface {
 	SetNodeSubnet(nodeID uint32, subnet string)
 	// DeleteNodeSubnet deletes node's subnet from network local state
 	DeleteNodeSubnet(nodeID uint32)
+	// GetSubnets retrieves the subnets of all network nodes
+	GetSubnets() map[uint32]string
 	// GetNodeIPs retrieves all node's used ips
-	GetNodeIPsList(nodeID uint32) []byte
+	GetNodeIPsList(nodeID uint32) []string
 	// GetDeploymentIPs retrieves deployment's used ips
-	GetDeploymentIPs(nodeID uint32, deploymentID string) []byte
+	GetDeploymentIPs(nodeID uint32, deploymentID string) []string
 	// SetDeploymentIPs sets deployment's used ips
-	SetDeploymentIPs(nodeID uint32, deploymentID string, ips []byte)
+	SetDeploymentIPs(nodeID uint32, deploymentID string, ips []string)
+	// GetReservedIPs retrieves the ips and cidrs excluded from the allocation
+	GetReservedIPs() []string
+	// SetReservedIPs sets the ips and cidrs excluded from the allocation
+	SetReservedIPs(reserved []string)
+	// GetNodeIPAM returns an allocator of node's subnet with the used and reserved ips
+	GetNodeIPAM(nodeID uint32) (*IPAM, error)
 	// RemoveDeployment deletes deployment entry
 	DeleteDeployment(nodeID uint32, deploymentID string)
+	// GetNodeDeploymentID retrieves the contract of the network deployment on the node, it's 0 if there's none
+	GetNodeDeploymentID(nodeID uint32) uint64
+	// SetNodeDeploymentIDs sets the contracts of the network deployments on all nodes
+	SetNodeDeploymentIDs(ids map[uint32]uint64)
+	// GetPeers retrieves the peers added to the node by the peerings with other networks
+	GetPeers(nodeID uint32) []Peer
+	// SetPeers replaces the peers added by the peering
+	SetPeers(peering string, peers []Peer)
+	// GetConfig retrieves the network configuration, it's nil unless the network can be extended
+	GetConfig() *NetworkConfig
+	// SetConfig sets the network configuration
+	SetConfig(config *NetworkConfig)
 }
 
 func NewLocalStateDB(t DBType) (DB, error) {
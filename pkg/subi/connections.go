@@ -0,0 +1,48 @@
+package subi
+
+import (
+	"strings"
+	"sync"
+)
+
+// Connector hands out the substrate connections of configured providers
+type Connector interface {
+	// Connect returns the connection to urls with the managers of version built by newManager
+	Connect(version string, newManager func(url ...string) Manager, urls ...string) (SubstrateExt, error)
+	Close()
+}
+
+// Connections shares one failover connection per substrate version and list of endpoints between
+// provider configurations. Connections are opened on first use and closed together on shutdown
+type Connections struct {
+	m     sync.Mutex
+	conns map[string]*FailoverSubstrate
+}
+
+func NewConnections() *Connections {
+	return &Connections{conns: make(map[string]*FailoverSubstrate)}
+}
+
+func (c *Connections) Connect(version string, newManager func(url ...string) Manager, urls ...string) (SubstrateExt, error) {
+	key := version + "|" + strings.Join(urls, ",")
+	c.m.Lock()
+	defer c.m.Unlock()
+	if conn, ok := c.conns[key]; ok {
+		return conn, nil
+	}
+	conn, err := NewFailoverSubstrate(newManager, urls...)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[key] = conn
+	return conn, nil
+}
+
+func (c *Connections) Close() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for key, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, key)
+	}
+}
@@ -0,0 +1,31 @@
+package subi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionsAreShared(t *testing.T) {
+	a := &fakeSubstrate{url: "a"}
+	managers := fakeManagers(map[string]*fakeManager{
+		"a": {sub: a},
+		"b": {sub: &fakeSubstrate{url: "b"}},
+	})
+	conns := NewConnections()
+	first, err := conns.Connect("dev", managers, "a")
+	assert.NoError(t, err)
+	second, err := conns.Connect("dev", managers, "a")
+	assert.NoError(t, err)
+	assert.Same(t, first, second)
+	other, err := conns.Connect("dev", managers, "b")
+	assert.NoError(t, err)
+	assert.NotSame(t, first, other)
+	// the managers of another version aren't compatible with the chain of the first ones
+	version, err := conns.Connect("test", managers, "a")
+	assert.NoError(t, err)
+	assert.NotSame(t, first, version)
+
+	conns.Close()
+	assert.True(t, a.closed)
+}
@@ -0,0 +1,61 @@
+package subi
+
+import (
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/substrate-client"
+)
+
+const (
+	contractsPallet       = "SmartContractModule"
+	createNodeContract    = "create_node_contract"
+	solutionProviderField = "solution_provider_id"
+)
+
+// DetectVersion connects to the chain behind url and returns the first supported version
+// whose extrinsics match the chain runtime metadata
+func DetectVersion(url ...string) (string, error) {
+	cl, meta, err := substrate.NewManager(url...).Raw()
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't connect to substrate")
+	}
+	defer cl.Client.Close()
+	if meta.Version != 14 {
+		return "", errors.Errorf("unsupported metadata version %d", meta.Version)
+	}
+	var fields []string
+	found := false
+	m := meta.AsMetadataV14
+	for _, pallet := range m.Pallets {
+		if string(pallet.Name) != contractsPallet || !pallet.HasCalls {
+			continue
+		}
+		typ, ok := m.EfficientLookup[pallet.Calls.Type.Int64()]
+		if !ok {
+			break
+		}
+		for _, call := range typ.Def.Variant.Variants {
+			if string(call.Name) != createNodeContract {
+				continue
+			}
+			found = true
+			for _, field := range call.Fields {
+				fields = append(fields, string(field.Name))
+			}
+		}
+	}
+	if !found {
+		return "", errors.Errorf("couldn't find %s.%s in chain metadata", contractsPallet, createNodeContract)
+	}
+	hasSolutionProvider := false
+	for _, field := range fields {
+		if field == solutionProviderField {
+			hasSolutionProvider = true
+		}
+	}
+	for _, version := range Networks {
+		if solutionProviderSupport[version] == hasSolutionProvider {
+			return version, nil
+		}
+	}
+	return "", errors.Errorf("no supported substrate version matches %s arguments %v", createNodeContract, fields)
+}
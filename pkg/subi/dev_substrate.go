@@ -1,3 +1,5 @@
+// Code generated by pkg/subi/gen from networks.json. DO NOT EDIT.
+
 package subi
 
 import (
@@ -5,35 +7,51 @@ import (
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/substrate-client"
 	subdev "github.com/threefoldtech/substrate-client-dev"
 )
 
-type Substrate interface {
-	CancelContract(identity Identity, contractID uint64) error
-	CreateNodeContract(identity Identity, node uint32, body string, hash string, publicIPs uint32, solutionProviderID *uint64) (uint64, error)
-	UpdateNodeContract(identity Identity, contract uint64, body string, hash string) (uint64, error)
-	Close()
-	GetTwinByPubKey(pk []byte) (uint32, error)
-}
-type SubstrateExt interface {
-	Substrate
-	EnsureContractCanceled(identity Identity, contractID uint64) error
-	DeleteInvalidContracts(contracts map[uint32]uint64) error
-	IsValidContract(contractID uint64) (bool, error)
-	InvalidateNameContract(
-		ctx context.Context,
-		identity Identity,
-		contractID uint64,
-		name string,
-	) (uint64, error)
-	GetContract(id uint64) (Contract, error)
-	GetNodeTwin(id uint32) (uint32, error)
-	CreateNameContract(identity Identity, name string) (uint64, error)
-	GetAccount(identity Identity) (types.AccountInfo, error)
-	GetTwinIP(twinID uint32) (string, error)
-	GetContractIDByNameRegistration(name string) (uint64, error)
-	GetTwinPK(twinID uint32) ([]byte, error)
+type DevManager struct {
+	substrate.Manager
+	versioned subdev.Manager
+}
+
+func NewDevManager(url ...string) Manager {
+	return &DevManager{substrate.NewManager(url...), subdev.NewManager(url...)}
+}
+
+func (m *DevManager) SubstrateExt() (SubstrateExt, error) {
+	sub, err := m.versioned.Substrate()
+	return &SubstrateDevImpl{sub}, err
+}
+
+type DevContract struct {
+	*subdev.Contract
+}
+
+func (c *DevContract) IsDeleted() bool {
+	return c.Contract.State.IsDeleted
+}
+func (c *DevContract) IsCreated() bool {
+	return c.Contract.State.IsCreated
+}
+
+func (c *DevContract) TwinID() uint32 {
+	return uint32(c.Contract.TwinID)
+}
+
+func (c *DevContract) PublicIPCount() uint32 {
+	return uint32(c.Contract.ContractType.NodeContract.PublicIPsCount)
 }
+
+func (c *DevContract) NodeID() uint32 {
+	return uint32(c.Contract.ContractType.NodeContract.Node)
+}
+
+func (c *DevContract) DeploymentData() string {
+	return c.Contract.ContractType.NodeContract.DeploymentData
+}
+
 type SubstrateDevImpl struct {
 	*subdev.Substrate
 }
@@ -49,10 +67,6 @@ func (s *SubstrateDevImpl) GetTwinIP(id uint32) (string, error) {
 	}
 	return twin.IP, nil
 }
-func (s *SubstrateDevImpl) GetAccount(identity Identity) (types.AccountInfo, error) {
-	res, err := s.Substrate.GetAccount(identity)
-	return res, terr(err)
-}
 func (s *SubstrateDevImpl) GetTwinPK(id uint32) ([]byte, error) {
 	twin, err := s.Substrate.GetTwin(id)
 	if err != nil {
@@ -60,6 +74,10 @@ func (s *SubstrateDevImpl) GetTwinPK(id uint32) ([]byte, error) {
 	}
 	return twin.Account.PublicKey(), nil
 }
+func (s *SubstrateDevImpl) GetAccount(identity Identity) (types.AccountInfo, error) {
+	res, err := s.Substrate.GetAccount(identity)
+	return res, terr(err)
+}
 func (s *SubstrateDevImpl) CreateNameContract(identity Identity, name string) (uint64, error) {
 	return s.Substrate.CreateNameContract(identity, name)
 }
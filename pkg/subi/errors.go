@@ -3,18 +3,22 @@ package subi
 import (
 	"github.com/pkg/errors"
 	subv2 "github.com/threefoldtech/substrate-client-dev"
-	subv3 "github.com/threefoldtech/substrate-client-test"
 )
 
 var ErrNotFound = subv2.ErrNotFound
 var ErrAccountNotFound = subv2.ErrAccountNotFound
 
+// terr maps the errors of every versioned client to the package level ones
 func terr(err error) error {
-	if errors.Is(err, subv2.ErrNotFound) || errors.Is(err, subv3.ErrNotFound) {
-		return ErrNotFound
+	for _, e := range notFoundErrors {
+		if errors.Is(err, e) {
+			return ErrNotFound
+		}
 	}
-	if errors.Is(err, subv2.ErrAccountNotFound) || errors.Is(err, subv3.ErrAccountNotFound) {
-		return ErrAccountNotFound
+	for _, e := range accountNotFoundErrors {
+		if errors.Is(err, e) {
+			return ErrAccountNotFound
+		}
 	}
 	return err
 }
@@ -0,0 +1,252 @@
+package subi
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/pkg/errors"
+)
+
+// FailoverSubstrate is a SubstrateExt over a list of endpoints, it reconnects to the
+// next healthy endpoint whenever a call fails with a connection error.
+// Reads are retried on the new endpoint, while contract creation and update are not
+// since the extrinsic could have been submitted before the connection dropped.
+type FailoverSubstrate struct {
+	newManager func(url ...string) Manager
+	urls       []string
+
+	m       sync.Mutex
+	current int
+	sub     SubstrateExt
+}
+
+// NewFailoverSubstrate connects to the first healthy endpoint of urls
+func NewFailoverSubstrate(newManager func(url ...string) Manager, urls ...string) (*FailoverSubstrate, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("at least one substrate url is required")
+	}
+	s := &FailoverSubstrate{
+		newManager: newManager,
+		urls:       urls,
+		current:    -1,
+	}
+	if err := s.connect(-1); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect moves to the first healthy endpoint after the one at index from.
+// the manager only hands out a connection to a node that is synced with the chain
+func (s *FailoverSubstrate) connect(from int) error {
+	var errs []string
+	for i := 1; i <= len(s.urls); i++ {
+		idx := (from + i) % len(s.urls)
+		sub, err := s.newManager(s.urls[idx]).SubstrateExt()
+		if err != nil {
+			log.Printf("substrate endpoint %s is unhealthy: %s", s.urls[idx], err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		if s.sub != nil {
+			s.sub.Close()
+		}
+		s.sub, s.current = sub, idx
+		log.Printf("connected to substrate endpoint %s", s.urls[idx])
+		return nil
+	}
+	return errors.Errorf("couldn't connect to any substrate endpoint: %s", strings.Join(errs, "; "))
+}
+
+func (s *FailoverSubstrate) get() (SubstrateExt, int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.sub, s.current
+}
+
+// failover reconnects unless another call already moved away from the failed endpoint
+func (s *FailoverSubstrate) failover(failed int) (SubstrateExt, int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.current == failed {
+		if err := s.connect(failed); err != nil {
+			return nil, 0, err
+		}
+	}
+	return s.sub, s.current, nil
+}
+
+func (s *FailoverSubstrate) do(name string, retry bool, fn func(sub SubstrateExt) error) error {
+	sub, idx := s.get()
+	err := fn(sub)
+	if !isConnectionError(err) {
+		log.Printf("substrate call %s served by %s", name, s.urls[idx])
+		return err
+	}
+	log.Printf("substrate call %s failed on %s: %s", name, s.urls[idx], err)
+	sub, idx, ferr := s.failover(idx)
+	if ferr != nil {
+		return errors.Wrapf(err, "failover failed: %s", ferr)
+	}
+	if !retry {
+		return err
+	}
+	err = fn(sub)
+	log.Printf("substrate call %s served by %s", name, s.urls[idx])
+	return err
+}
+
+// isConnectionError reports whether err is caused by the connection to the endpoint
+// rather than by the chain
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "use of closed network connection") ||
+		strings.Contains(msg, "websocket: close") ||
+		strings.Contains(msg, "connection reset by peer")
+}
+
+func (s *FailoverSubstrate) Close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.sub != nil {
+		s.sub.Close()
+		s.sub = nil
+	}
+}
+
+func (s *FailoverSubstrate) CancelContract(identity Identity, contractID uint64) error {
+	return s.do("CancelContract", true, func(sub SubstrateExt) error {
+		return sub.CancelContract(identity, contractID)
+	})
+}
+
+func (s *FailoverSubstrate) CreateNodeContract(identity Identity, node uint32, body string, hash string, publicIPs uint32, solutionProviderID *uint64) (res uint64, err error) {
+	err = s.do("CreateNodeContract", false, func(sub SubstrateExt) error {
+		res, err = sub.CreateNodeContract(identity, node, body, hash, publicIPs, solutionProviderID)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) UpdateNodeContract(identity Identity, contract uint64, body string, hash string) (res uint64, err error) {
+	err = s.do("UpdateNodeContract", false, func(sub SubstrateExt) error {
+		res, err = sub.UpdateNodeContract(identity, contract, body, hash)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) GetTwinByPubKey(pk []byte) (res uint32, err error) {
+	err = s.do("GetTwinByPubKey", true, func(sub SubstrateExt) error {
+		res, err = sub.GetTwinByPubKey(pk)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) EnsureContractCanceled(identity Identity, contractID uint64) error {
+	return s.do("EnsureContractCanceled", true, func(sub SubstrateExt) error {
+		return sub.EnsureContractCanceled(identity, contractID)
+	})
+}
+
+func (s *FailoverSubstrate) DeleteInvalidContracts(contracts map[uint32]uint64) error {
+	return s.do("DeleteInvalidContracts", true, func(sub SubstrateExt) error {
+		return sub.DeleteInvalidContracts(contracts)
+	})
+}
+
+func (s *FailoverSubstrate) IsValidContract(contractID uint64) (res bool, err error) {
+	err = s.do("IsValidContract", true, func(sub SubstrateExt) error {
+		res, err = sub.IsValidContract(contractID)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) InvalidateNameContract(
+	ctx context.Context,
+	identity Identity,
+	contractID uint64,
+	name string,
+) (res uint64, err error) {
+	err = s.do("InvalidateNameContract", true, func(sub SubstrateExt) error {
+		res, err = sub.InvalidateNameContract(ctx, identity, contractID, name)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) GetContract(id uint64) (res Contract, err error) {
+	err = s.do("GetContract", true, func(sub SubstrateExt) error {
+		res, err = sub.GetContract(id)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) GetNodeTwin(id uint32) (res uint32, err error) {
+	err = s.do("GetNodeTwin", true, func(sub SubstrateExt) error {
+		res, err = sub.GetNodeTwin(id)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) CreateNameContract(identity Identity, name string) (res uint64, err error) {
+	err = s.do("CreateNameContract", false, func(sub SubstrateExt) error {
+		res, err = sub.CreateNameContract(identity, name)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) GetAccount(identity Identity) (res types.AccountInfo, err error) {
+	err = s.do("GetAccount", true, func(sub SubstrateExt) error {
+		res, err = sub.GetAccount(identity)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) GetTwinIP(twinID uint32) (res string, err error) {
+	err = s.do("GetTwinIP", true, func(sub SubstrateExt) error {
+		res, err = sub.GetTwinIP(twinID)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) GetContractIDByNameRegistration(name string) (res uint64, err error) {
+	err = s.do("GetContractIDByNameRegistration", true, func(sub SubstrateExt) error {
+		res, err = sub.GetContractIDByNameRegistration(name)
+		return err
+	})
+	return
+}
+
+func (s *FailoverSubstrate) GetTwinPK(twinID uint32) (res []byte, err error) {
+	err = s.do("GetTwinPK", true, func(sub SubstrateExt) error {
+		res, err = sub.GetTwinPK(twinID)
+		return err
+	})
+	return
+}
@@ -0,0 +1,96 @@
+package subi
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/substrate-client"
+)
+
+type fakeSubstrate struct {
+	SubstrateExt
+	url    string
+	err    error
+	closed bool
+}
+
+func (f *fakeSubstrate) GetTwinPK(twinID uint32) ([]byte, error) {
+	return []byte(f.url), f.err
+}
+
+func (f *fakeSubstrate) CreateNodeContract(identity Identity, node uint32, body string, hash string, publicIPs uint32, solutionProviderID *uint64) (uint64, error) {
+	return 1, f.err
+}
+
+func (f *fakeSubstrate) Close() {
+	f.closed = true
+}
+
+type fakeManager struct {
+	substrate.Manager
+	sub *fakeSubstrate
+	err error
+}
+
+func (m *fakeManager) SubstrateExt() (SubstrateExt, error) {
+	return m.sub, m.err
+}
+
+func fakeManagers(subs map[string]*fakeManager) func(url ...string) Manager {
+	return func(url ...string) Manager {
+		return subs[url[0]]
+	}
+}
+
+func TestFailoverSkipsUnhealthyEndpoints(t *testing.T) {
+	managers := map[string]*fakeManager{
+		"a": {err: errors.New("node is behind acceptable delay")},
+		"b": {sub: &fakeSubstrate{url: "b"}},
+	}
+	s, err := NewFailoverSubstrate(fakeManagers(managers), "a", "b")
+	assert.NoError(t, err)
+	pk, err := s.GetTwinPK(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), pk)
+}
+
+func TestFailoverOnConnectionError(t *testing.T) {
+	a := &fakeSubstrate{url: "a", err: io.EOF}
+	managers := map[string]*fakeManager{
+		"a": {sub: a},
+		"b": {sub: &fakeSubstrate{url: "b"}},
+	}
+	s, err := NewFailoverSubstrate(fakeManagers(managers), "a", "b")
+	assert.NoError(t, err)
+	pk, err := s.GetTwinPK(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), pk)
+	assert.True(t, a.closed)
+}
+
+func TestFailoverDoesNotRetryExtrinsics(t *testing.T) {
+	managers := map[string]*fakeManager{
+		"a": {sub: &fakeSubstrate{url: "a", err: io.EOF}},
+		"b": {sub: &fakeSubstrate{url: "b"}},
+	}
+	s, err := NewFailoverSubstrate(fakeManagers(managers), "a", "b")
+	assert.NoError(t, err)
+	_, err = s.CreateNodeContract(nil, 1, "", "", 0, nil)
+	assert.ErrorIs(t, err, io.EOF)
+	pk, err := s.GetTwinPK(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), pk)
+}
+
+func TestFailoverKeepsChainErrors(t *testing.T) {
+	managers := map[string]*fakeManager{
+		"a": {sub: &fakeSubstrate{url: "a", err: ErrNotFound}},
+		"b": {sub: &fakeSubstrate{url: "b"}},
+	}
+	s, err := NewFailoverSubstrate(fakeManagers(managers), "a", "b")
+	assert.NoError(t, err)
+	_, err = s.GetTwinPK(1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
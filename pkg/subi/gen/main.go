@@ -0,0 +1,74 @@
+// gen renders the network specific substrate wrappers of package subi from networks.json.
+// Adding a network only requires a new entry in networks.json and a replace directive in go.mod.
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+//go:embed *.tmpl
+var templates embed.FS
+
+// Network describes a substrate-client fork and the differences in its extrinsics api
+type Network struct {
+	Name             string `json:"name"`
+	Type             string `json:"type"`
+	Module           string `json:"module"`
+	Alias            string `json:"alias"`
+	BytesBody        bool   `json:"bytes_body"`
+	SolutionProvider bool   `json:"solution_provider"`
+}
+
+func render(tmpl *template.Template, name string, data interface{}, out string) error {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return errors.Wrapf(err, "couldn't execute template %s", name)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return errors.Wrapf(err, "couldn't format generated %s", out)
+	}
+	return os.WriteFile(out, src, 0644)
+}
+
+func run(dir string) error {
+	content, err := os.ReadFile(filepath.Join(dir, "networks.json"))
+	if err != nil {
+		return errors.Wrap(err, "couldn't read networks file")
+	}
+	var networks []Network
+	if err := json.Unmarshal(content, &networks); err != nil {
+		return errors.Wrap(err, "couldn't decode networks file")
+	}
+	tmpl, err := template.ParseFS(templates, "*.tmpl")
+	if err != nil {
+		return errors.Wrap(err, "couldn't parse templates")
+	}
+	for _, n := range networks {
+		out := filepath.Join(dir, fmt.Sprintf("%s_substrate.go", n.Name))
+		if err := render(tmpl, "substrate.go.tmpl", n, out); err != nil {
+			return err
+		}
+	}
+	return render(tmpl, "networks.go.tmpl", networks, filepath.Join(dir, "networks.go"))
+}
+
+func main() {
+	dir := "."
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	if err := run(dir); err != nil {
+		log.Fatal(err)
+	}
+}
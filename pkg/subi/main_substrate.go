@@ -1,3 +1,5 @@
+// Code generated by pkg/subi/gen from networks.json. DO NOT EDIT.
+
 package subi
 
 import (
@@ -5,9 +7,51 @@ import (
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/substrate-client"
 	submain "github.com/threefoldtech/substrate-client-main"
 )
 
+type MainManager struct {
+	substrate.Manager
+	versioned submain.Manager
+}
+
+func NewMainManager(url ...string) Manager {
+	return &MainManager{substrate.NewManager(url...), submain.NewManager(url...)}
+}
+
+func (m *MainManager) SubstrateExt() (SubstrateExt, error) {
+	sub, err := m.versioned.Substrate()
+	return &SubstrateMainImpl{sub}, err
+}
+
+type MainContract struct {
+	*submain.Contract
+}
+
+func (c *MainContract) IsDeleted() bool {
+	return c.Contract.State.IsDeleted
+}
+func (c *MainContract) IsCreated() bool {
+	return c.Contract.State.IsCreated
+}
+
+func (c *MainContract) TwinID() uint32 {
+	return uint32(c.Contract.TwinID)
+}
+
+func (c *MainContract) PublicIPCount() uint32 {
+	return uint32(c.Contract.ContractType.NodeContract.PublicIPsCount)
+}
+
+func (c *MainContract) NodeID() uint32 {
+	return uint32(c.Contract.ContractType.NodeContract.Node)
+}
+
+func (c *MainContract) DeploymentData() string {
+	return c.Contract.ContractType.NodeContract.DeploymentData
+}
+
 type SubstrateMainImpl struct {
 	*submain.Substrate
 }
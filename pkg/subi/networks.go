@@ -0,0 +1,47 @@
+// Code generated by pkg/subi/gen from networks.json. DO NOT EDIT.
+
+package subi
+
+import (
+	subdev "github.com/threefoldtech/substrate-client-dev"
+	submain "github.com/threefoldtech/substrate-client-main"
+	subqa "github.com/threefoldtech/substrate-client-qa"
+	subtest "github.com/threefoldtech/substrate-client-test"
+)
+
+// Networks lists the supported substrate versions in order of preference
+var Networks = []string{
+	"dev",
+	"qa",
+	"test",
+	"main",
+}
+
+// Managers maps each supported network to the constructor of its versioned manager
+var Managers = map[string]func(url ...string) Manager{
+	"dev":  NewDevManager,
+	"qa":   NewQAManager,
+	"test": NewTestManager,
+	"main": NewMainManager,
+}
+
+var solutionProviderSupport = map[string]bool{
+	"dev":  true,
+	"qa":   true,
+	"test": false,
+	"main": false,
+}
+
+var notFoundErrors = []error{
+	subdev.ErrNotFound,
+	subqa.ErrNotFound,
+	subtest.ErrNotFound,
+	submain.ErrNotFound,
+}
+
+var accountNotFoundErrors = []error{
+	subdev.ErrAccountNotFound,
+	subqa.ErrAccountNotFound,
+	subtest.ErrAccountNotFound,
+	submain.ErrAccountNotFound,
+}
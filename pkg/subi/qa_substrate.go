@@ -1,3 +1,5 @@
+// Code generated by pkg/subi/gen from networks.json. DO NOT EDIT.
+
 package subi
 
 import (
@@ -5,9 +7,51 @@ import (
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/substrate-client"
 	subqa "github.com/threefoldtech/substrate-client-qa"
 )
 
+type QAManager struct {
+	substrate.Manager
+	versioned subqa.Manager
+}
+
+func NewQAManager(url ...string) Manager {
+	return &QAManager{substrate.NewManager(url...), subqa.NewManager(url...)}
+}
+
+func (m *QAManager) SubstrateExt() (SubstrateExt, error) {
+	sub, err := m.versioned.Substrate()
+	return &SubstrateQAImpl{sub}, err
+}
+
+type QAContract struct {
+	*subqa.Contract
+}
+
+func (c *QAContract) IsDeleted() bool {
+	return c.Contract.State.IsDeleted
+}
+func (c *QAContract) IsCreated() bool {
+	return c.Contract.State.IsCreated
+}
+
+func (c *QAContract) TwinID() uint32 {
+	return uint32(c.Contract.TwinID)
+}
+
+func (c *QAContract) PublicIPCount() uint32 {
+	return uint32(c.Contract.ContractType.NodeContract.PublicIPsCount)
+}
+
+func (c *QAContract) NodeID() uint32 {
+	return uint32(c.Contract.ContractType.NodeContract.Node)
+}
+
+func (c *QAContract) DeploymentData() string {
+	return c.Contract.ContractType.NodeContract.DeploymentData
+}
+
 type SubstrateQAImpl struct {
 	*subqa.Substrate
 }
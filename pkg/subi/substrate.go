@@ -0,0 +1,42 @@
+package subi
+
+import (
+	"context"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/threefoldtech/substrate-client"
+)
+
+//go:generate go run ./gen
+
+type Manager interface {
+	substrate.Manager
+	SubstrateExt() (SubstrateExt, error)
+}
+
+type Substrate interface {
+	CancelContract(identity Identity, contractID uint64) error
+	CreateNodeContract(identity Identity, node uint32, body string, hash string, publicIPs uint32, solutionProviderID *uint64) (uint64, error)
+	UpdateNodeContract(identity Identity, contract uint64, body string, hash string) (uint64, error)
+	Close()
+	GetTwinByPubKey(pk []byte) (uint32, error)
+}
+type SubstrateExt interface {
+	Substrate
+	EnsureContractCanceled(identity Identity, contractID uint64) error
+	DeleteInvalidContracts(contracts map[uint32]uint64) error
+	IsValidContract(contractID uint64) (bool, error)
+	InvalidateNameContract(
+		ctx context.Context,
+		identity Identity,
+		contractID uint64,
+		name string,
+	) (uint64, error)
+	GetContract(id uint64) (Contract, error)
+	GetNodeTwin(id uint32) (uint32, error)
+	CreateNameContract(identity Identity, name string) (uint64, error)
+	GetAccount(identity Identity) (types.AccountInfo, error)
+	GetTwinIP(twinID uint32) (string, error)
+	GetContractIDByNameRegistration(name string) (uint64, error)
+	GetTwinPK(twinID uint32) ([]byte, error)
+}
@@ -1,3 +1,5 @@
+// Code generated by pkg/subi/gen from networks.json. DO NOT EDIT.
+
 package subi
 
 import (
@@ -5,9 +7,51 @@ import (
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/substrate-client"
 	subtest "github.com/threefoldtech/substrate-client-test"
 )
 
+type TestManager struct {
+	substrate.Manager
+	versioned subtest.Manager
+}
+
+func NewTestManager(url ...string) Manager {
+	return &TestManager{substrate.NewManager(url...), subtest.NewManager(url...)}
+}
+
+func (m *TestManager) SubstrateExt() (SubstrateExt, error) {
+	sub, err := m.versioned.Substrate()
+	return &SubstrateTestImpl{sub}, err
+}
+
+type TestContract struct {
+	*subtest.Contract
+}
+
+func (c *TestContract) IsDeleted() bool {
+	return c.Contract.State.IsDeleted
+}
+func (c *TestContract) IsCreated() bool {
+	return c.Contract.State.IsCreated
+}
+
+func (c *TestContract) TwinID() uint32 {
+	return uint32(c.Contract.TwinID)
+}
+
+func (c *TestContract) PublicIPCount() uint32 {
+	return uint32(c.Contract.ContractType.NodeContract.PublicIPsCount)
+}
+
+func (c *TestContract) NodeID() uint32 {
+	return uint32(c.Contract.ContractType.NodeContract.Node)
+}
+
+func (c *TestContract) DeploymentData() string {
+	return c.Contract.ContractType.NodeContract.DeploymentData
+}
+
 type SubstrateTestImpl struct {
 	*subtest.Substrate
 }
@@ -0,0 +1,54 @@
+package workloads
+
+import (
+	"net"
+
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+// zos gives every network resource a private ipv6 /64 and every workload in it an ipv6 address,
+// both derived from the ipv4 ones as fd<network id[0:5]>:<third octet>::<fourth octet>
+// so ipv6 addresses never need to be allocated separately
+
+// NetworkIP6Range returns the ULA /48 containing the ipv6 subnets of the network on all nodes
+func NetworkIP6Range(twinID uint32, network string) gridtypes.IPNet {
+	return gridtypes.NewIPNet(net.IPNet{
+		IP:   ip6Prefix(zos.NetworkID(twinID, gridtypes.Name(network))),
+		Mask: net.CIDRMask(48, 128),
+	})
+}
+
+// NodeIP6Range returns the ipv6 /64 of the network resource using the ipv4 subnet
+func NodeIP6Range(twinID uint32, network string, subnet gridtypes.IPNet) gridtypes.IPNet {
+	ip := ip6Prefix(zos.NetworkID(twinID, gridtypes.Name(network)))
+	ip[7] = subnet.IP.To4()[2]
+	return gridtypes.NewIPNet(net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(64, 128),
+	})
+}
+
+// PrivateIP6 returns the ipv6 of the workload with the private ipv4 ip in the network,
+// it's empty if ip isn't a valid ipv4
+func PrivateIP6(twinID uint32, network string, ip string) string {
+	ip4 := net.ParseIP(ip).To4()
+	if ip4 == nil {
+		return ""
+	}
+	return convert4to6(zos.NetworkID(twinID, gridtypes.Name(network)), ip4).String()
+}
+
+func convert4to6(netID zos.NetID, ip4 net.IP) net.IP {
+	ip := ip6Prefix(netID)
+	ip[7] = ip4[2]
+	ip[15] = ip4[3]
+	return ip
+}
+
+func ip6Prefix(netID zos.NetID) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfd
+	copy(ip[1:6], netID)
+	return ip
+}
@@ -0,0 +1,28 @@
+package workloads
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+func TestConvert4to6(t *testing.T) {
+	// the addresses zos derives for the network id networkdID
+	assert.Equal(t, net.ParseIP("fd6e:6574:776f:0000::2"), convert4to6("networkdID", net.ParseIP("100.127.0.2").To4()))
+	assert.Equal(t, net.ParseIP("fd6e:6574:776f:0002::0010"), convert4to6("networkdID", net.ParseIP("100.127.2.16").To4()))
+}
+
+func TestPrivateIP6(t *testing.T) {
+	ip := net.ParseIP(PrivateIP6(11, "network", "10.1.3.5"))
+	assert.NotNil(t, ip)
+	contains := func(r gridtypes.IPNet) bool {
+		return r.Contains(ip)
+	}
+	assert.True(t, contains(NodeIP6Range(11, "network", gridtypes.MustParseIPNet("10.1.3.0/24"))))
+	assert.True(t, contains(NetworkIP6Range(11, "network")))
+	assert.False(t, contains(NodeIP6Range(11, "network", gridtypes.MustParseIPNet("10.1.4.0/24"))))
+	assert.False(t, contains(NetworkIP6Range(11, "other")))
+	assert.Empty(t, PrivateIP6(11, "network", ""))
+}
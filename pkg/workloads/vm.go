@@ -29,6 +29,7 @@ type VM struct {
 	ComputedIP6   string
 	YggIP         string
 	IP            string
+	IP6           string
 	Description   string
 	Cpu           int
 	Memory        int
@@ -80,6 +81,7 @@ func NewVMFromSchema(vm map[string]interface{}) *VM {
 		YggIP:         vm["ygg_ip"].(string),
 		Planetary:     vm["planetary"].(bool),
 		IP:            vm["ip"].(string),
+		IP6:           vm["ip6"].(string),
 		Cpu:           vm["cpu"].(int),
 		Memory:        vm["memory"].(int),
 		RootfsSize:    vm["rootfs_size"].(int),
@@ -104,6 +106,13 @@ func NewVMFromWorkloads(wl *gridtypes.Workload, dl *gridtypes.Deployment) (VM, e
 		return VM{}, errors.Wrap(err, "failed to get vm result")
 	}
 
+	// zos only supports vms with a single private network for now, but the machines
+	// deployed by other clients shouldn't break reading the deployment
+	ip, networkName := "", ""
+	if len(data.Network.Interfaces) != 0 {
+		ip = data.Network.Interfaces[0].IP.String()
+		networkName = string(data.Network.Interfaces[0].Network)
+	}
 	pubip := pubIP(dl, data.Network.PublicIP)
 	var pubip4, pubip6 = "", ""
 	if !pubip.IP.Nil() {
@@ -124,7 +133,8 @@ func NewVMFromWorkloads(wl *gridtypes.Workload, dl *gridtypes.Deployment) (VM, e
 		Planetary:     result.YggIP != "",
 		Corex:         data.Corex,
 		YggIP:         result.YggIP,
-		IP:            data.Network.Interfaces[0].IP.String(),
+		IP:            ip,
+		IP6:           PrivateIP6(dl.TwinID, networkName, ip),
 		Cpu:           int(data.ComputeCapacity.CPU),
 		Memory:        int(data.ComputeCapacity.Memory / gridtypes.Megabyte),
 		RootfsSize:    int(data.Size / gridtypes.Megabyte),
@@ -132,7 +142,7 @@ func NewVMFromWorkloads(wl *gridtypes.Workload, dl *gridtypes.Deployment) (VM, e
 		Mounts:        mounts(data.Mounts),
 		Zlogs:         zlogs(dl, wl.Name.String()),
 		EnvVars:       data.Env,
-		NetworkName:   string(data.Network.Interfaces[0].Network),
+		NetworkName:   networkName,
 	}, nil
 }
 func mounts(mounts []zos.MachineMount) []Mount {
@@ -270,6 +280,7 @@ func (vm *VM) Dictify() map[string]interface{} {
 	res["computedip6"] = vm.ComputedIP6
 	res["ygg_ip"] = vm.YggIP
 	res["ip"] = vm.IP
+	res["ip6"] = vm.IP6
 	res["mounts"] = mounts
 	res["cpu"] = vm.Cpu
 	res["memory"] = vm.Memory
@@ -20,6 +20,7 @@ func vmInterface() map[string]interface{} {
 		"computedip":     "189.0.0.12/24",
 		"computedip6":    "::/64",
 		"ip":             "10.0.0.1",
+		"ip6":            "fd46:4447:7162::1",
 		"cpu":            1,
 		"description":    "des",
 		"memory":         1024,
@@ -58,6 +59,7 @@ func vmObj() *VM {
 		ComputedIP:    "189.0.0.12/24",
 		ComputedIP6:   "::/64",
 		IP:            "10.0.0.1",
+		IP6:           "fd46:4447:7162::1",
 		Cpu:           1,
 		Description:   "des",
 		Memory:        1024,
@@ -232,6 +234,29 @@ func TestNewVMFromWorkloads(t *testing.T) {
 	assert.Equal(t, *vm, wlVM)
 }
 
+func TestNewVMFromWorkloadsWithoutInterfaces(t *testing.T) {
+	wl := gridtypes.Workload{
+		Name: "vm",
+		Type: zos.ZMachineType,
+		Data: gridtypes.MustMarshal(zos.ZMachine{
+			FList:   "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+			Network: zos.MachineNetwork{Planetary: true},
+		}),
+		Result: gridtypes.Result{
+			State: gridtypes.StateOk,
+			Data:  json.RawMessage(`{"ygg_ip": "300::1"}`),
+		},
+	}
+	d := NewDeployment(11)
+	d.Workloads = []gridtypes.Workload{wl}
+	vm, err := NewVMFromWorkloads(&wl, &d)
+	assert.NoError(t, err)
+	assert.Empty(t, vm.IP)
+	assert.Empty(t, vm.IP6)
+	assert.Empty(t, vm.NetworkName)
+	assert.Equal(t, "300::1", vm.YggIP)
+}
+
 func TestMatch(t *testing.T) {
 	vm := vmObj().WithNetworkName("network")
 	wls := vm.GenerateVMWorkload()